@@ -0,0 +1,67 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package codec provides the framers to split the byte stream of netpoll.Connection into frames.
+//
+// The frames are decoded as zero-copy netpoll.Reader by Reader.Slice, and should be released after use.
+// The frame larger than the max frame size is treated as an attack or a broken peer,
+// so the connection is closed if the reader is a netpoll.Connection.
+package codec
+
+import (
+	"errors"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// DefaultMaxFrameSize is used if the MaxFrameSize of framers is not set.
+const DefaultMaxFrameSize = 4 * 1024 * 1024
+
+// ErrFrameTooLarge is returned if the frame is larger than the max frame size.
+var ErrFrameTooLarge = errors.New("codec: frame too large")
+
+// ErrInvalidFrame is returned if the frame header is malformed, e.g. a negative length.
+var ErrInvalidFrame = errors.New("codec: invalid frame")
+
+// Decoder splits the next frame from the reader.
+type Decoder interface {
+	// Decode returns the next frame as a zero-copy Reader, blocking as Reader.Next until the frame is complete.
+	Decode(r netpoll.Reader) (frame netpoll.Reader, err error)
+}
+
+// Encoder writes a frame to the writer, which is sent after submission(e.g. Flush).
+type Encoder interface {
+	Encode(w netpoll.Writer, payload []byte) (err error)
+}
+
+// Framer decodes and encodes frames in the same format.
+type Framer interface {
+	Decoder
+	Encoder
+}
+
+func maxFrameSize(size int) int {
+	if size <= 0 {
+		return DefaultMaxFrameSize
+	}
+	return size
+}
+
+// reject closes the peer sending the invalid frame, since the rest of stream can't be split any more.
+func reject(r netpoll.Reader, err error) error {
+	if conn, ok := r.(netpoll.Connection); ok {
+		conn.Close()
+	}
+	return err
+}
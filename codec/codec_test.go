@@ -0,0 +1,196 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+func TestLengthFieldFramer(t *testing.T) {
+	// magic(2) + length(2) + body, with the length of body in little endian.
+	var framer = &LengthFieldFramer{ByteOrder: binary.LittleEndian, LengthOffset: 2, LengthSize: 2}
+	var buf = netpoll.NewLinkBuffer()
+	MustNil(t, framer.Encode(buf, []byte("mghello")))
+	MustNil(t, framer.Encode(buf, []byte("mg")))
+	MustNil(t, buf.Flush())
+	Equal(t, string(buf.Bytes()), "mg\x05\x00hellomg\x00\x00")
+	frame, err := framer.Decode(buf)
+	MustNil(t, err)
+	Equal(t, readAll(t, frame), "mg\x05\x00hello")
+	frame, err = framer.Decode(buf)
+	MustNil(t, err)
+	Equal(t, readAll(t, frame), "mg\x00\x00")
+	_, err = framer.Decode(buf)
+	MustTrue(t, err != nil)
+
+	// the length includes itself, and is stripped.
+	framer = &LengthFieldFramer{LengthSize: 4, LengthAdjustment: -4, Strip: 4, MaxFrameSize: 16}
+	buf = netpoll.NewLinkBuffer()
+	MustNil(t, framer.Encode(buf, []byte("hello")))
+	MustNil(t, buf.Flush())
+	Equal(t, string(buf.Bytes()), "\x00\x00\x00\x09hello")
+	frame, err = framer.Decode(buf)
+	MustNil(t, err)
+	Equal(t, readAll(t, frame), "hello")
+
+	// invalid or too large.
+	Equal(t, framer.Encode(buf, make([]byte, 13)), ErrFrameTooLarge)
+	MustNil(t, buf.WriteUint32(3))
+	MustNil(t, buf.Flush())
+	_, err = framer.Decode(buf)
+	Equal(t, err, ErrInvalidFrame)
+	buf = netpoll.NewLinkBuffer()
+	MustNil(t, buf.WriteUint32(17))
+	MustNil(t, buf.Flush())
+	_, err = framer.Decode(buf)
+	Equal(t, err, ErrFrameTooLarge)
+	Equal(t, (&LengthFieldFramer{LengthSize: 1}).Encode(buf, make([]byte, 256)), ErrFrameTooLarge)
+}
+
+func TestVarintFramer(t *testing.T) {
+	var framer = &VarintFramer{MaxFrameSize: 300}
+	var buf = netpoll.NewLinkBuffer()
+	MustNil(t, framer.Encode(buf, []byte("hello")))
+	MustNil(t, framer.Encode(buf, make([]byte, 300)))
+	Equal(t, framer.Encode(buf, make([]byte, 301)), ErrFrameTooLarge)
+	MustNil(t, buf.Flush())
+	frame, err := framer.Decode(buf)
+	MustNil(t, err)
+	Equal(t, readAll(t, frame), "hello")
+	frame, err = framer.Decode(buf)
+	MustNil(t, err)
+	Equal(t, frame.Len(), 300)
+	MustNil(t, buf.WriteUvarint(301))
+	MustNil(t, buf.Flush())
+	_, err = framer.Decode(buf)
+	Equal(t, err, ErrFrameTooLarge)
+}
+
+func TestDelimiterFramer(t *testing.T) {
+	var framer = &DelimiterFramer{Delimiter: []byte("\r\n"), MaxFrameSize: 8}
+	var buf = netpoll.NewLinkBuffer()
+	MustNil(t, framer.Encode(buf, []byte("PING")))
+	Equal(t, framer.Encode(buf, []byte("a\r\nb")), ErrInvalidFrame)
+	Equal(t, framer.Encode(buf, []byte("1234567")), ErrFrameTooLarge)
+	_, err := buf.WriteString("+OK\r\n")
+	MustNil(t, err)
+	MustNil(t, buf.Flush())
+	frame, err := framer.Decode(buf)
+	MustNil(t, err)
+	Equal(t, readAll(t, frame), "PING\r\n")
+	framer.StripDelimiter = true
+	frame, err = framer.Decode(buf)
+	MustNil(t, err)
+	Equal(t, readAll(t, frame), "+OK")
+	Equal(t, buf.Len(), 0)
+
+	_, err = buf.WriteString("12345678\r\n")
+	MustNil(t, err)
+	MustNil(t, buf.Flush())
+	_, err = framer.Decode(buf)
+	Equal(t, err, ErrFrameTooLarge)
+}
+
+func TestFramerCloseOversizedPeer(t *testing.T) {
+	ln, err := net.Listen("tcp", ":18899")
+	MustNil(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// the frame arrives in pieces with the delimiter split, and then the oversized one.
+		conn.Write([]byte("hel"))
+		time.Sleep(10 * time.Millisecond)
+		conn.Write([]byte("lo\r"))
+		time.Sleep(10 * time.Millisecond)
+		conn.Write([]byte("\n"))
+		time.Sleep(10 * time.Millisecond)
+		conn.Write([]byte("0123456789"))
+		time.Sleep(time.Second)
+	}()
+
+	conn, err := netpoll.DialConnection("tcp", ":18899", time.Second)
+	MustNil(t, err)
+	var framer = &DelimiterFramer{Delimiter: []byte("\r\n"), MaxFrameSize: 8}
+	frame, err := framer.Decode(conn.Reader())
+	MustNil(t, err)
+	Equal(t, readAll(t, frame), "hello\r\n")
+	_, err = framer.Decode(conn.Reader())
+	Equal(t, err, ErrFrameTooLarge)
+	MustTrue(t, !conn.IsActive())
+}
+
+func TestFramerCloseOverflowedVarint(t *testing.T) {
+	ln, err := net.Listen("tcp", ":18898")
+	MustNil(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// the length prefix is longer than any uvarint.
+		conn.Write(bytes.Repeat([]byte{0xff}, 11))
+		time.Sleep(time.Second)
+	}()
+
+	conn, err := netpoll.DialConnection("tcp", ":18898", time.Second)
+	MustNil(t, err)
+	_, err = (&VarintFramer{}).Decode(conn.Reader())
+	Equal(t, err, ErrInvalidFrame)
+	MustTrue(t, !conn.IsActive())
+}
+
+func readAll(t *testing.T, r netpoll.Reader) string {
+	t.Helper()
+	s, err := r.ReadString(r.Len())
+	MustNil(t, err)
+	MustNil(t, r.Release())
+	return s
+}
+
+func MustNil(t *testing.T, val interface{}) {
+	t.Helper()
+	if val != nil {
+		t.Fatal("assertion nil failed, val=", val)
+	}
+}
+
+func MustTrue(t *testing.T, cond bool) {
+	t.Helper()
+	if !cond {
+		t.Fatal("assertion true failed.")
+	}
+}
+
+func Equal(t *testing.T, got, expect interface{}) {
+	t.Helper()
+	if got != expect {
+		t.Fatalf("assertion equal failed, got=[%v], expect=[%v]", got, expect)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/cloudwego/netpoll"
+)
+
+var _ Framer = &DelimiterFramer{}
+
+// DelimiterFramer splits the frames ended with the delimiter, e.g. "\r\n" of text protocols.
+type DelimiterFramer struct {
+	// Delimiter ends each frame, which can't be empty.
+	Delimiter []byte
+	// StripDelimiter strips the delimiter from the decoded frames.
+	StripDelimiter bool
+	// MaxFrameSize is the max size of frames including the delimiter, DefaultMaxFrameSize if not set.
+	MaxFrameSize int
+}
+
+// Decode implements Decoder.
+func (f *DelimiterFramer) Decode(r netpoll.Reader) (frame netpoll.Reader, err error) {
+	if len(f.Delimiter) == 0 {
+		return nil, errors.New("codec: empty delimiter")
+	}
+	var max = maxFrameSize(f.MaxFrameSize)
+	var i, from int
+	for i = index(r, f.Delimiter, from); i < 0; i = index(r, f.Delimiter, from) {
+		var l = r.Len()
+		if l >= max {
+			return nil, reject(r, ErrFrameTooLarge)
+		}
+		// wait for more data.
+		if _, err = r.Peek(l + 1); err != nil {
+			return nil, err
+		}
+		// resume from where the delimiter may start, which can span the data coming.
+		if from = l - len(f.Delimiter) + 1; from < 0 {
+			from = 0
+		}
+	}
+	var size = i + len(f.Delimiter)
+	if size > max {
+		return nil, reject(r, ErrFrameTooLarge)
+	}
+	if !f.StripDelimiter {
+		return r.Slice(size)
+	}
+	if frame, err = r.Slice(i); err != nil {
+		return nil, err
+	}
+	return frame, r.Skip(len(f.Delimiter))
+}
+
+// Encode implements Encoder, and the payload must not contain the delimiter.
+func (f *DelimiterFramer) Encode(w netpoll.Writer, payload []byte) (err error) {
+	if len(f.Delimiter) == 0 {
+		return errors.New("codec: empty delimiter")
+	}
+	if bytes.Contains(payload, f.Delimiter) {
+		return ErrInvalidFrame
+	}
+	if len(payload)+len(f.Delimiter) > maxFrameSize(f.MaxFrameSize) {
+		return ErrFrameTooLarge
+	}
+	if _, err = w.WriteBinary(payload); err != nil {
+		return err
+	}
+	_, err = w.WriteBinary(f.Delimiter)
+	return err
+}
+
+// index finds sep at or after from by DelimiterReader if r implements it, otherwise in the peeked bytes.
+func index(r netpoll.Reader, sep []byte, from int) (i int) {
+	if dr, ok := r.(netpoll.DelimiterReader); ok {
+		return dr.Index(sep, from)
+	}
+	var l = r.Len()
+	if l < from+len(sep) {
+		return -1
+	}
+	p, err := r.Peek(l)
+	if err != nil {
+		return -1
+	}
+	if i = bytes.Index(p[from:], sep); i < 0 {
+		return -1
+	}
+	return from + i
+}
@@ -0,0 +1,151 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/cloudwego/netpoll"
+)
+
+var _ Framer = &LengthFieldFramer{}
+
+// LengthFieldFramer splits the frames by the length field in the header, like Netty's LengthFieldBasedFrameDecoder.
+// The size of frame is LengthOffset + LengthSize + LengthAdjustment + the value of length field.
+//
+// For example, the frames of a 2 bytes magic number followed by a 4 bytes length of the body can be split by:
+//
+//	&LengthFieldFramer{LengthOffset: 2, LengthSize: 4}
+//
+// and the frames of a 4 bytes length including itself, with the length stripped, can be split by:
+//
+//	&LengthFieldFramer{LengthSize: 4, LengthAdjustment: -4, Strip: 4}
+type LengthFieldFramer struct {
+	// ByteOrder of the length field, binary.BigEndian if nil.
+	ByteOrder binary.ByteOrder
+	// LengthOffset is the offset of the length field in the frame.
+	LengthOffset int
+	// LengthSize is the size of the length field, which must be 1, 2, 4 or 8.
+	LengthSize int
+	// LengthAdjustment is added to the length field to get the size of the frame after the length field.
+	LengthAdjustment int
+	// Strip is the number of bytes stripped from the head of the decoded frames.
+	Strip int
+	// MaxFrameSize is the max size of frames before stripped, DefaultMaxFrameSize if not set.
+	MaxFrameSize int
+}
+
+// Decode implements Decoder.
+func (f *LengthFieldFramer) Decode(r netpoll.Reader) (frame netpoll.Reader, err error) {
+	var header = f.LengthOffset + f.LengthSize
+	p, err := r.Peek(header)
+	if err != nil {
+		return nil, err
+	}
+	length, err := f.getLength(p[f.LengthOffset:])
+	if err != nil {
+		return nil, err
+	}
+	var max = maxFrameSize(f.MaxFrameSize)
+	if length > uint64(max) {
+		return nil, reject(r, ErrFrameTooLarge)
+	}
+	var size = header + f.LengthAdjustment + int(length)
+	if size < header || size < f.Strip {
+		return nil, reject(r, ErrInvalidFrame)
+	}
+	if size > max {
+		return nil, reject(r, ErrFrameTooLarge)
+	}
+	if f.Strip > 0 {
+		if err = r.Skip(f.Strip); err != nil {
+			return nil, err
+		}
+	}
+	return r.Slice(size - f.Strip)
+}
+
+// Encode implements Encoder, which inserts the length field at LengthOffset of payload,
+// so payload[:LengthOffset] is the header before the length field.
+func (f *LengthFieldFramer) Encode(w netpoll.Writer, payload []byte) (err error) {
+	var length = len(payload) - f.LengthOffset - f.LengthAdjustment
+	if len(payload) < f.LengthOffset || length < 0 {
+		return ErrInvalidFrame
+	}
+	if len(payload)+f.LengthSize > maxFrameSize(f.MaxFrameSize) {
+		return ErrFrameTooLarge
+	}
+	// check the length field before writing anything.
+	var field [8]byte
+	if f.LengthSize <= 0 || f.LengthSize > len(field) {
+		return fmt.Errorf("codec: unsupported length size %d", f.LengthSize)
+	}
+	if err = f.putLength(field[:f.LengthSize], uint64(length)); err != nil {
+		return err
+	}
+	if f.LengthOffset > 0 {
+		if _, err = w.WriteBinary(payload[:f.LengthOffset]); err != nil {
+			return err
+		}
+	}
+	buf, err := w.Malloc(f.LengthSize)
+	if err != nil {
+		return err
+	}
+	copy(buf, field[:f.LengthSize])
+	_, err = w.WriteBinary(payload[f.LengthOffset:])
+	return err
+}
+
+func (f *LengthFieldFramer) byteOrder() binary.ByteOrder {
+	if f.ByteOrder == nil {
+		return binary.BigEndian
+	}
+	return f.ByteOrder
+}
+
+func (f *LengthFieldFramer) getLength(p []byte) (length uint64, err error) {
+	switch f.LengthSize {
+	case 1:
+		return uint64(p[0]), nil
+	case 2:
+		return uint64(f.byteOrder().Uint16(p)), nil
+	case 4:
+		return uint64(f.byteOrder().Uint32(p)), nil
+	case 8:
+		return f.byteOrder().Uint64(p), nil
+	}
+	return 0, fmt.Errorf("codec: unsupported length size %d", f.LengthSize)
+}
+
+func (f *LengthFieldFramer) putLength(p []byte, length uint64) (err error) {
+	if f.LengthSize < 8 && length >= 1<<(8*uint(f.LengthSize)) {
+		return ErrFrameTooLarge
+	}
+	switch f.LengthSize {
+	case 1:
+		p[0] = byte(length)
+	case 2:
+		f.byteOrder().PutUint16(p, uint16(length))
+	case 4:
+		f.byteOrder().PutUint32(p, uint32(length))
+	case 8:
+		f.byteOrder().PutUint64(p, length)
+	default:
+		return fmt.Errorf("codec: unsupported length size %d", f.LengthSize)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/cloudwego/netpoll"
+)
+
+var _ Framer = &VarintFramer{}
+
+// VarintFramer splits the frames prefixed by the length encoded as an uvarint, like protobuf's delimited messages.
+// The decoded frames don't contain the length prefix.
+type VarintFramer struct {
+	// MaxFrameSize is the max size of frames without the prefix, DefaultMaxFrameSize if not set.
+	MaxFrameSize int
+}
+
+// Decode implements Decoder.
+func (f *VarintFramer) Decode(r netpoll.Reader) (frame netpoll.Reader, err error) {
+	length, err := readUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(maxFrameSize(f.MaxFrameSize)) {
+		return nil, reject(r, ErrFrameTooLarge)
+	}
+	return r.Slice(int(length))
+}
+
+// Encode implements Encoder.
+func (f *VarintFramer) Encode(w netpoll.Writer, payload []byte) (err error) {
+	if len(payload) > maxFrameSize(f.MaxFrameSize) {
+		return ErrFrameTooLarge
+	}
+	if err = writeUvarint(w, uint64(len(payload))); err != nil {
+		return err
+	}
+	_, err = w.WriteBinary(payload)
+	return err
+}
+
+// readUvarint reads an uvarint by BinaryReader if r implements it, otherwise it peeks the bytes one by one.
+func readUvarint(r netpoll.Reader) (v uint64, err error) {
+	if br, ok := r.(netpoll.BinaryReader); ok {
+		if v, err = br.ReadUvarint(); errors.Is(err, netpoll.ErrVarintOverflow) {
+			return 0, reject(r, ErrInvalidFrame)
+		}
+		return v, err
+	}
+	for n := 1; n <= binary.MaxVarintLen64; n++ {
+		p, err := r.Peek(n)
+		if err != nil {
+			return 0, err
+		}
+		v, l := binary.Uvarint(p)
+		if l > 0 {
+			return v, r.Skip(l)
+		}
+		if l < 0 {
+			break
+		}
+	}
+	return 0, reject(r, ErrInvalidFrame)
+}
+
+// writeUvarint writes an uvarint by BinaryWriter if w implements it, otherwise it mallocs the bytes.
+func writeUvarint(w netpoll.Writer, v uint64) (err error) {
+	if bw, ok := w.(netpoll.BinaryWriter); ok {
+		return bw.WriteUvarint(v)
+	}
+	var p [binary.MaxVarintLen64]byte
+	var n = binary.PutUvarint(p[:], v)
+	buf, err := w.Malloc(n)
+	if err != nil {
+		return err
+	}
+	copy(buf, p[:n])
+	return nil
+}
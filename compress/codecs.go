@@ -0,0 +1,73 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compress
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+)
+
+// NewFlate returns the Codec of raw DEFLATE (RFC 1951) with the compression level of compress/flate.
+func NewFlate(level int) Codec {
+	return flateCodec{level: level}
+}
+
+// NewGzip returns the Codec of gzip (RFC 1952) with the compression level of compress/gzip.
+func NewGzip(level int) Codec {
+	return gzipCodec{level: level}
+}
+
+// NewZlib returns the Codec of zlib (RFC 1950) with the compression level of compress/zlib.
+func NewZlib(level int) Codec {
+	return zlibCodec{level: level}
+}
+
+type flateCodec struct {
+	level int
+}
+
+func (c flateCodec) NewCompressor(w io.Writer) (Compressor, error) {
+	return flate.NewWriter(w, c.level)
+}
+
+func (c flateCodec) NewDecompressor(r io.Reader) (io.Reader, error) {
+	return flate.NewReader(r), nil
+}
+
+type gzipCodec struct {
+	level int
+}
+
+func (c gzipCodec) NewCompressor(w io.Writer) (Compressor, error) {
+	return gzip.NewWriterLevel(w, c.level)
+}
+
+func (c gzipCodec) NewDecompressor(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+type zlibCodec struct {
+	level int
+}
+
+func (c zlibCodec) NewCompressor(w io.Writer) (Compressor, error) {
+	return zlib.NewWriterLevel(w, c.level)
+}
+
+func (c zlibCodec) NewDecompressor(r io.Reader) (io.Reader, error) {
+	return zlib.NewReader(r)
+}
@@ -0,0 +1,194 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package compress provides the netpoll.Reader and netpoll.Writer decorators that compress the byte stream.
+//
+// The Writer compresses the data written on Flush, and flushes the compressor so that the peer can decompress
+// all the data flushed. It's closed to finish the stream, e.g. to write the trailer of gzip.
+//
+// The Reader decompresses on demand into the nodes of its own LinkBuffer, so the slices returned by Next, Peek,
+// etc. are still zero-copy. Both of them take over the underlying Reader or Writer, which must not be used
+// directly any more.
+//
+// The codecs of the standard library are provided, and other pure-Go codecs can be used by implementing Codec.
+package compress
+
+import (
+	"errors"
+	"io"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// Codec creates the compressor and decompressor of a stream.
+type Codec interface {
+	// NewCompressor returns a Compressor writing the compressed data to w.
+	NewCompressor(w io.Writer) (Compressor, error)
+	// NewDecompressor returns a reader decompressing the data read from r, which is called on the first read,
+	// so it may read the header of the stream.
+	NewDecompressor(r io.Reader) (io.Reader, error)
+}
+
+// Compressor compresses the data written.
+type Compressor interface {
+	io.Writer
+	// Flush writes all the data compressed, so that the peer can decompress it without more data.
+	Flush() error
+	// Close flushes the data and finishes the stream, e.g. writes the trailer.
+	Close() error
+}
+
+// Writer is the netpoll.Writer compressing the data written.
+type Writer interface {
+	netpoll.Writer
+	// Close flushes the data written and finishes the stream, and the Writer can't be written any more.
+	// The underlying Writer is flushed but not closed.
+	Close() error
+}
+
+var errWriterClosed = errors.New("compress: writer closed")
+
+// NewReader returns a Reader that decompresses the data read from r by codec.
+func NewReader(r netpoll.Reader, codec Codec) netpoll.Reader {
+	return netpoll.NewReader(&decompressor{codec: codec, src: source{r}})
+}
+
+// NewWriter returns a Writer that compresses the data written to w by codec on Flush.
+func NewWriter(w netpoll.Writer, codec Codec) Writer {
+	var c = &compressor{codec: codec, dst: w}
+	return &writer{Writer: netpoll.NewWriter(c), c: c}
+}
+
+// writer finishes the stream of compressor on Close.
+type writer struct {
+	netpoll.Writer
+	c *compressor
+}
+
+// Close implements Writer.
+func (w *writer) Close() error {
+	if err := w.Writer.Flush(); err != nil {
+		return err
+	}
+	return w.c.close()
+}
+
+// decompressor creates the decompressor of codec lazily, since it may block to read the header.
+type decompressor struct {
+	codec Codec
+	src   source
+	r     io.Reader
+}
+
+// Read implements io.Reader, which is called by the Reader to fill its LinkBuffer.
+func (d *decompressor) Read(p []byte) (n int, err error) {
+	if d.r == nil {
+		if d.r, err = d.codec.NewDecompressor(d.src); err != nil {
+			return 0, err
+		}
+	}
+	return d.r.Read(p)
+}
+
+// compressor compresses the data flushed by the Writer into dst.
+type compressor struct {
+	codec  Codec
+	dst    netpoll.Writer
+	c      Compressor
+	closed bool
+}
+
+// Write implements io.Writer, which is called by the Writer with all the data on Flush.
+func (c *compressor) Write(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if c.closed {
+		return 0, errWriterClosed
+	}
+	if err = c.init(); err != nil {
+		return 0, err
+	}
+	if n, err = c.c.Write(p); err != nil {
+		return n, err
+	}
+	if err = c.c.Flush(); err != nil {
+		return n, err
+	}
+	return n, c.dst.Flush()
+}
+
+// init creates the Compressor of codec lazily, so the stream is not started until written or closed.
+func (c *compressor) init() (err error) {
+	if c.c == nil {
+		c.c, err = c.codec.NewCompressor(sink{c.dst})
+	}
+	return err
+}
+
+// close finishes the stream, which is valid even if nothing has been written.
+func (c *compressor) close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if err := c.init(); err != nil {
+		return err
+	}
+	if err := c.c.Close(); err != nil {
+		return err
+	}
+	return c.dst.Flush()
+}
+
+// source reads the compressed data from the Reader, and blocks only if there is no data.
+type source struct {
+	r netpoll.Reader
+}
+
+func (s source) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if s.r.Len() == 0 {
+		if _, err = s.r.Peek(1); err != nil {
+			if errors.Is(err, netpoll.ErrEOF) {
+				err = io.EOF
+			}
+			return 0, err
+		}
+	}
+	if n = s.r.Len(); n > len(p) {
+		n = len(p)
+	}
+	src, err := s.r.Next(n)
+	if err != nil {
+		return 0, err
+	}
+	n = copy(p, src)
+	return n, s.r.Release()
+}
+
+// sink writes the compressed data to the Writer, which is flushed after the compressor flushed.
+type sink struct {
+	w netpoll.Writer
+}
+
+func (s sink) Write(p []byte) (n int, err error) {
+	dst, err := s.w.Malloc(len(p))
+	if err != nil {
+		return 0, err
+	}
+	return copy(dst, p), nil
+}
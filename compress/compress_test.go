@@ -0,0 +1,117 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+
+	"github.com/cloudwego/netpoll"
+)
+
+func TestReaderWriter(t *testing.T) {
+	var codecs = []Codec{NewFlate(flate.BestSpeed), NewGzip(flate.DefaultCompression), NewZlib(flate.BestCompression)}
+	for _, codec := range codecs {
+		var buf = netpoll.NewLinkBuffer()
+		var w, r = NewWriter(buf, codec), NewReader(buf, codec)
+
+		// the data flushed can be read without the following data.
+		_, err := w.WriteString("hello")
+		MustNil(t, err)
+		MustNil(t, w.Flush())
+		MustTrue(t, buf.Len() > 0)
+		p, err := r.Next(5)
+		MustNil(t, err)
+		Equal(t, string(p), "hello")
+
+		// large data is decompressed into the nodes of the reader, and compressed.
+		var data = bytes.Repeat([]byte("netpoll"), 8192)
+		p, err = w.Malloc(len(data))
+		MustNil(t, err)
+		copy(p, data)
+		MustNil(t, w.Flush())
+		MustTrue(t, buf.Len() < len(data))
+		p, err = r.Next(len(data))
+		MustNil(t, err)
+		MustTrue(t, bytes.Equal(p, data))
+		MustNil(t, r.Release())
+		Equal(t, r.Len(), 0)
+		Equal(t, buf.Len(), 0)
+	}
+}
+
+func TestWriterClose(t *testing.T) {
+	var buf = netpoll.NewLinkBuffer()
+	var w = NewWriter(buf, NewGzip(gzip.DefaultCompression))
+	_, err := w.WriteString("hello")
+	MustNil(t, err)
+	MustNil(t, w.Close())
+	MustNil(t, w.Close())
+	_, err = w.WriteString("closed")
+	MustNil(t, err)
+	MustTrue(t, w.Flush() != nil)
+
+	// the trailer is written, so the stream is complete.
+	p, err := buf.Next(buf.Len())
+	MustNil(t, err)
+	gr, err := gzip.NewReader(bytes.NewReader(p))
+	MustNil(t, err)
+	data, err := ioutil.ReadAll(gr)
+	MustNil(t, err)
+	Equal(t, string(data), "hello")
+
+	// an empty stream is still valid.
+	w = NewWriter(buf, NewGzip(gzip.DefaultCompression))
+	MustNil(t, w.Close())
+	p, err = buf.Next(buf.Len())
+	MustNil(t, err)
+	gr, err = gzip.NewReader(bytes.NewReader(p))
+	MustNil(t, err)
+	data, err = ioutil.ReadAll(gr)
+	MustNil(t, err)
+	Equal(t, len(data), 0)
+}
+
+func TestReaderEOF(t *testing.T) {
+	var buf = netpoll.NewLinkBuffer()
+	var r = NewReader(buf, NewFlate(flate.BestSpeed))
+	MustNil(t, buf.Close())
+	_, err := r.Next(1)
+	MustTrue(t, err != nil)
+}
+
+func MustNil(t *testing.T, val interface{}) {
+	t.Helper()
+	if val != nil {
+		t.Fatal("assertion nil failed, val=", val)
+	}
+}
+
+func MustTrue(t *testing.T, cond bool) {
+	t.Helper()
+	if !cond {
+		t.Fatal("assertion true failed.")
+	}
+}
+
+func Equal(t *testing.T, got, expect interface{}) {
+	t.Helper()
+	if got != expect {
+		t.Fatalf("assertion equal failed, got=[%v], expect=[%v]", got, expect)
+	}
+}
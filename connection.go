@@ -69,6 +69,45 @@ type Connection interface {
 	AddCloseCallback(callback CloseCallback) error
 }
 
+// Aborter is an optional interface of Connection, which closes the connection abortively.
+// The Connection of netpoll implements Aborter.
+type Aborter interface {
+	// Abort closes the connection immediately with RST instead of FIN, so that it doesn't go through TIME_WAIT.
+	// The pending output is discarded, and the close callbacks are called as Close.
+	Abort() error
+}
+
+// AsyncFlusher is an optional interface of Connection, which flushes without waiting for the data sent.
+// The Connection of netpoll implements AsyncFlusher.
+type AsyncFlusher interface {
+	// FlushAsync flushes the written data like Flush, but returns at once without waiting for it to be sent,
+	// and the rest is sent by the poller. cb is called after all the data flushed has been sent,
+	// or with an error if the connection is closed before that. The callbacks of FlushAsync are called in order
+	// of the calls, and not called if FlushAsync returns an error. The data written by WriteFile is unsupported.
+	FlushAsync(cb func(err error)) error
+}
+
+// MemStatsReporter is an optional interface of Connection, which reports the memory of its buffers.
+// The Connection of netpoll implements MemStatsReporter.
+type MemStatsReporter interface {
+	// MemStats returns the memory allocated by the input and output buffers of the connection,
+	// including the buffers sliced from them and still not released.
+	MemStats() MemStats
+}
+
+// HalfCloser is an optional interface of Connection, which shuts down one direction of the connection.
+// The Connection of netpoll implements HalfCloser.
+type HalfCloser interface {
+	// CloseWrite sends all the pending output, and then shuts down the writing side of the connection,
+	// so that the peer reads EOF. The connection can still be read, but can't be written any more.
+	CloseWrite() error
+
+	// CloseRead shuts down the reading side of the connection, and the connection can still be written.
+	// The data in the input buffer can still be read, and then Reader returns ErrEOF.
+	// The connection is closed once both directions have been shut down, including by the peer if WithHalfClose.
+	CloseRead() error
+}
+
 // Conn extends net.Conn, but supports getting the conn's fd.
 type Conn interface {
 	net.Conn
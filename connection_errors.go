@@ -35,6 +35,16 @@ const (
 	ErrEOF = syscall.Errno(0x106)
 	// Write I/O buffer timeout, calling by Connection.Writer
 	ErrWriteTimeout = syscall.Errno(0x107)
+	// The task is rejected by the Executor
+	ErrTaskRejected = syscall.Errno(0x108)
+	// The pending output exceeds the limit
+	ErrOutputFull = syscall.Errno(0x109)
+	// The delimiter is not found within the max length
+	ErrTooLong = syscall.Errno(0x10A)
+	// The memory budget of buffers is exhausted
+	ErrBudgetExhausted = syscall.Errno(0x10B)
+	// The varint overflows a 64-bit integer
+	ErrVarintOverflow = syscall.Errno(0x10C)
 )
 
 const ErrnoMask = 0xFF
@@ -90,11 +100,16 @@ func (e *exception) Unwrap() error {
 
 // Errors defined in netpoll
 var errnos = [...]string{
-	ErrnoMask & ErrConnClosed:     "connection has been closed",
-	ErrnoMask & ErrReadTimeout:    "connection read timeout",
-	ErrnoMask & ErrDialTimeout:    "dial wait timeout",
-	ErrnoMask & ErrDialNoDeadline: "dial no deadline",
-	ErrnoMask & ErrUnsupported:    "netpoll dose not support",
-	ErrnoMask & ErrEOF:            "EOF",
-	ErrnoMask & ErrWriteTimeout:   "connection write timeout",
+	ErrnoMask & ErrConnClosed:      "connection has been closed",
+	ErrnoMask & ErrReadTimeout:     "connection read timeout",
+	ErrnoMask & ErrDialTimeout:     "dial wait timeout",
+	ErrnoMask & ErrDialNoDeadline:  "dial no deadline",
+	ErrnoMask & ErrUnsupported:     "netpoll dose not support",
+	ErrnoMask & ErrEOF:             "EOF",
+	ErrnoMask & ErrWriteTimeout:    "connection write timeout",
+	ErrnoMask & ErrTaskRejected:    "task rejected by executor",
+	ErrnoMask & ErrOutputFull:      "connection output is full",
+	ErrnoMask & ErrTooLong:         "delimiter not found within the max length",
+	ErrnoMask & ErrBudgetExhausted: "buffer memory budget exhausted",
+	ErrnoMask & ErrVarintOverflow:  "varint overflows a 64-bit integer",
 }
@@ -0,0 +1,250 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// asyncFlush tracks the FlushAsync calls waiting for their output to be sent, in the order of calls.
+type asyncFlush struct {
+	callers   sync.Mutex // serializes the callers of FlushAsync
+	committed uint64     // the bytes flushed to be sent, only changed with flushing locked
+
+	wmu     sync.Mutex // serializes the monitoring changes of write events
+	writing int32      // 1 if the write events are monitored, and the output is sent by the poller
+
+	mu      sync.Mutex
+	sent    uint64 // the bytes sent
+	queue   []flushWaiter
+	done    []flushWaiter // the waiters completed but not called
+	running bool          // whether the done waiters are being called
+}
+
+type flushWaiter struct {
+	target uint64 // completed when sent reaches target
+	cb     func(err error)
+	err    error
+}
+
+// FlushAsync implements AsyncFlusher.
+func (c *connection) FlushAsync(cb func(err error)) error {
+	var af = &c.asyncFlush
+	af.callers.Lock()
+	defer af.callers.Unlock()
+	if !c.IsActive() || !c.lock(flushing) {
+		return Exception(ErrConnClosed, "when flush")
+	}
+	defer c.unlockFlushing()
+	if c.isShut(shutWrite) {
+		return Exception(syscall.EPIPE, "when flush")
+	}
+	if atomic.LoadInt32(&c.files.size) > 0 {
+		return Exception(ErrUnsupported, "FlushAsync with files")
+	}
+	c.commitOutput()
+	var target = af.committed
+	var size = c.outputBuffer.Len()
+	if c.observer != nil {
+		c.observer.OnFlushStart(c, size)
+		cb = c.observeFlush(target, size, cb)
+	}
+	// send at once if the poller is not sending, and leave the rest to the poller.
+	if !c.isWriting() && !c.outputBuffer.IsEmpty() {
+		if err := c.sendOutput(); err != nil {
+			if c.observer != nil {
+				c.observer.OnFlushEnd(c, size-c.unsent(target), err)
+			}
+			return err
+		}
+	}
+	c.waitSent(target, cb)
+	if c.outputBuffer.IsEmpty() {
+		return nil
+	}
+	if err := c.startWriting(); err != nil {
+		return Exception(err, "when flush")
+	}
+	return nil
+}
+
+// isWriting returns whether the output is being sent by the poller.
+// It's only changed from false to true with flushing locked, so the locker can send the output directly if false.
+func (c *connection) isWriting() bool {
+	return atomic.LoadInt32(&c.asyncFlush.writing) == 1
+}
+
+// startWriting makes the poller send the output, which must be called with flushing locked.
+func (c *connection) startWriting() error {
+	var af = &c.asyncFlush
+	af.wmu.Lock()
+	defer af.wmu.Unlock()
+	if atomic.LoadInt32(&af.writing) == 1 {
+		return nil
+	}
+	if err := c.operator.Control(PollR2RW); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&af.writing, 1)
+	return nil
+}
+
+// stopWriting removes the monitoring of write events, and returns false if it's not removed
+// because the output is not drained, unless force.
+func (c *connection) stopWriting(force bool) bool {
+	var af = &c.asyncFlush
+	af.wmu.Lock()
+	defer af.wmu.Unlock()
+	// the output may be flushed after the poller found it drained.
+	if !force && c.hasOutput() {
+		return false
+	}
+	atomic.StoreInt32(&af.writing, 0)
+	c.operator.Control(PollRW2R)
+	return true
+}
+
+// hasFlushWaiters returns whether there are FlushAsync calls waiting for the output to be sent.
+func (c *connection) hasFlushWaiters() bool {
+	var af = &c.asyncFlush
+	af.mu.Lock()
+	defer af.mu.Unlock()
+	return len(af.queue) > 0
+}
+
+// commitOutput makes the written data readable to be sent, which must be called with flushing locked.
+func (c *connection) commitOutput() {
+	c.asyncFlush.committed += uint64(c.outputBuffer.MallocLen())
+	c.outputBuffer.Flush()
+}
+
+// unlockFlushing unlocks flushing, and calls the waiters of FlushAsync completed while it's locked.
+func (c *connection) unlockFlushing() {
+	c.unlock(flushing)
+	c.callFlushWaiters()
+}
+
+// waitSent calls cb after the output is sent to target, or the connection fails.
+func (c *connection) waitSent(target uint64, cb func(err error)) {
+	var af = &c.asyncFlush
+	af.mu.Lock()
+	var w = flushWaiter{target: target, cb: cb}
+	if len(af.queue) == 0 && af.sent >= target {
+		af.done = append(af.done, w)
+	} else {
+		af.queue = append(af.queue, w)
+	}
+	af.mu.Unlock()
+}
+
+// observeFlush wraps cb to report the output of FlushAsync sent, which may be sent later by the poller.
+func (c *connection) observeFlush(target uint64, size int, cb func(err error)) func(err error) {
+	return func(err error) {
+		c.observer.OnFlushEnd(c, size-c.unsent(target), err)
+		if cb != nil {
+			cb(err)
+		}
+	}
+}
+
+// unsent returns the bytes still not sent before target.
+func (c *connection) unsent(target uint64) int {
+	var af = &c.asyncFlush
+	af.mu.Lock()
+	defer af.mu.Unlock()
+	if af.sent >= target {
+		return 0
+	}
+	return int(target - af.sent)
+}
+
+// completeFlush completes the waiters of FlushAsync after n bytes are sent.
+func (c *connection) completeFlush(n int) {
+	var af = &c.asyncFlush
+	af.mu.Lock()
+	af.sent += uint64(n)
+	var i int
+	for i < len(af.queue) && af.queue[i].target <= af.sent {
+		i++
+	}
+	if i == 0 {
+		af.mu.Unlock()
+		return
+	}
+	af.done = append(af.done, af.queue[:i]...)
+	af.queue = af.queue[:copy(af.queue, af.queue[i:])]
+	af.mu.Unlock()
+	// the callbacks can't be called with flushing locked, otherwise they can't flush with an inline executor.
+	// The locker calls them when unlocking.
+	if c.isUnlock(flushing) {
+		c.callFlushWaiters()
+	}
+}
+
+// failFlush fails all the waiters of FlushAsync, since the connection is closed.
+func (c *connection) failFlush(err error) {
+	var af = &c.asyncFlush
+	af.mu.Lock()
+	if len(af.queue) == 0 {
+		af.mu.Unlock()
+		return
+	}
+	for i := range af.queue {
+		af.queue[i].err = err
+	}
+	af.done = append(af.done, af.queue...)
+	af.queue = nil
+	af.mu.Unlock()
+	c.callFlushWaiters()
+}
+
+// callFlushWaiters calls the completed waiters in order by a single task,
+// since they may be completed by the poller, which can't be blocked by the callbacks.
+func (c *connection) callFlushWaiters() {
+	var af = &c.asyncFlush
+	af.mu.Lock()
+	if af.running || len(af.done) == 0 {
+		af.mu.Unlock()
+		return
+	}
+	af.running = true
+	af.mu.Unlock()
+	var task = func() {
+		for {
+			af.mu.Lock()
+			var done = af.done
+			af.done = nil
+			if len(done) == 0 {
+				af.running = false
+				af.mu.Unlock()
+				return
+			}
+			af.mu.Unlock()
+			for i := range done {
+				if done[i].cb != nil {
+					done[i].cb(done[i].err)
+				}
+			}
+		}
+	}
+	if err := c.getExecutor().Execute(c.ctx, task); err != nil {
+		go task()
+	}
+}
@@ -18,16 +18,15 @@
 package netpoll
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 )
 
-const (
-	defaultZeroCopyTimeoutSec = 60
-)
-
 // connection is the implement of Connection
 type connection struct {
 	netFD
@@ -41,18 +40,55 @@ type connection struct {
 	writeTimeout    time.Duration
 	writeTimer      *time.Timer
 	writeTrigger    chan error
+	asyncFlush      asyncFlush
 	inputBuffer     *LinkBuffer
 	outputBuffer    *LinkBuffer
 	inputBarrier    *barrier
 	outputBarrier   *barrier
 	supportZeroCopy bool
+	zerocopy        zerocopy
+	files           outputFiles
+	observer        Observer
 	maxSize         int // The maximum size of data between two Release().
 	bookSize        int // The size of data that can be read at once.
-}
+	highWater       int // Reading is paused if the input buffer exceeds it, 0 means unlimited.
+	lowWater        int // Reading is resumed if the input buffer is drained below it.
+	outputBound     outputBound
+	halfClose       bool          // Whether the connection stays writable after the peer shuts down its writing.
+	shut            int32         // The directions that have been shut down.
+	eofNotified     int32         // 1 if OnRequest has been called for the EOF after the peer shut down.
+	aborted         int32         // 1 if the connection is reset on close by Abort.
+	closeTimeout    time.Duration // Close waits at most closeTimeout for the pending output to be sent.
+	account         *memAccount   // The memory of the buffers is allocated by and counted to it.
+	budgetWaiting   int32         // 1 if reading waits for the buffer budget to be available.
+	sizePolicy      SizePolicy    // The policy decides bookSize.
+	booked          int           // The size booked by the last inputs.
+	idleTimer       *time.Timer   // The timer to reset bookSize and maxSize after the connection has been idle.
+	reads           uint32        // The count of reads, to tell whether the connection has been idle.
+	idleReads       uint32        // The count of reads when the idle timer fired last time.
+	idling          int32         // 1 if the idle timer is stopped until the next read.
+	idleReset       bool          // The sizes have been reset after the connection was idle, until the tail is reset.
+}
+
+// the directions of connection shut down.
+const (
+	shutRead  int32 = 1 << iota // shut down by CloseRead
+	shutWrite                   // shut down by CloseWrite
+	shutPeer                    // shut down by the peer's writing, only if halfClose
+	shutEOF                     // the rest data has been read after shutPeer, so the reader gets EOF
+)
 
 var _ Connection = &connection{}
 var _ Reader = &connection{}
 var _ Writer = &connection{}
+var _ VectorReader = &connection{}
+var _ DelimiterReader = &connection{}
+var _ BinaryReader = &connection{}
+var _ BinaryWriter = &connection{}
+var _ AsyncFlusher = &connection{}
+var _ MemStatsReporter = &connection{}
+var _ Aborter = &connection{}
+var _ HalfCloser = &connection{}
 
 // Reader implements Connection.
 func (c *connection) Reader() Reader {
@@ -93,6 +129,11 @@ func (c *connection) SetWriteTimeout(timeout time.Duration) error {
 	return nil
 }
 
+// MemStats implements MemStatsReporter.
+func (c *connection) MemStats() MemStats {
+	return c.account.memStats()
+}
+
 // ------------------------------------------ implement zero-copy reader ------------------------------------------
 
 // Next implements Connection.
@@ -111,6 +152,22 @@ func (c *connection) Peek(n int) (buf []byte, err error) {
 	return c.inputBuffer.Peek(n)
 }
 
+// NextVectors implements VectorReader.
+func (c *connection) NextVectors(n int) (vs [][]byte, err error) {
+	if err = c.waitRead(n); err != nil {
+		return vs, err
+	}
+	return c.inputBuffer.NextVectors(n)
+}
+
+// PeekVectors implements VectorReader.
+func (c *connection) PeekVectors(n int) (vs [][]byte, err error) {
+	if err = c.waitRead(n); err != nil {
+		return vs, err
+	}
+	return c.inputBuffer.PeekVectors(n)
+}
+
 // Skip implements Connection.
 func (c *connection) Skip(n int) (err error) {
 	if err = c.waitRead(n); err != nil {
@@ -130,16 +187,20 @@ func (c *connection) Release() (err error) {
 			maxSize = mallocMax
 		}
 
-		if maxSize > c.maxSize {
+		// The data read before the idle reset may be still counted in the tail node, and isn't counted again.
+		if maxSize > c.maxSize && !c.idleReset {
 			c.maxSize = maxSize
 		}
 		// Double check length to reset tail node
 		if c.inputBuffer.Len() == 0 {
 			c.inputBuffer.resetTail(c.maxSize)
+			c.idleReset = false
 		}
 		c.operator.done()
 	}
-	return c.inputBuffer.Release()
+	err = c.inputBuffer.Release()
+	c.resumeRead()
+	return err
 }
 
 // Slice implements Connection.
@@ -175,6 +236,38 @@ func (c *connection) Until(delim byte) (line []byte, err error) {
 	}
 }
 
+// UntilBytes implements DelimiterReader.
+func (c *connection) UntilBytes(delim []byte, maxLen int) (line []byte, err error) {
+	var n, l int
+	for {
+		if err = c.waitRead(n + len(delim)); err != nil {
+			// return all the data in the buffer
+			line, _ = c.inputBuffer.Next(c.inputBuffer.Len())
+			return
+		}
+
+		l = c.inputBuffer.Len()
+		i := c.inputBuffer.indexBytes(delim, n)
+		if i < 0 {
+			if maxLen > 0 && l >= maxLen {
+				return nil, Exception(ErrTooLong, "when until bytes")
+			}
+			// continue from where the delim may start, which can span the data coming.
+			n = l - len(delim) + 1
+			continue
+		}
+		if maxLen > 0 && i+len(delim) > maxLen {
+			return nil, Exception(ErrTooLong, "when until bytes")
+		}
+		return c.Next(i + len(delim))
+	}
+}
+
+// Index implements DelimiterReader.
+func (c *connection) Index(sep []byte, from int) (i int) {
+	return c.inputBuffer.Index(sep, from)
+}
+
 // ReadString implements Connection.
 func (c *connection) ReadString(n int) (s string, err error) {
 	if err = c.waitRead(n); err != nil {
@@ -199,10 +292,72 @@ func (c *connection) ReadByte() (b byte, err error) {
 	return c.inputBuffer.ReadByte()
 }
 
+// ReadUint16 implements BinaryReader.
+func (c *connection) ReadUint16() (v uint16, err error) {
+	if err = c.waitRead(2); err != nil {
+		return v, err
+	}
+	return c.inputBuffer.ReadUint16()
+}
+
+// ReadUint32 implements BinaryReader.
+func (c *connection) ReadUint32() (v uint32, err error) {
+	if err = c.waitRead(4); err != nil {
+		return v, err
+	}
+	return c.inputBuffer.ReadUint32()
+}
+
+// ReadUint64 implements BinaryReader.
+func (c *connection) ReadUint64() (v uint64, err error) {
+	if err = c.waitRead(8); err != nil {
+		return v, err
+	}
+	return c.inputBuffer.ReadUint64()
+}
+
+// ReadUint16LE implements BinaryReader.
+func (c *connection) ReadUint16LE() (v uint16, err error) {
+	if err = c.waitRead(2); err != nil {
+		return v, err
+	}
+	return c.inputBuffer.ReadUint16LE()
+}
+
+// ReadUint32LE implements BinaryReader.
+func (c *connection) ReadUint32LE() (v uint32, err error) {
+	if err = c.waitRead(4); err != nil {
+		return v, err
+	}
+	return c.inputBuffer.ReadUint32LE()
+}
+
+// ReadUint64LE implements BinaryReader.
+func (c *connection) ReadUint64LE() (v uint64, err error) {
+	if err = c.waitRead(8); err != nil {
+		return v, err
+	}
+	return c.inputBuffer.ReadUint64LE()
+}
+
+// ReadUvarint implements BinaryReader.
+func (c *connection) ReadUvarint() (v uint64, err error) {
+	return readUvarint(c.inputBuffer, c.waitRead)
+}
+
+// ReadVarint implements BinaryReader.
+func (c *connection) ReadVarint() (v int64, err error) {
+	ux, err := c.ReadUvarint()
+	return unzigzag(ux), err
+}
+
 // ------------------------------------------ implement zero-copy writer ------------------------------------------
 
 // Malloc implements Connection.
 func (c *connection) Malloc(n int) (buf []byte, err error) {
+	if err = c.reserveOutput(n); err != nil {
+		return nil, err
+	}
 	return c.outputBuffer.Malloc(n)
 }
 
@@ -221,8 +376,11 @@ func (c *connection) Flush() error {
 	if !c.IsActive() || !c.lock(flushing) {
 		return Exception(ErrConnClosed, "when flush")
 	}
-	defer c.unlock(flushing)
-	c.outputBuffer.Flush()
+	defer c.unlockFlushing()
+	if c.isShut(shutWrite) {
+		return Exception(syscall.EPIPE, "when flush")
+	}
+	c.commitOutput()
 	return c.flush()
 }
 
@@ -233,29 +391,108 @@ func (c *connection) MallocAck(n int) (err error) {
 
 // Append implements Connection.
 func (c *connection) Append(w Writer) (err error) {
+	if err = c.reserveOutput(w.MallocLen()); err != nil {
+		return err
+	}
 	return c.outputBuffer.Append(w)
 }
 
 // WriteString implements Connection.
 func (c *connection) WriteString(s string) (n int, err error) {
+	if err = c.reserveOutput(len(s)); err != nil {
+		return 0, err
+	}
 	return c.outputBuffer.WriteString(s)
 }
 
 // WriteBinary implements Connection.
 func (c *connection) WriteBinary(b []byte) (n int, err error) {
+	if err = c.reserveOutput(len(b)); err != nil {
+		return 0, err
+	}
 	return c.outputBuffer.WriteBinary(b)
 }
 
 // WriteDirect implements Connection.
 func (c *connection) WriteDirect(p []byte, remainCap int) (err error) {
+	if err = c.reserveOutput(len(p)); err != nil {
+		return err
+	}
 	return c.outputBuffer.WriteDirect(p, remainCap)
 }
 
 // WriteByte implements Connection.
 func (c *connection) WriteByte(b byte) (err error) {
+	if err = c.reserveOutput(1); err != nil {
+		return err
+	}
 	return c.outputBuffer.WriteByte(b)
 }
 
+// WriteUint16 implements BinaryWriter.
+func (c *connection) WriteUint16(v uint16) (err error) {
+	if err = c.reserveOutput(2); err != nil {
+		return err
+	}
+	return c.outputBuffer.WriteUint16(v)
+}
+
+// WriteUint32 implements BinaryWriter.
+func (c *connection) WriteUint32(v uint32) (err error) {
+	if err = c.reserveOutput(4); err != nil {
+		return err
+	}
+	return c.outputBuffer.WriteUint32(v)
+}
+
+// WriteUint64 implements BinaryWriter.
+func (c *connection) WriteUint64(v uint64) (err error) {
+	if err = c.reserveOutput(8); err != nil {
+		return err
+	}
+	return c.outputBuffer.WriteUint64(v)
+}
+
+// WriteUint16LE implements BinaryWriter.
+func (c *connection) WriteUint16LE(v uint16) (err error) {
+	if err = c.reserveOutput(2); err != nil {
+		return err
+	}
+	return c.outputBuffer.WriteUint16LE(v)
+}
+
+// WriteUint32LE implements BinaryWriter.
+func (c *connection) WriteUint32LE(v uint32) (err error) {
+	if err = c.reserveOutput(4); err != nil {
+		return err
+	}
+	return c.outputBuffer.WriteUint32LE(v)
+}
+
+// WriteUint64LE implements BinaryWriter.
+func (c *connection) WriteUint64LE(v uint64) (err error) {
+	if err = c.reserveOutput(8); err != nil {
+		return err
+	}
+	return c.outputBuffer.WriteUint64LE(v)
+}
+
+// WriteUvarint implements BinaryWriter.
+func (c *connection) WriteUvarint(v uint64) (err error) {
+	if err = c.reserveOutput(uvarintLen(v)); err != nil {
+		return err
+	}
+	return c.outputBuffer.WriteUvarint(v)
+}
+
+// WriteVarint implements BinaryWriter.
+func (c *connection) WriteVarint(v int64) (err error) {
+	if err = c.reserveOutput(uvarintLen(zigzag(v))); err != nil {
+		return err
+	}
+	return c.outputBuffer.WriteVarint(v)
+}
+
 // ------------------------------------------ implement net.Conn ------------------------------------------
 
 // Read behavior is the same as net.Conn, it will return io.EOF if buffer is empty.
@@ -280,23 +517,131 @@ func (c *connection) Read(p []byte) (n int, err error) {
 
 // Write will Flush soon.
 func (c *connection) Write(p []byte) (n int, err error) {
+	if err = c.reserveOutput(len(p)); err != nil {
+		return 0, err
+	}
 	if !c.IsActive() || !c.lock(flushing) {
 		return 0, Exception(ErrConnClosed, "when write")
 	}
-	defer c.unlock(flushing)
+	defer c.unlockFlushing()
+	if c.isShut(shutWrite) {
+		return 0, Exception(syscall.EPIPE, "when write")
+	}
 
 	dst, _ := c.outputBuffer.Malloc(len(p))
 	n = copy(dst, p)
-	c.outputBuffer.Flush()
+	c.commitOutput()
 	err = c.flush()
 	return n, err
 }
 
+// WriteTo implements io.WriterTo, which writes the input data to w directly from the nodes of the input buffer,
+// until the connection is closed by the peer, and so io.Copy can move data without copy.
+//
+// Unlike the other read methods, WriteTo releases the input buffer after each write, so that a long stream
+// is not held in memory until it ends. The slices read by Next, Peek etc. before WriteTo are invalid after it,
+// as they are after Release.
+func (c *connection) WriteTo(w io.Writer) (n int64, err error) {
+	var m int64
+	for {
+		if err = c.waitRead(1); err != nil {
+			if errors.Is(err, ErrEOF) {
+				err = nil
+			}
+			return n, err
+		}
+		m, err = c.inputBuffer.WriteTo(w)
+		n += m
+		c.Release()
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// ReadFrom implements io.ReaderFrom, which reads from r into the output buffer directly and flushes it,
+// until io.EOF or an error occurs.
+func (c *connection) ReadFrom(r io.Reader) (n int64, err error) {
+	var buf []byte
+	var m int
+	for {
+		size := c.MallocLen()
+		if buf, err = c.Malloc(pagesize); err != nil {
+			return n, err
+		}
+		m, err = r.Read(buf)
+		if m < 0 || m > len(buf) {
+			m, err = 0, fmt.Errorf("connection read from invalid count[%d]", m)
+		}
+		c.MallocAck(size + m)
+		n += int64(m)
+		if c.MallocLen() > 0 {
+			if ferr := c.Flush(); ferr != nil {
+				return n, ferr
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return n, err
+		}
+	}
+}
+
 // Close implements Connection.
 func (c *connection) Close() error {
+	c.flushOnClose()
 	return c.onClose()
 }
 
+// Abort implements Aborter.
+func (c *connection) Abort() error {
+	atomic.StoreInt32(&c.aborted, 1)
+	return c.onClose()
+}
+
+// CloseRead implements HalfCloser.
+func (c *connection) CloseRead() error {
+	if !c.IsActive() {
+		return Exception(ErrConnClosed, "when close read")
+	}
+	if !c.shutdown(shutRead) {
+		return nil
+	}
+	// the connection can't be used any more if the writing has been shut down.
+	if c.isShut(shutWrite) {
+		return c.Close()
+	}
+	// stop reading first, otherwise the EOF after shutdown would be handled as a hang-up.
+	if err := c.operator.Control(PollPauseRead); err != nil {
+		return Exception(err, "when close read")
+	}
+	var err = syscall.Shutdown(c.fd, syscall.SHUT_RD)
+	c.triggerRead()
+	if err != nil {
+		return Exception(err, "when close read")
+	}
+	return nil
+}
+
+// CloseWrite implements HalfCloser.
+func (c *connection) CloseWrite() error {
+	if !c.IsActive() || !c.lock(flushing) {
+		return Exception(ErrConnClosed, "when close write")
+	}
+	var err = c.closeWrite()
+	c.unlockFlushing()
+	if err != nil {
+		return err
+	}
+	// the connection can't be used any more if the reading has been shut down.
+	if c.isShut(shutRead | shutEOF) {
+		return c.Close()
+	}
+	return nil
+}
+
 // ------------------------------------------ private ------------------------------------------
 
 var barrierPool = sync.Pool{
@@ -313,13 +658,29 @@ func (c *connection) init(conn Conn, opts *options) (err error) {
 	// init buffer, barrier, finalizer
 	c.readTrigger = make(chan struct{}, 1)
 	c.writeTrigger = make(chan error, 1)
-	c.bookSize, c.maxSize = block1k/2, pagesize
-	c.inputBuffer, c.outputBuffer = NewLinkBuffer(pagesize), NewLinkBuffer()
+	c.sizePolicy = defaultSizePolicy
+	if opts != nil {
+		c.account = newMemAccount(opts.allocator)
+		if opts.sizePolicy != nil {
+			c.sizePolicy = opts.sizePolicy
+		}
+	} else {
+		c.account = newMemAccount(nil)
+	}
+	c.bookSize, c.maxSize = c.sizePolicy.Initial(), pagesize
+	c.inputBuffer, c.outputBuffer = newLinkBuffer(c.account, pagesize), newLinkBuffer(c.account, 0)
 	c.inputBarrier, c.outputBarrier = barrierPool.Get().(*barrier), barrierPool.Get().(*barrier)
 
 	c.initNetFD(conn) // conn must be *netFD{}
+	if opts != nil {
+		if opts.manager != nil {
+			c.manager = opts.manager
+		}
+		c.halfClose = opts.halfClose
+	}
 	c.initFDOperator()
 	c.initFinalizer()
+	c.initIdleTimer()
 
 	syscall.SetNonblock(c.fd, true)
 	// enable TCP_NODELAY by default
@@ -327,10 +688,12 @@ func (c *connection) init(conn Conn, opts *options) (err error) {
 	case "tcp", "tcp4", "tcp6":
 		setTCPNoDelay(c.fd, true)
 	}
-	// check zero-copy
-	if setZeroCopy(c.fd) == nil && setBlockZeroCopySend(c.fd, defaultZeroCopyTimeoutSec, 0) == nil {
-		c.supportZeroCopy = true
+	// set SO_LINGER if required
+	if opts != nil && opts.lingerSet {
+		setLinger(c.fd, opts.linger)
 	}
+	// enable zero-copy if required
+	c.initZeroCopy(opts)
 
 	// connection initialized and prepare options
 	return c.onPrepare(opts)
@@ -354,13 +717,18 @@ func (c *connection) initFDOperator() {
 		// reuse operator created at connect step
 		op = c.pd.operator
 	} else {
-		poll := pollmanager.Pick()
+		poll := c.pollManager().Pick()
 		op = poll.Alloc()
 	}
 	op.FD = c.fd
 	op.OnRead, op.OnWrite, op.OnHup = nil, nil, c.onHup
+	op.OnRdHup = nil
+	if c.halfClose {
+		op.OnRdHup = c.onRdHup
+	}
 	op.Inputs, op.InputAck = c.inputs, c.inputAck
 	op.Outputs, op.OutputAck = c.outputs, c.outputAck
+	op.ZeroCopyAck, op.OutputFile = c.zeroCopyAck, c.outputFile
 
 	c.operator = op
 }
@@ -371,6 +739,12 @@ func (c *connection) initFinalizer() {
 		// stop the finalizing state to prevent conn.fill function to be performed
 		c.stop(finalizing)
 		c.operator.Free()
+		c.closeZeroCopy()
+		c.closeFiles()
+		if atomic.LoadInt32(&c.aborted) == 1 {
+			// discard the unsent data, and reset the connection by closing.
+			setLinger(c.fd, 0)
+		}
 		if err = c.netFD.Close(); err != nil {
 			logger.Printf("NETPOLL: netFD close failed: %v", err)
 		}
@@ -400,12 +774,17 @@ func (c *connection) waitRead(n int) (err error) {
 	}
 	atomic.StoreInt64(&c.waitReadSize, int64(n))
 	defer atomic.StoreInt64(&c.waitReadSize, 0)
+	// the data waited for may be beyond the high water mark.
+	c.resumeRead()
 	if c.readTimeout > 0 {
 		return c.waitReadWithTimeout(n)
 	}
 	// wait full n
 	for c.inputBuffer.Len() < n {
 		if c.IsActive() {
+			if c.isShut(shutRead | shutEOF) {
+				return Exception(ErrEOF, "wait read")
+			}
 			<-c.readTrigger
 			continue
 		}
@@ -438,6 +817,10 @@ func (c *connection) waitReadWithTimeout(n int) (err error) {
 			}
 			break
 		}
+		if c.isShut(shutRead | shutEOF) {
+			err = Exception(ErrEOF, "wait read")
+			break
+		}
 
 		select {
 		case <-c.readTimer.C:
@@ -445,6 +828,9 @@ func (c *connection) waitReadWithTimeout(n int) (err error) {
 			if c.inputBuffer.Len() >= n {
 				return nil
 			}
+			if c.observer != nil {
+				c.observer.OnReadTimeout(c)
+			}
 			return Exception(ErrReadTimeout, c.remoteAddr.String())
 		case <-c.readTrigger:
 			continue
@@ -458,6 +844,26 @@ func (c *connection) waitReadWithTimeout(n int) (err error) {
 	return err
 }
 
+// closeWrite sends the pending output before FIN, which must be called with flushing locked.
+func (c *connection) closeWrite() error {
+	if c.isShut(shutWrite) {
+		return nil
+	}
+	c.commitOutput()
+	if err := c.flush(); err != nil {
+		return err
+	}
+	c.shutdown(shutWrite)
+	if c.isShut(shutRead | shutEOF) {
+		// it will be closed directly.
+		return nil
+	}
+	if err := syscall.Shutdown(c.fd, syscall.SHUT_WR); err != nil {
+		return Exception(err, "when close write")
+	}
+	return nil
+}
+
 // fill data after connection is closed.
 func (c *connection) fill(need int) (err error) {
 	if !c.lock(finalizing) {
@@ -493,32 +899,74 @@ func (c *connection) fill(need int) (err error) {
 
 // flush write data directly.
 func (c *connection) flush() error {
-	if c.outputBuffer.IsEmpty() {
+	if !c.hasOutput() {
 		return nil
 	}
-	// TODO: Let the upper layer pass in whether to use ZeroCopy.
-	var bs = c.outputBuffer.GetBytes(c.outputBarrier.bs)
-	var n, err = sendmsg(c.fd, bs, c.outputBarrier.ivs, false && c.supportZeroCopy)
-	if err != nil && err != syscall.EAGAIN {
-		return Exception(err, "when flush")
+	if c.observer == nil {
+		return c.flushBuffer()
 	}
-	if n > 0 {
-		err = c.outputBuffer.Skip(n)
-		c.outputBuffer.Release()
-		if err != nil {
-			return Exception(err, "when flush")
+	var size = c.outputBuffer.Len()
+	c.observer.OnFlushStart(c, size)
+	var err = c.flushBuffer()
+	c.observer.OnFlushEnd(c, size-c.outputBuffer.Len(), err)
+	return err
+}
+
+// flushBuffer sends the output buffer and waits for the poller if it cannot be sent at once.
+func (c *connection) flushBuffer() error {
+	// the poller may be sending the output of FlushAsync, so it can't be sent directly.
+	for c.isWriting() && c.hasOutput() {
+		if err := c.waitFlush(); err != nil {
+			return err
 		}
 	}
+	if !c.hasOutput() {
+		return nil
+	}
+	if atomic.LoadInt32(&c.files.size) > 0 {
+		return c.flushFiles()
+	}
+	if err := c.sendOutput(); err != nil {
+		return err
+	}
 	// return if write all buffer.
 	if c.outputBuffer.IsEmpty() {
 		return nil
 	}
-	err = c.operator.Control(PollR2RW)
+	var err = c.startWriting()
 	if err != nil {
 		return Exception(err, "when flush")
 	}
+	// the trigger may be left by the poller finishing the output of FlushAsync.
+	for {
+		if err = c.waitFlush(); err != nil || c.outputBuffer.IsEmpty() {
+			return err
+		}
+	}
+}
 
-	return c.waitFlush()
+// sendOutput sends the output buffer once, which must be called with flushing locked.
+func (c *connection) sendOutput() error {
+	var bs = c.outputBuffer.GetBytes(c.outputBarrier.bs)
+	var zerocopy = c.useZeroCopy(c.outputBuffer.Len())
+	var n, err = sendmsg(c.fd, bs, c.outputBarrier.ivs, zerocopy)
+	if err == syscall.ENOBUFS && zerocopy {
+		// exceed the optmem limit of zerocopy, retry by copying.
+		zerocopy = false
+		bs = c.outputBuffer.GetBytes(c.outputBarrier.bs)
+		n, err = sendmsg(c.fd, bs, c.outputBarrier.ivs, zerocopy)
+	}
+	if err != nil && err != syscall.EAGAIN {
+		return Exception(err, "when flush")
+	}
+	if n > 0 {
+		err = c.skipOutput(n, zerocopy)
+		if err != nil {
+			return Exception(err, "when flush")
+		}
+		c.writable()
+	}
+	return nil
 }
 
 func (c *connection) waitFlush() (err error) {
@@ -551,7 +999,13 @@ func (c *connection) waitFlush() (err error) {
 		}
 		// if timeout, remove write event from poller
 		// we cannot flush it again, since we don't if the poller is still process outputBuffer
-		c.operator.Control(PollRW2R)
+		// The output of FlushAsync is still sent by the poller.
+		if !c.hasFlushWaiters() {
+			c.stopWriting(true)
+		}
+		if c.observer != nil {
+			c.observer.OnWriteTimeout(c)
+		}
 		return Exception(ErrWriteTimeout, c.remoteAddr.String())
 	}
 }
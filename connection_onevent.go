@@ -19,20 +19,11 @@ package netpoll
 
 import (
 	"context"
+	"runtime/debug"
 	"sync/atomic"
-
-	"github.com/bytedance/gopkg/util/gopool"
+	"time"
 )
 
-var runTask = gopool.CtxGo
-
-func disableGopool() error {
-	runTask = func(ctx context.Context, f func()) {
-		go f()
-	}
-	return nil
-}
-
 // ------------------------------------ implement OnPrepare, OnRequest, CloseCallback ------------------------------------
 
 type gracefulExit interface {
@@ -45,6 +36,7 @@ type gracefulExit interface {
 // which is a CAS lock and can only be cleared by OnRequest.
 type onEvent struct {
 	ctx               context.Context
+	executor          Executor
 	onConnectCallback atomic.Value
 	onRequestCallback atomic.Value
 	closeCallbacks    atomic.Value // value is latest *callbackNode
@@ -94,12 +86,23 @@ func (c *connection) AddCloseCallback(callback CloseCallback) error {
 // connection will be registered by this call after preparing.
 func (c *connection) onPrepare(opts *options) (err error) {
 	if opts != nil {
+		c.observer = opts.observer
+		c.executor = opts.executor
+		c.highWater, c.lowWater = opts.highWater, opts.lowWater
+		c.outputBound = opts.outputBound
+		if c.outputBound.max > 0 {
+			c.outputBound.drained = make(chan struct{}, 1)
+		}
+		c.closeTimeout = opts.closeTimeout
 		c.SetOnConnect(opts.onConnect)
 		c.SetOnRequest(opts.onRequest)
 		c.SetReadTimeout(opts.readTimeout)
 		c.SetWriteTimeout(opts.writeTimeout)
 		c.SetIdleTimeout(opts.idleTimeout)
 
+		if c.observer != nil {
+			c.observer.OnPrepare(c)
+		}
 		// calling prepare first and then register.
 		if opts.onPrepare != nil {
 			c.ctx = opts.onPrepare(c)
@@ -132,15 +135,18 @@ func (c *connection) onConnect() {
 				return true
 			}
 			// check for onRequest
-			return onRequest != nil && c.Reader().Len() > 0
+			return onRequest != nil && (c.Reader().Len() > 0 || c.notifyEOF())
 		},
 		func(c *connection) {
 			if atomic.CompareAndSwapInt32(&connected, 0, 1) {
+				if c.observer != nil {
+					c.observer.OnConnect(c)
+				}
 				c.ctx = onConnect(c.ctx, c)
 				return
 			}
 			if onRequest != nil {
-				_ = onRequest(c.ctx, c)
+				c.handleRequest(onRequest)
 			}
 		},
 	)
@@ -153,18 +159,31 @@ func (c *connection) onRequest() (needTrigger bool) {
 		return true
 	}
 	processed := c.onProcess(
-		// only process when conn active and have unread data
+		// only process when conn active and have unread data, or the EOF has not been seen
 		func(c *connection) bool {
-			return c.Reader().Len() > 0
+			return c.Reader().Len() > 0 || c.notifyEOF()
 		},
 		func(c *connection) {
-			_ = onRequest(c.ctx, c)
+			c.handleRequest(onRequest)
 		},
 	)
 	// if not processed, should trigger read
 	return !processed
 }
 
+// handleRequest executes onRequest once and notifies the observer.
+func (c *connection) handleRequest(onRequest OnRequest) {
+	// the input may be drained without Release.
+	defer c.resumeRead()
+	if c.observer == nil {
+		_ = onRequest(c.ctx, c)
+		return
+	}
+	c.observer.OnRequestStart(c)
+	var err = onRequest(c.ctx, c)
+	c.observer.OnRequestEnd(c, err)
+}
+
 // onProcess is responsible for executing the process function serially,
 // and make sure the connection has been closed correctly if user call c.Close() in process function.
 func (c *connection) onProcess(isProcessable func(c *connection) bool, process func(c *connection)) (processed bool) {
@@ -175,8 +194,13 @@ func (c *connection) onProcess(isProcessable func(c *connection) bool, process f
 	if !c.lock(processing) {
 		return false
 	}
+	// executing is 1 until Execute returns, which means that the task may be run by the caller, e.g. the poller.
+	var executing int32 = 1
 	// add new task
 	var task = func() {
+		if safeMode {
+			defer safeCatch(debug.SetPanicOnFault(true))
+		}
 	START:
 		// `process` must be executed at least once if `isProcessable` in order to cover the `send & close by peer` case.
 		// Then the loop processing must ensure that the connection `IsActive`.
@@ -188,6 +212,11 @@ func (c *connection) onProcess(isProcessable func(c *connection) bool, process f
 		}
 		// Handling callback if connection has been closed.
 		if !c.IsActive() {
+			// the poller can't free the operator it is handling, so leave the closeCallback to a new goroutine.
+			if atomic.LoadInt32(&executing) == 1 {
+				go c.closeCallback(false)
+				return
+			}
 			c.closeCallback(false)
 			return
 		}
@@ -200,10 +229,44 @@ func (c *connection) onProcess(isProcessable func(c *connection) bool, process f
 		return
 	}
 
-	runTask(c.ctx, task)
+	var err = c.getExecutor().Execute(c.ctx, task)
+	atomic.StoreInt32(&executing, 0)
+	if err != nil {
+		// the task is not run, shed the connection without flushing, since the caller may be the poller.
+		// processing is still held, so the poller can't free the operator it is handling,
+		// and the closeCallback is left to a new goroutine as the task does.
+		logRejected(err)
+		c.onClose()
+		go c.closeCallback(false)
+	}
 	return true
 }
 
+// rejected limits the logs of the tasks failed to execute to one per second,
+// which would flood when the executor is overloaded.
+var rejected struct {
+	last  int64  // the unix nano of the last log
+	count uint64 // the number of failed tasks since the last log
+}
+
+func logRejected(err error) {
+	atomic.AddUint64(&rejected.count, 1)
+	var now, last = time.Now().UnixNano(), atomic.LoadInt64(&rejected.last)
+	if now-last < int64(time.Second) || !atomic.CompareAndSwapInt64(&rejected.last, last, now) {
+		return
+	}
+	var n = atomic.SwapUint64(&rejected.count, 0)
+	logger.Printf("NETPOLL: execute %d tasks failed, close connections: %v", n, err)
+}
+
+// getExecutor returns the Executor to run the tasks of connection.
+func (c *connection) getExecutor() Executor {
+	if c.executor != nil {
+		return c.executor
+	}
+	return defaultExecutor
+}
+
 // closeCallback .
 // It can be confirmed that closeCallback and onRequest will not be executed concurrently.
 // If onRequest is still running, it will trigger closeCallback on exit.
@@ -224,6 +287,9 @@ func (c *connection) closeCallback(needLock bool) (err error) {
 	for callback := latest.(*callbackNode); callback != nil; callback = callback.pre {
 		callback.fn(c)
 	}
+	if c.observer != nil {
+		c.observer.OnCloseCallback(c)
+	}
 	return nil
 }
 
@@ -249,5 +315,5 @@ func (c *connection) register() (err error) {
 func (c *connection) isIdle() (yes bool) {
 	return c.isUnlock(processing) &&
 		c.inputBuffer.IsEmpty() &&
-		c.outputBuffer.IsEmpty()
+		!c.hasOutput()
 }
@@ -0,0 +1,177 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// outputBound limits the pending output of connection, which is set by WithOutputLimit.
+type outputBound struct {
+	max        int // 0 means unlimited
+	low        int
+	failFast   bool
+	onWritable OnWritable
+	exceeded   int32 // 1 if a write exceeded max, and OnWritable is waiting for the output drained
+	waiting    int32 // 1 if a write is waiting for the output drained
+	drained    chan struct{}
+}
+
+// reserveOutput checks whether n bytes can be written without exceeding the limit.
+// Otherwise, it fails fast, or waits for the flushed output drained to the low water mark.
+// If the buffer budget is exhausted, it fails fast as well, or waits for the budget available.
+func (c *connection) reserveOutput(n int) error {
+	if budget.exhausted() {
+		if c.outputBound.failFast {
+			return Exception(ErrBudgetExhausted, "when write")
+		}
+		if err := c.waitWriteBudget(); err != nil {
+			return err
+		}
+	}
+	var bound = &c.outputBound
+	if bound.max <= 0 {
+		return nil
+	}
+	var pending = c.outputBuffer.Len() + c.outputBuffer.MallocLen()
+	if pending == 0 || pending+n <= bound.max {
+		return nil
+	}
+	atomic.StoreInt32(&bound.exceeded, 1)
+	if bound.failFast {
+		return Exception(ErrOutputFull, "when write")
+	}
+	return c.waitDrained()
+}
+
+// waitDrained waits for the flushed output sent by the poller until it's drained to the low water mark,
+// at most writeTimeout. The output malloced but not flushed is left to the caller.
+func (c *connection) waitDrained() error {
+	var bound = &c.outputBound
+	if c.outputBuffer.Len() <= bound.low {
+		return nil
+	}
+	atomic.StoreInt32(&bound.waiting, 1)
+	defer atomic.StoreInt32(&bound.waiting, 0)
+	// the poller may have stopped sending the flushed output after the last flush timed out.
+	if !c.isWriting() && c.lock(flushing) {
+		var err = c.startWriting()
+		c.unlockFlushing()
+		if err != nil {
+			return Exception(err, "when write")
+		}
+	}
+	var timeout <-chan time.Time
+	if c.writeTimeout > 0 {
+		var timer = time.NewTimer(c.writeTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for c.outputBuffer.Len() > bound.low {
+		if !c.IsActive() {
+			return Exception(ErrConnClosed, "when write")
+		}
+		select {
+		case <-bound.drained:
+		case <-timeout:
+			return Exception(ErrWriteTimeout, c.remoteAddr.String())
+		}
+	}
+	return nil
+}
+
+// waitWriteBudget waits for the buffer budget available, at most writeTimeout.
+func (c *connection) waitWriteBudget() error {
+	var available = make(chan struct{}, 1)
+	var timeout <-chan time.Time
+	if c.writeTimeout > 0 {
+		var timer = time.NewTimer(c.writeTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for !budget.wait(func() {
+		select {
+		case available <- struct{}{}:
+		default:
+		}
+	}) {
+		select {
+		case <-available:
+		case <-timeout:
+			return Exception(ErrWriteTimeout, "when write")
+		}
+		if !c.IsActive() {
+			return Exception(ErrConnClosed, "when write")
+		}
+	}
+	return nil
+}
+
+// writable calls OnWritable if a write exceeded the limit and the output has been drained to the low water mark.
+func (c *connection) writable() {
+	var bound = &c.outputBound
+	if atomic.LoadInt32(&bound.exceeded) == 0 || c.outputBuffer.Len() > bound.low {
+		return
+	}
+	if atomic.LoadInt32(&bound.waiting) == 1 {
+		c.triggerDrained()
+	}
+	if !atomic.CompareAndSwapInt32(&bound.exceeded, 1, 0) || bound.onWritable == nil {
+		return
+	}
+	var onWritable = bound.onWritable
+	var task = func() {
+		_ = onWritable(c.ctx, c)
+	}
+	if err := c.getExecutor().Execute(c.ctx, task); err != nil {
+		go task()
+	}
+}
+
+func (c *connection) triggerDrained() {
+	select {
+	case c.outputBound.drained <- struct{}{}:
+	default:
+	}
+}
+
+// flushOnClose waits for the pending output to be sent by the poller before closing, at most closeTimeout.
+// The output still unsent after the timeout is discarded, and reported to the Observer by closeBuffer.
+func (c *connection) flushOnClose() {
+	if c.closeTimeout <= 0 || !c.IsActive() || !c.lock(flushing) {
+		return
+	}
+	defer c.unlockFlushing()
+	if !c.hasOutput() {
+		return
+	}
+	// the write event may have been removed by the timeout of the last flush.
+	if err := c.startWriting(); err != nil {
+		return
+	}
+	var timer = time.NewTimer(c.closeTimeout)
+	defer timer.Stop()
+	for c.IsActive() && c.hasOutput() {
+		select {
+		case <-c.writeTrigger:
+		case <-timer.C:
+			return
+		}
+	}
+}
@@ -19,6 +19,8 @@ package netpoll
 
 import (
 	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 // ------------------------------------------ implement FDOperator ------------------------------------------
@@ -26,8 +28,13 @@ import (
 // onHup means close by poller.
 func (c *connection) onHup(p Poll) error {
 	if c.closeBy(poller) {
+		if c.observer != nil {
+			c.observer.OnHup(c)
+		}
 		c.triggerRead()
 		c.triggerWrite(ErrConnClosed)
+		c.triggerDrained()
+		c.failFlush(Exception(ErrConnClosed, "when flush"))
 		// It depends on closing by user if OnConnect and OnRequest is nil, otherwise it needs to be released actively.
 		// It can be confirmed that the OnRequest goroutine has been exited before closecallback executing,
 		// and it is safe to close the buffer at this time.
@@ -40,11 +47,74 @@ func (c *connection) onHup(p Poll) error {
 	return nil
 }
 
+// onRdHup means the peer has shut down its writing, which is only set if halfClose.
+// The rest data is read before EOF, and then the connection stops reading but is still writable.
+func (c *connection) onRdHup(p Poll) error {
+	if !c.shutdown(shutPeer) {
+		return nil
+	}
+	// stop reading first, since the EOF is always readable.
+	if err := c.operator.Control(PollPauseRead); err != nil {
+		logger.Printf("NETPOLL: connection pause read failed: %v", err)
+	}
+	var bs = c.inputs(c.inputBarrier.bs)
+	for {
+		var n, err = readv(c.fd, bs, c.inputBarrier.ivs)
+		if err == syscall.EINTR {
+			// reuse bs that has been booked, otherwise will mess the input buffer
+			continue
+		}
+		c.inputAck(n)
+		if n <= 0 || err != nil {
+			break
+		}
+		bs = c.inputs(c.inputBarrier.bs)
+	}
+	c.shutdown(shutEOF)
+	c.triggerRead()
+	// notify OnRequest of the EOF.
+	c.onRequest()
+	if c.isShut(shutWrite) {
+		// the poller can't free the operator it is handling, so close in a new goroutine.
+		go c.Close()
+	}
+	return nil
+}
+
+// shutdown marks the direction shut down, and returns false if it has been marked.
+func (c *connection) shutdown(how int32) bool {
+	for {
+		var shut = atomic.LoadInt32(&c.shut)
+		if shut&how != 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&c.shut, shut, shut|how) {
+			return true
+		}
+	}
+}
+
+// isShut checks whether any of the directions has been shut down.
+func (c *connection) isShut(how int32) bool {
+	return atomic.LoadInt32(&c.shut)&how != 0
+}
+
+// notifyEOF returns true only once after the peer shut down its writing,
+// so that OnRequest is called to see the EOF even if there is no data.
+func (c *connection) notifyEOF() bool {
+	return c.isShut(shutEOF) && atomic.CompareAndSwapInt32(&c.eofNotified, 0, 1)
+}
+
 // onClose means close by user.
 func (c *connection) onClose() error {
 	if c.closeBy(user) {
+		if c.observer != nil {
+			c.observer.OnClose(c)
+		}
 		c.triggerRead()
 		c.triggerWrite(ErrConnClosed)
+		c.triggerDrained()
+		c.failFlush(Exception(ErrConnClosed, "when flush"))
 		c.closeCallback(true)
 		return nil
 	}
@@ -58,6 +128,9 @@ func (c *connection) onClose() error {
 
 // closeBuffer recycle input & output LinkBuffer.
 func (c *connection) closeBuffer() {
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
 	var onConnect, _ = c.onConnectCallback.Load().(OnConnect)
 	var onRequest, _ = c.onRequestCallback.Load().(OnRequest)
 	// if client close the connection, we cannot ensure that the poller is not process the buffer,
@@ -66,15 +139,24 @@ func (c *connection) closeBuffer() {
 		c.inputBuffer.Close()
 		barrierPool.Put(c.inputBarrier)
 	}
+	// the output is lost if the flushing on close has timed out, or the connection has been closed by the peer.
+	if n := c.outputBuffer.Len(); n > 0 && c.closeTimeout > 0 && atomic.LoadInt32(&c.aborted) == 0 && c.observer != nil {
+		c.observer.OnOutputLost(c, n)
+	}
 	if c.outputBuffer.Len() == 0 || onConnect != nil || onRequest != nil {
 		c.outputBuffer.Close()
 		barrierPool.Put(c.outputBarrier)
 	}
+	// the memory of the buffers given up to GC is no longer in use.
+	if c.inputBuffer.Len() != 0 || c.outputBuffer.Len() != 0 {
+		c.account.detach()
+	}
 }
 
 // inputs implements FDOperator.
 func (c *connection) inputs(vs [][]byte) (rs [][]byte) {
-	vs[0] = c.inputBuffer.book(c.bookSize, c.maxSize)
+	vs[0] = c.inputBuffer.book(c.readSize(), c.maxSize)
+	c.booked = len(vs[0])
 	return vs[:1]
 }
 
@@ -85,10 +167,11 @@ func (c *connection) inputAck(n int) (err error) {
 		return nil
 	}
 
-	// Auto size bookSize.
-	if n == c.bookSize && c.bookSize < mallocMax {
-		c.bookSize <<= 1
+	// Auto size bookSize, unless the read has been limited by the tail node or the high water mark.
+	if c.booked == c.bookSize {
+		c.bookSize = c.sizePolicy.Next(c.bookSize, n)
 	}
+	c.wakeIdle()
 
 	length, _ := c.inputBuffer.bookAck(n)
 	if c.maxSize < length {
@@ -97,6 +180,7 @@ func (c *connection) inputAck(n int) (err error) {
 	if c.maxSize > mallocMax {
 		c.maxSize = mallocMax
 	}
+	c.pauseRead(length)
 
 	var needTrigger = true
 	if length == n { // first start onRequest
@@ -108,30 +192,166 @@ func (c *connection) inputAck(n int) (err error) {
 	return nil
 }
 
+// readSize returns the size to read at once, which is limited by the high water mark,
+// so that the input buffer won't exceed it too much.
+func (c *connection) readSize() int {
+	if c.highWater <= 0 {
+		return c.bookSize
+	}
+	var length = c.inputBuffer.Len()
+	var limit = c.highWater - length
+	if wait := int(atomic.LoadInt64(&c.waitReadSize)) - length; wait > limit {
+		limit = wait
+	}
+	if limit < block1k/2 {
+		limit = block1k / 2
+	}
+	if limit < c.bookSize {
+		return limit
+	}
+	return c.bookSize
+}
+
+// pauseRead stops reading if the input buffer exceeds the high water mark, or the buffer budget is exhausted,
+// unless the reader is waiting for more.
+func (c *connection) pauseRead(length int) {
+	if c.operator.isPaused() {
+		return
+	}
+	if (c.highWater <= 0 || length < c.highWater) && !budget.exhausted() {
+		return
+	}
+	if length < int(atomic.LoadInt64(&c.waitReadSize)) {
+		return
+	}
+	if err := c.operator.Control(PollPauseRead); err != nil {
+		logger.Printf("NETPOLL: connection pause read failed: %v", err)
+		return
+	}
+	// the input may have been drained or waited for before paused.
+	c.resumeRead()
+}
+
+// resumeRead restarts reading if the input buffer has been drained below the low water mark
+// and the buffer budget is available, or the reader is waiting for more data.
+func (c *connection) resumeRead() {
+	if !c.operator.isPaused() || !c.IsActive() || c.isShut(shutRead|shutPeer) {
+		return
+	}
+	var length = c.inputBuffer.Len()
+	var wait = int(atomic.LoadInt64(&c.waitReadSize))
+	if length > c.lowWater && length >= wait {
+		return
+	}
+	if length >= wait && !c.waitBudget() {
+		return
+	}
+	if err := c.operator.Control(PollResumeRead); err != nil && c.IsActive() {
+		logger.Printf("NETPOLL: connection resume read failed: %v", err)
+	}
+	// the reading may be shut down concurrently.
+	if c.isShut(shutRead | shutPeer) {
+		c.operator.Control(PollPauseRead)
+	}
+}
+
+// waitBudget returns true if the buffer budget is available, otherwise resumeRead is called again once it is.
+func (c *connection) waitBudget() bool {
+	if !budget.exhausted() {
+		return true
+	}
+	if !atomic.CompareAndSwapInt32(&c.budgetWaiting, 0, 1) {
+		return false
+	}
+	return budget.wait(func() {
+		atomic.StoreInt32(&c.budgetWaiting, 0)
+		c.resumeRead()
+	})
+}
+
+// initIdleTimer starts the timer to reset the sizes of reading after the connection has been idle, if required.
+func (c *connection) initIdleTimer() {
+	if idle := c.sizePolicy.IdleTimeout(); idle > 0 {
+		c.idleTimer = time.AfterFunc(idle, c.onIdle)
+	}
+}
+
+// onIdle resets bookSize and maxSize if there is no read since the timer fired last time,
+// so that the large buffers are released by Release, and then the timer is stopped until the next read.
+func (c *connection) onIdle() {
+	if !c.IsActive() {
+		return
+	}
+	var idle = c.sizePolicy.IdleTimeout()
+	var last = c.idleReads
+	if reads := atomic.LoadUint32(&c.reads); reads != last {
+		c.idleReads = reads
+		c.idleTimer.Reset(idle)
+		return
+	}
+	// compete with the poller like Release, and retry later if failed.
+	if !c.operator.do() {
+		c.idleTimer.Reset(idle)
+		return
+	}
+	if c.IsActive() {
+		c.bookSize, c.maxSize = c.sizePolicy.Initial(), pagesize
+		c.idleReset = true
+	}
+	c.operator.done()
+	atomic.StoreInt32(&c.idling, 1)
+	// the read may have happened before idling is set.
+	if atomic.LoadUint32(&c.reads) != last && atomic.CompareAndSwapInt32(&c.idling, 1, 0) {
+		c.idleTimer.Reset(idle)
+	}
+}
+
+// wakeIdle counts the read, and restarts the idle timer if it has been stopped.
+func (c *connection) wakeIdle() {
+	if c.idleTimer == nil {
+		return
+	}
+	atomic.AddUint32(&c.reads, 1)
+	if atomic.LoadInt32(&c.idling) == 1 && atomic.CompareAndSwapInt32(&c.idling, 1, 0) {
+		c.idleTimer.Reset(c.sizePolicy.IdleTimeout())
+	}
+}
+
 // outputs implements FDOperator.
 func (c *connection) outputs(vs [][]byte) (rs [][]byte, supportZeroCopy bool) {
+	var limit = c.outputLimit()
+	if limit == 0 {
+		// the file at the head will be sent by outputFile.
+		return rs, false
+	}
 	if c.outputBuffer.IsEmpty() {
 		c.rw2r()
-		return rs, c.supportZeroCopy
+		return rs, false
 	}
-	rs = c.outputBuffer.GetBytes(vs)
-	return rs, c.supportZeroCopy
+	rs = limitBytes(c.outputBuffer.GetBytes(vs), limit)
+	// retry by copying once if the last zerocopy send failed, e.g. ENOBUFS.
+	c.zerocopy.sending = !c.zerocopy.fallback && c.useZeroCopy(c.outputBuffer.Len())
+	c.zerocopy.fallback = false
+	return rs, c.zerocopy.sending
 }
 
 // outputAck implements FDOperator.
 func (c *connection) outputAck(n int) (err error) {
 	if n > 0 {
-		c.outputBuffer.Skip(n)
-		c.outputBuffer.Release()
+		c.skipOutput(n, c.zerocopy.sending)
+		c.writable()
+	} else if c.zerocopy.sending {
+		c.zerocopy.fallback = true
 	}
-	if c.outputBuffer.IsEmpty() {
+	if !c.hasOutput() {
 		c.rw2r()
 	}
 	return nil
 }
 
-// rw2r removed the monitoring of write events.
+// rw2r removed the monitoring of write events after the output is drained.
 func (c *connection) rw2r() {
-	c.operator.Control(PollRW2R)
-	c.triggerWrite(nil)
+	if c.stopWriting(false) {
+		c.triggerWrite(nil)
+	}
 }
@@ -0,0 +1,261 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+var _ FileWriter = &connection{}
+
+// errWaitSource means that the source of splice must be read by the goroutine of Flush rather than the poller,
+// since it may not be readable, and reading it may take as long as the peer writes.
+var errWaitSource = errors.New("wait for the source of splice")
+
+// outputFiles is the queue of file regions in the output stream.
+// The bytes of outputBuffer and the file regions are sent in the order of writing,
+// so each region records the position in the buffered bytes where it's written,
+// which is compared with the buffered bytes sent as counted by FlushAsync.
+type outputFiles struct {
+	mu     sync.Mutex
+	size   int32 // length of queue, can be loaded without mu
+	queue  []outputFile
+	pipe   [2]int
+	piped  int // the number of bytes in pipe
+	piping bool
+}
+
+type outputFile struct {
+	fd     int
+	offset int64
+	remain int
+	at     uint64 // the number of buffered bytes written ahead of this file
+	splice bool
+}
+
+// WriteFile implements FileWriter.
+func (c *connection) WriteFile(fd int, offset int64, length int) (err error) {
+	if length <= 0 {
+		return nil
+	}
+	if err = c.reserveOutput(length); err != nil {
+		return err
+	}
+	if !c.IsActive() {
+		return Exception(ErrConnClosed, "when write file")
+	}
+	if c.isShut(shutWrite) {
+		return Exception(syscall.EPIPE, "when write file")
+	}
+	splice, err := fileSendMode(fd)
+	if err != nil {
+		return Exception(err, "when write file")
+	}
+	fs := &c.files
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if splice && !fs.piping {
+		if fs.pipe[0], fs.pipe[1], err = sysPipe(); err != nil {
+			return Exception(err, "when write file")
+		}
+		fs.piping = true
+	}
+	// the bytes flushed are counted by committed, which is not changed by the poller sending them.
+	var at = c.asyncFlush.committed + uint64(c.outputBuffer.MallocLen())
+	fs.queue = append(fs.queue, outputFile{fd: fd, offset: offset, remain: length, at: at, splice: splice})
+	atomic.StoreInt32(&fs.size, int32(len(fs.queue)))
+	return nil
+}
+
+// hasOutput checks whether there is data to be sent.
+func (c *connection) hasOutput() bool {
+	return !c.outputBuffer.IsEmpty() || atomic.LoadInt32(&c.files.size) > 0
+}
+
+// outputLimit returns the number of buffered bytes can be sent before the first file,
+// or -1 if there is no file in the output stream.
+func (c *connection) outputLimit() (limit int) {
+	fs := &c.files
+	if atomic.LoadInt32(&fs.size) == 0 {
+		return -1
+	}
+	fs.mu.Lock()
+	if len(fs.queue) == 0 {
+		fs.mu.Unlock()
+		return -1
+	}
+	var at = fs.queue[0].at
+	fs.mu.Unlock()
+	return c.unsent(at)
+}
+
+// sendFile sends the first file in the output stream, which must be at the head.
+// If wait is false, it's called by the poller and returns errWaitSource for the source of splice.
+func (c *connection) sendFile(wait bool) (err error) {
+	fs := &c.files
+	fs.mu.Lock()
+	if len(fs.queue) == 0 {
+		fs.mu.Unlock()
+		return nil
+	}
+	var f = &fs.queue[0]
+	var n int
+	if !f.splice {
+		n, err = sendfile(c.fd, f.fd, &f.offset, f.remain)
+		if n > 0 {
+			f.remain -= n
+		} else if err == nil {
+			err = Exception(ErrEOF, "when sendfile")
+		}
+	} else if !wait {
+		fs.mu.Unlock()
+		return errWaitSource
+	} else {
+		// move data from source to pipe
+		if fs.piped == 0 {
+			n, err = splice(fs.pipe[1], f.fd, f.remain)
+			if err == syscall.EAGAIN {
+				var fd = f.fd
+				fs.mu.Unlock()
+				if err = waitReadable(fd, c.writeTimeout); err != nil {
+					return err
+				}
+				return nil
+			}
+			if n > 0 {
+				f.remain -= n
+				fs.piped += n
+			} else if err == nil {
+				err = Exception(ErrEOF, "when splice")
+			}
+		}
+		// move data from pipe to socket
+		if err == nil {
+			n, err = splice(c.fd, fs.pipe[0], fs.piped)
+			if n > 0 {
+				fs.piped -= n
+			}
+		}
+	}
+	if f.remain == 0 && fs.piped == 0 {
+		fs.queue[0] = outputFile{}
+		fs.queue = fs.queue[:copy(fs.queue, fs.queue[1:])]
+		atomic.StoreInt32(&fs.size, int32(len(fs.queue)))
+	}
+	fs.mu.Unlock()
+	return err
+}
+
+// flushFiles sends the output stream which contains files.
+func (c *connection) flushFiles() (err error) {
+	for c.hasOutput() {
+		if limit := c.outputLimit(); limit == 0 {
+			err = c.sendFile(true)
+		} else {
+			err = c.sendBuffer(limit)
+		}
+		if err == nil {
+			continue
+		}
+		if err != syscall.EAGAIN {
+			return Exception(err, "when flush")
+		}
+		if err = c.startWriting(); err != nil {
+			return Exception(err, "when flush")
+		}
+		if err = c.waitFlush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendBuffer sends the buffered bytes once, and limit < 0 means no limit.
+func (c *connection) sendBuffer(limit int) (err error) {
+	var bs = limitBytes(c.outputBuffer.GetBytes(c.outputBarrier.bs), limit)
+	var zerocopy = c.useZeroCopy(c.outputBuffer.Len())
+	var n int
+	n, err = sendmsg(c.fd, bs, c.outputBarrier.ivs, zerocopy)
+	if err == syscall.ENOBUFS && zerocopy {
+		// exceed the optmem limit of zerocopy, retry by copying.
+		zerocopy = false
+		bs = limitBytes(c.outputBuffer.GetBytes(c.outputBarrier.bs), limit)
+		n, err = sendmsg(c.fd, bs, c.outputBarrier.ivs, zerocopy)
+	}
+	if n > 0 {
+		if serr := c.skipOutput(n, zerocopy); serr != nil {
+			return serr
+		}
+	}
+	return err
+}
+
+// outputFile implements FDOperator, sends the file at the head of output stream in poller.
+func (c *connection) outputFile() (err error) {
+	if atomic.LoadInt32(&c.files.size) == 0 {
+		return nil
+	}
+	switch err = c.sendFile(false); err {
+	case nil, syscall.EAGAIN:
+		if !c.hasOutput() {
+			c.rw2r()
+		}
+		return nil
+	case errWaitSource:
+		// wake up Flush to wait for the source.
+		c.stopWriting(true)
+		c.triggerWrite(nil)
+		return nil
+	}
+	return err
+}
+
+// closeFiles closes the pipe and gives up the files not sent.
+func (c *connection) closeFiles() {
+	fs := &c.files
+	fs.mu.Lock()
+	if fs.piping {
+		syscall.Close(fs.pipe[0])
+		syscall.Close(fs.pipe[1])
+		fs.piping = false
+	}
+	fs.queue, fs.piped = nil, 0
+	atomic.StoreInt32(&fs.size, 0)
+	fs.mu.Unlock()
+}
+
+// limitBytes limits the total length of bs, and limit < 0 means no limit.
+func limitBytes(bs [][]byte, limit int) [][]byte {
+	if limit < 0 {
+		return bs
+	}
+	for i := range bs {
+		if len(bs[i]) >= limit {
+			bs[i] = bs[i][:limit]
+			for j := i + 1; j < len(bs); j++ {
+				bs[j] = nil
+			}
+			return bs[:i+1]
+		}
+		limit -= len(bs[i])
+	}
+	return bs
+}
@@ -0,0 +1,89 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"time"
+)
+
+// SizePolicy decides the size of each read of connections, which grows when the reads fill it up
+// and can shrink when the connection is less busy. It's set by WithSizePolicy.
+type SizePolicy interface {
+	// Initial returns the size of the first read, and the size after the connection has been idle.
+	Initial() int
+	// Next returns the size of the next read, after n bytes have been read by a read of size.
+	Next(size, n int) int
+	// IdleTimeout returns how long a connection can be idle before its size is reset to Initial
+	// and the buffers larger than it are released, and 0 means never.
+	IdleTimeout() time.Duration
+}
+
+// defaultSizePolicy is used by the connections without WithSizePolicy.
+// It only doubles the size up to mallocMax, and never shrinks or resets it.
+var defaultSizePolicy SizePolicy = &sizePolicy{min: block1k / 2, initial: block1k / 2, max: mallocMax}
+
+// NewSizePolicy returns a SizePolicy which doubles the size up to max when a read fills it up,
+// and halves it down to min when a read fills less than 1/8 of it.
+// The size starts from initial, and is reset to it after the connection has been idle for idleTimeout unless it's 0.
+func NewSizePolicy(min, initial, max int, idleTimeout time.Duration) SizePolicy {
+	if min <= 0 {
+		min = block1k / 2
+	}
+	if max > mallocMax {
+		max = mallocMax
+	}
+	if max < min {
+		max = min
+	}
+	if initial < min {
+		initial = min
+	} else if initial > max {
+		initial = max
+	}
+	return &sizePolicy{min: min, initial: initial, max: max, idleTimeout: idleTimeout, shrink: true}
+}
+
+type sizePolicy struct {
+	min, initial, max int
+	idleTimeout       time.Duration
+	shrink            bool
+}
+
+// Initial implements SizePolicy.
+func (p *sizePolicy) Initial() int {
+	return p.initial
+}
+
+// Next implements SizePolicy.
+func (p *sizePolicy) Next(size, n int) int {
+	switch {
+	case n >= size && size < p.max:
+		size <<= 1
+		if size > p.max {
+			size = p.max
+		}
+	case p.shrink && n <= size/8 && size > p.min:
+		size >>= 1
+		if size < p.min {
+			size = p.min
+		}
+	}
+	return size
+}
+
+// IdleTimeout implements SizePolicy.
+func (p *sizePolicy) IdleTimeout() time.Duration {
+	return p.idleTimeout
+}
@@ -0,0 +1,165 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSizePolicy(t *testing.T) {
+	var policy = NewSizePolicy(1024, 512, 64*1024, time.Second)
+	Equal(t, policy.Initial(), 1024)
+	Equal(t, policy.IdleTimeout(), time.Second)
+	Equal(t, policy.Next(1024, 1024), 2048)
+	Equal(t, policy.Next(48*1024, 48*1024), 64*1024)
+	Equal(t, policy.Next(64*1024, 64*1024), 64*1024)
+	Equal(t, policy.Next(4096, 1024), 4096)
+	Equal(t, policy.Next(4096, 512), 2048)
+	Equal(t, policy.Next(1024, 0), 1024)
+
+	policy = NewSizePolicy(0, 0, 0, 0)
+	Equal(t, policy.Initial(), block1k/2)
+	Equal(t, policy.Next(block1k/2, block1k/2), block1k/2)
+
+	// the default policy only grows, and is never reset.
+	policy = defaultSizePolicy
+	Equal(t, policy.Initial(), block1k/2)
+	Equal(t, policy.IdleTimeout(), time.Duration(0))
+	Equal(t, policy.Next(block1k/2, block1k/2), block1k)
+	Equal(t, policy.Next(mallocMax, mallocMax), mallocMax)
+	Equal(t, policy.Next(64*1024, 1), 64*1024)
+	Equal(t, policy.Next(64*1024, 0), 64*1024)
+}
+
+func TestConnectionSizePolicy(t *testing.T) {
+	var initial, max = 1024, 32 * 1024
+	var policy = &sizeRecorder{SizePolicy: NewSizePolicy(512, initial, max, 100*time.Millisecond)}
+	var opts = &options{}
+	WithSizePolicy(policy).f(opts)
+
+	r, w := GetSysFdPairs()
+	defer syscall.Close(w)
+	var conn = &connection{}
+	conn.init(&netFD{fd: r}, opts)
+	defer conn.Close()
+	var write = func(msg []byte) {
+		for sent := 0; sent < len(msg); {
+			n, err := syscall.Write(w, msg[sent:])
+			if err != nil {
+				return
+			}
+			sent += n
+		}
+	}
+	var reader = conn.Reader()
+	var inuse = func() int64 {
+		return conn.MemStats().InUse
+	}
+	// readByte reads a byte from the peer, and returns the memory in use while it's not released.
+	// The tail node is reset by Release first, which doesn't compete with the poller after a while.
+	var readByte = func() (n int64) {
+		time.Sleep(5 * time.Millisecond)
+		MustNil(t, reader.Release())
+		write([]byte{'x'})
+		_, err := reader.Next(1)
+		MustNil(t, err)
+		n = inuse()
+		MustNil(t, reader.Release())
+		return n
+	}
+	// nextRead returns the size decided for the next read, and forgets the sizes recorded.
+	var nextRead = func() int {
+		var sizes = policy.reads()
+		MustTrue(t, len(sizes) > 0)
+		return sizes[len(sizes)-1]
+	}
+	var small = readByte()
+	MustTrue(t, small <= int64(2*pagesize))
+	Equal(t, policy.resets(), 1)
+	// the size shrinks after the small read.
+	Equal(t, nextRead(), initial/2)
+
+	// the size grows with a burst, and is limited by max. And then the sizes are reset after the connection
+	// has been idle, and the idle timer is restarted by the next burst.
+	var total = 4 * 1024 * 1024
+	for round := 0; round < 2; round++ {
+		go write(make([]byte, total))
+		_, err := reader.Next(total)
+		MustNil(t, err)
+		MustNil(t, reader.Release())
+		var sizes, largest = policy.reads(), 0
+		Equal(t, sizes[0], initial)
+		for _, size := range sizes {
+			MustTrue(t, size <= max)
+			if size > largest {
+				largest = size
+			}
+		}
+		Equal(t, largest, max)
+		// the next read still uses a large buffer.
+		MustTrue(t, readByte() > small+int64(pagesize))
+
+		for i := 0; policy.resets() != round+2; i++ {
+			MustTrue(t, i < 100)
+			time.Sleep(10 * time.Millisecond)
+		}
+		// the large buffers are released, and the next read uses a small one.
+		Equal(t, readByte(), small)
+		Equal(t, nextRead(), initial/2)
+	}
+}
+
+// sizeRecorder records the sizes returned by Next, and counts the resets by Initial.
+type sizeRecorder struct {
+	SizePolicy
+	mu      sync.Mutex
+	sizes   []int
+	initial int
+}
+
+func (r *sizeRecorder) Initial() int {
+	r.mu.Lock()
+	r.initial++
+	r.mu.Unlock()
+	return r.SizePolicy.Initial()
+}
+
+func (r *sizeRecorder) Next(size, n int) int {
+	size = r.SizePolicy.Next(size, n)
+	r.mu.Lock()
+	r.sizes = append(r.sizes, size)
+	r.mu.Unlock()
+	return size
+}
+
+// reads returns the sizes recorded since the last call.
+func (r *sizeRecorder) reads() (sizes []int) {
+	r.mu.Lock()
+	sizes, r.sizes = r.sizes, nil
+	r.mu.Unlock()
+	return sizes
+}
+
+func (r *sizeRecorder) resets() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.initial
+}
@@ -18,10 +18,16 @@
 package netpoll
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/rand"
+	"net"
+	"os"
 	"runtime"
 	"sync"
 	"sync/atomic"
@@ -353,6 +359,157 @@ func TestConnectionLargeMemory(t *testing.T) {
 	}
 }
 
+func TestConnectionInputWaterMarks(t *testing.T) {
+	var high, low = 256 * 1024, 64 * 1024
+	var opts = &options{}
+	WithInputWaterMarks(high, low).f(opts)
+	Equal(t, opts.highWater, high)
+	Equal(t, opts.lowWater, low)
+
+	r, w := GetSysFdPairs()
+	var rconn = &connection{}
+	rconn.init(&netFD{fd: r}, opts)
+	defer rconn.Close()
+
+	var size, total = 64 * 1024, 32 * 1024 * 1024
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer syscall.Close(w)
+		var msg = make([]byte, size)
+		for i := 0; i < total/size; i++ {
+			for sent := 0; sent < size; {
+				n, err := syscall.Write(w, msg[sent:])
+				if err != nil {
+					panic(err)
+				}
+				sent += n
+			}
+		}
+	}()
+
+	// nobody reads the input, so the poller stops reading when it exceeds the high water mark.
+	for !rconn.operator.isPaused() {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	var length = rconn.Reader().Len()
+	MustTrue(t, length >= high)
+	// io_uring may deliver the data received before the recv is canceled, which is limited by its buffers.
+	MustTrue(t, length <= high+4*1024*1024)
+	time.Sleep(50 * time.Millisecond)
+	Equal(t, rconn.Reader().Len(), length)
+
+	// waiting for the data beyond the high water mark must not be blocked.
+	var read = length + high
+	_, err := rconn.Reader().Next(read)
+	MustNil(t, err)
+	MustNil(t, rconn.Reader().Release())
+
+	// draining below the low water mark resumes reading.
+	for read < total {
+		var n = rconn.Reader().Len()
+		if n == 0 {
+			n = 1
+		}
+		if n > total-read {
+			n = total - read
+		}
+		_, err = rconn.Reader().Next(n)
+		MustNil(t, err)
+		MustNil(t, rconn.Reader().Release())
+		read += n
+	}
+	Equal(t, rconn.Reader().Len(), 0)
+}
+
+func TestConnectionOutputLimit(t *testing.T) {
+	var size = 1024 * 1024
+	var wg sync.WaitGroup
+	var drain = func(fd int) {
+		defer wg.Done()
+		var buf = make([]byte, size)
+		for n := 0; n < size; {
+			m, err := syscall.Read(fd, buf)
+			if err != nil {
+				panic(err)
+			}
+			n += m
+		}
+	}
+
+	// fail fast
+	var writable = make(chan struct{}, 1)
+	var opts = &options{}
+	WithOutputLimit(64*1024, 16*1024, true).f(opts)
+	WithOnWritable(func(ctx context.Context, connection Connection) error {
+		writable <- struct{}{}
+		return nil
+	}).f(opts)
+	r, w := GetSysFdPairs()
+	defer syscall.Close(r)
+	var peer = &net.UnixAddr{Net: "unix", Name: "peer"}
+	var wconn = &connection{}
+	wconn.init(&netFD{fd: w, remoteAddr: peer}, opts)
+	defer wconn.Close()
+	wconn.SetWriteTimeout(50 * time.Millisecond)
+
+	// a single write larger than the limit is allowed.
+	_, err := wconn.WriteBinary(make([]byte, size))
+	MustNil(t, err)
+	err = wconn.Flush()
+	MustTrue(t, errors.Is(err, ErrWriteTimeout))
+	_, err = wconn.WriteBinary(make([]byte, 1))
+	MustTrue(t, errors.Is(err, ErrOutputFull))
+	_, err = wconn.Malloc(1)
+	MustTrue(t, errors.Is(err, ErrOutputFull))
+	wg.Add(1)
+	go drain(r)
+	MustNil(t, wconn.Flush())
+	select {
+	case <-writable:
+	case <-time.After(time.Second):
+		t.Fatal("OnWritable is not called")
+	}
+	_, err = wconn.WriteBinary(make([]byte, 1))
+	MustNil(t, err)
+	// wait for the drain before closing the fd.
+	wg.Wait()
+
+	// blocking
+	opts = &options{}
+	WithOutputLimit(64*1024, 16*1024, false).f(opts)
+	r, w = GetSysFdPairs()
+	defer syscall.Close(r)
+	wconn = &connection{}
+	wconn.init(&netFD{fd: w, remoteAddr: peer}, opts)
+	defer wconn.Close()
+	wconn.SetWriteTimeout(50 * time.Millisecond)
+
+	_, err = wconn.WriteBinary(make([]byte, size))
+	MustNil(t, err)
+	// the output malloced but not flushed is never flushed by the write.
+	_, err = wconn.WriteBinary(make([]byte, 1))
+	MustNil(t, err)
+	Equal(t, wconn.MallocLen(), size+1)
+	err = wconn.Flush()
+	MustTrue(t, errors.Is(err, ErrWriteTimeout))
+	// the flushed output can't be sent.
+	_, err = wconn.WriteBinary(make([]byte, 1))
+	MustTrue(t, errors.Is(err, ErrWriteTimeout))
+	Equal(t, wconn.MallocLen(), 0)
+	wg.Add(1)
+	go drain(r)
+	_, err = wconn.WriteBinary(make([]byte, 1))
+	MustNil(t, err)
+	MustTrue(t, wconn.outputBuffer.Len() <= 16*1024)
+	Equal(t, wconn.MallocLen(), 1)
+	MustNil(t, wconn.Flush())
+	wg.Wait()
+}
+
 // TestSetTCPNoDelay is used to verify the connection initialization set the TCP_NODELAY correctly
 func TestSetTCPNoDelay(t *testing.T) {
 	fd, err := sysSocket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
@@ -432,3 +589,686 @@ func TestBookSizeLargerThanMaxSize(t *testing.T) {
 	wg.Wait()
 	rconn.Close()
 }
+
+// TestZeroCopyHoldBuffer verifies that the buffers sent by zerocopy are never reused before the kernel acks.
+func TestZeroCopyHoldBuffer(t *testing.T) {
+	r, w := GetSysFdPairs()
+	defer syscall.Close(r)
+	var wconn = &connection{}
+	wconn.init(&netFD{fd: w}, nil)
+	wconn.supportZeroCopy = true
+	wconn.zerocopy.threshold = 1
+
+	var size = pagesize * 2
+	var sends = make([]*LinkBuffer, 3)
+	for i := 0; i < len(sends); i++ {
+		buf, err := wconn.outputBuffer.Malloc(size)
+		MustNil(t, err)
+		for j := range buf {
+			buf[j] = byte(i + 1)
+		}
+		wconn.outputBuffer.Flush()
+		err = wconn.skipOutput(size, true)
+		MustNil(t, err)
+		Equal(t, wconn.outputBuffer.Len(), 0)
+		sends[i] = wconn.zerocopy.pending[i].buf
+	}
+	Equal(t, wconn.zeroCopyPending(), 3)
+
+	// recycle the output buffer and dirty the memory from mcache
+	wconn.outputBuffer.Close()
+	for i := 0; i < 16; i++ {
+		buf := malloc(size, size)
+		for j := range buf {
+			buf[j] = 0xff
+		}
+		free(buf)
+	}
+	for i, send := range sends {
+		buf, err := send.Peek(size)
+		MustNil(t, err)
+		for _, b := range buf {
+			if b != byte(i+1) {
+				t.Fatalf("zerocopy buffer[%d] reused before ack", i)
+			}
+		}
+	}
+
+	wconn.zeroCopyAck(0, 1)
+	Equal(t, wconn.zeroCopyPending(), 1)
+	wconn.zeroCopyAck(2, 2)
+	Equal(t, wconn.zeroCopyPending(), 0)
+	syscall.Close(w)
+}
+
+func TestZeroCopySend(t *testing.T) {
+	fd, err := sysSocket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	MustNil(t, err)
+	err = setZeroCopy(fd)
+	syscall.Close(fd)
+	if err != nil {
+		t.Skipf("zerocopy is not supported: %v", err)
+	}
+
+	var network, address = "tcp", ":18889"
+	var size, cycle = 256 * 1024, 64
+	var sender = make(chan *connection, 1)
+	var loop = newTestEventLoop(network, address,
+		func(ctx context.Context, conn Connection) error {
+			_, err := conn.Reader().Next(1)
+			MustNil(t, err)
+			conn.Reader().Release()
+			for i := 0; i < cycle; i++ {
+				buf, err := conn.Writer().Malloc(size)
+				MustNil(t, err)
+				for j := range buf {
+					buf[j] = byte(i)
+				}
+				err = conn.Writer().Flush()
+				MustNil(t, err)
+			}
+			sender <- conn.(*connection)
+			return nil
+		},
+		WithZeroCopy(1),
+	)
+	defer loop.Shutdown(context.Background())
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	err = conn.Writer().WriteByte(0)
+	MustNil(t, err)
+	err = conn.Writer().Flush()
+	MustNil(t, err)
+	for i := 0; i < cycle; i++ {
+		buf, err := conn.Reader().Next(size)
+		MustNil(t, err)
+		for j := range buf {
+			if buf[j] != byte(i) {
+				t.Fatalf("zerocopy send corrupted at cycle[%d] index[%d]", i, j)
+			}
+		}
+		conn.Reader().Release()
+	}
+	svr := <-sender
+	if _, ok := svr.operator.poll.(zeroCopyPoll); ok {
+		MustTrue(t, svr.supportZeroCopy)
+	}
+	for svr.zeroCopyPending() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	err = conn.Close()
+	MustNil(t, err)
+}
+
+func TestConnectionWriteFile(t *testing.T) {
+	r, w := GetSysFdPairs()
+	var rconn, wconn = &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, nil)
+	wconn.init(&netFD{fd: w}, nil)
+
+	// regular file larger than the socket buffer
+	var fileData = make([]byte, 4*1024*1024)
+	for i := range fileData {
+		fileData[i] = byte(i % 251)
+	}
+	file, err := ioutil.TempFile("", "netpoll-sendfile")
+	MustNil(t, err)
+	defer os.Remove(file.Name())
+	defer file.Close()
+	_, err = file.Write(fileData)
+	MustNil(t, err)
+
+	// pipe written slowly
+	var pipeData = []byte("data from pipe")
+	var p [2]int
+	err = syscall.Pipe(p[:])
+	MustNil(t, err)
+	defer syscall.Close(p[0])
+	defer syscall.Close(p[1])
+	go func() {
+		for i := range pipeData {
+			time.Sleep(time.Millisecond)
+			syscall.Write(p[1], pipeData[i:i+1])
+		}
+	}()
+
+	var offset = 1024
+	var expect []byte
+	_, err = wconn.WriteString("head")
+	MustNil(t, err)
+	err = wconn.WriteFile(int(file.Fd()), int64(offset), len(fileData)-offset)
+	MustNil(t, err)
+	_, err = wconn.WriteString("middle")
+	MustNil(t, err)
+	err = wconn.WriteFile(p[0], 0, len(pipeData))
+	MustNil(t, err)
+	_, err = wconn.WriteString("tail")
+	MustNil(t, err)
+	expect = append(expect, "head"...)
+	expect = append(expect, fileData[offset:]...)
+	expect = append(expect, "middle"...)
+	expect = append(expect, pipeData...)
+	expect = append(expect, "tail"...)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buf, err := rconn.Reader().Next(len(expect))
+		MustNil(t, err)
+		MustTrue(t, bytes.Equal(buf, expect))
+	}()
+	err = wconn.Flush()
+	MustNil(t, err)
+	wg.Wait()
+	MustTrue(t, !wconn.hasOutput())
+
+	// file offset is not changed
+	pos, err := file.Seek(0, io.SeekCurrent)
+	MustNil(t, err)
+	Equal(t, pos, int64(len(fileData)))
+
+	wconn.Close()
+	rconn.Close()
+}
+
+func TestConnectionWriteFileAfterFlushAsync(t *testing.T) {
+	r, w := GetSysFdPairs()
+	var rconn, wconn = &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, nil)
+	wconn.init(&netFD{fd: w}, nil)
+
+	file, err := ioutil.TempFile("", "netpoll-sendfile")
+	MustNil(t, err)
+	defer os.Remove(file.Name())
+	defer file.Close()
+	_, err = file.WriteString("file")
+	MustNil(t, err)
+
+	// the file is queued while the poller is still sending the output flushed before.
+	var head = make([]byte, 4*1024*1024)
+	for i := range head {
+		head[i] = byte(i % 251)
+	}
+	_, err = wconn.WriteBinary(head)
+	MustNil(t, err)
+	MustNil(t, wconn.FlushAsync(nil))
+	MustNil(t, wconn.WriteFile(int(file.Fd()), 0, 4))
+	_, err = wconn.WriteString("tail")
+	MustNil(t, err)
+	var expect = append(append(head, "file"...), "tail"...)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		buf, err := rconn.Reader().Next(len(expect))
+		MustNil(t, err)
+		MustTrue(t, bytes.Equal(buf, expect))
+	}()
+	MustNil(t, wconn.Flush())
+	wg.Wait()
+
+	// WriteFile is checked like the other writes.
+	wconn.Close()
+	rconn.Close()
+	MustTrue(t, errors.Is(wconn.WriteFile(int(file.Fd()), 0, 4), ErrConnClosed))
+}
+
+func TestConnectionHalfClose(t *testing.T) {
+	// the server stays writable after the peer shuts down its writing.
+	var address = "127.0.0.1:18896"
+	var eof = make(chan error, 1)
+	var loop = newTestEventLoop("tcp", address,
+		func(ctx context.Context, connection Connection) error {
+			var reader, writer = connection.Reader(), connection.Writer()
+			if n := reader.Len(); n > 0 {
+				buf, _ := reader.Next(n)
+				writer.WriteBinary(buf)
+				reader.Release()
+				return writer.Flush()
+			}
+			// OnRequest is called with no data only once after the peer shut down.
+			_, err := reader.Next(1)
+			eof <- err
+			writer.WriteString("bye")
+			return connection.(HalfCloser).CloseWrite()
+		},
+		WithHalfClose(true))
+	defer loop.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", address)
+	MustNil(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	_, err = conn.Write([]byte("hello"))
+	MustNil(t, err)
+	var buf = make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	MustNil(t, err)
+	Equal(t, string(buf), "hello")
+	MustNil(t, conn.(*net.TCPConn).CloseWrite())
+	MustTrue(t, errors.Is(<-eof, ErrEOF))
+	buf, err = ioutil.ReadAll(conn)
+	MustNil(t, err)
+	Equal(t, string(buf), "bye")
+
+	// the client of Dialer stays writable after the peer shuts down its writing.
+	address = "127.0.0.1:18897"
+	ln, err := net.Listen("tcp", address)
+	MustNil(t, err)
+	defer ln.Close()
+	var received = make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+		var buf = make([]byte, 5)
+		io.ReadFull(conn, buf)
+		conn.Write([]byte("world"))
+		conn.(*net.TCPConn).CloseWrite()
+		buf, _ = ioutil.ReadAll(conn)
+		received <- string(buf)
+	}()
+	cconn, err := NewDialer(WithHalfClose(true)).DialConnection("tcp", address, time.Second)
+	MustNil(t, err)
+	defer cconn.Close()
+	cconn.SetReadTimeout(time.Second)
+	cconn.Writer().WriteString("hello")
+	MustNil(t, cconn.Writer().Flush())
+	buf, err = cconn.Reader().Next(5)
+	MustNil(t, err)
+	Equal(t, string(buf), "world")
+	_, err = cconn.Reader().Next(1)
+	MustTrue(t, errors.Is(err, ErrEOF))
+	MustTrue(t, cconn.IsActive())
+	cconn.Writer().WriteString("again")
+	MustNil(t, cconn.(HalfCloser).CloseWrite())
+	Equal(t, <-received, "again")
+	// CloseWrite stops writing, and the connection is closed after CloseRead.
+	var done = make(chan struct{})
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+		var buf, _ = ioutil.ReadAll(conn)
+		received <- string(buf)
+		<-done
+	}()
+	cconn, err = DialConnection("tcp", address, time.Second)
+	MustNil(t, err)
+	defer cconn.Close()
+	cconn.Writer().WriteString("ping")
+	MustNil(t, cconn.(HalfCloser).CloseWrite())
+	Equal(t, <-received, "ping")
+	cconn.Writer().WriteString("closed")
+	MustTrue(t, errors.Is(cconn.Writer().Flush(), syscall.EPIPE))
+	MustTrue(t, cconn.IsActive())
+	MustNil(t, cconn.(HalfCloser).CloseRead())
+	MustTrue(t, !cconn.IsActive())
+	close(done)
+
+	// CloseRead stops reading, but the connection is still writable.
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+		conn.Write([]byte("ignored"))
+		var buf = make([]byte, 4)
+		io.ReadFull(conn, buf)
+		received <- string(buf)
+	}()
+	cconn, err = DialConnection("tcp", address, time.Second)
+	MustNil(t, err)
+	defer cconn.Close()
+	MustNil(t, cconn.(HalfCloser).CloseRead())
+	_, err = cconn.Reader().Next(cconn.Reader().Len() + 1)
+	MustTrue(t, errors.Is(err, ErrEOF))
+	MustTrue(t, cconn.IsActive())
+	cconn.Writer().WriteString("ping")
+	MustNil(t, cconn.Writer().Flush())
+	Equal(t, <-received, "ping")
+}
+
+func TestConnectionAbort(t *testing.T) {
+	var opts = &options{}
+	WithLinger(1500 * time.Millisecond).f(opts)
+	MustTrue(t, opts.lingerSet)
+	Equal(t, opts.linger, 2)
+	WithLinger(-1).f(opts)
+	MustTrue(t, !opts.lingerSet)
+
+	var address = "127.0.0.1:18898"
+	ln, err := net.Listen("tcp", address)
+	MustNil(t, err)
+	defer ln.Close()
+	var accept = func() chan error {
+		var closed = make(chan error, 1)
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				closed <- err
+				return
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(time.Second))
+			_, err = ioutil.ReadAll(conn)
+			closed <- err
+		}()
+		return closed
+	}
+
+	// the peer reads EOF after Close, but it's reset after Abort.
+	var closed = accept()
+	conn, err := DialConnection("tcp", address, time.Second)
+	MustNil(t, err)
+	MustNil(t, conn.Close())
+	MustNil(t, <-closed)
+
+	closed = accept()
+	conn, err = DialConnection("tcp", address, time.Second)
+	MustNil(t, err)
+	conn.Writer().WriteString("discarded")
+	MustNil(t, conn.(Aborter).Abort())
+	MustTrue(t, !conn.IsActive())
+	MustTrue(t, errors.Is(<-closed, syscall.ECONNRESET))
+
+	// zero linger resets the connection on close.
+	closed = accept()
+	conn, err = NewDialer(WithLinger(0)).DialConnection("tcp", address, time.Second)
+	MustNil(t, err)
+	MustNil(t, conn.Close())
+	MustTrue(t, errors.Is(<-closed, syscall.ECONNRESET))
+}
+
+type lostObserver struct {
+	NoopObserver
+	lost int64
+}
+
+func (o *lostObserver) OnOutputLost(connection Connection, n int) { atomic.AddInt64(&o.lost, int64(n)) }
+
+func TestConnectionFlushOnClose(t *testing.T) {
+	var size = 1024 * 1024
+	var peer = &net.UnixAddr{Net: "unix", Name: "peer"}
+	var observer = &lostObserver{}
+	var opts = &options{}
+	WithFlushOnClose(time.Second).f(opts)
+	WithObserver(observer).f(opts)
+
+	// the pending output is sent before closing.
+	r, w := GetSysFdPairs()
+	defer syscall.Close(r)
+	var wconn = &connection{}
+	wconn.init(&netFD{fd: w, remoteAddr: peer}, opts)
+	wconn.SetWriteTimeout(50 * time.Millisecond)
+	_, err := wconn.WriteBinary(make([]byte, size))
+	MustNil(t, err)
+	MustTrue(t, errors.Is(wconn.Flush(), ErrWriteTimeout))
+	var received = make(chan int, 1)
+	go func() {
+		var buf = make([]byte, size)
+		var n int
+		for {
+			m, err := syscall.Read(r, buf)
+			if m <= 0 || err != nil {
+				break
+			}
+			n += m
+		}
+		received <- n
+	}()
+	MustNil(t, wconn.Close())
+	Equal(t, <-received, size)
+	Equal(t, atomic.LoadInt64(&observer.lost), int64(0))
+
+	// the output unsent after the timeout is reported.
+	WithFlushOnClose(50 * time.Millisecond).f(opts)
+	r, w = GetSysFdPairs()
+	defer syscall.Close(r)
+	wconn = &connection{}
+	wconn.init(&netFD{fd: w, remoteAddr: peer}, opts)
+	wconn.SetWriteTimeout(50 * time.Millisecond)
+	_, err = wconn.WriteBinary(make([]byte, size))
+	MustNil(t, err)
+	MustTrue(t, errors.Is(wconn.Flush(), ErrWriteTimeout))
+	var start = time.Now()
+	MustNil(t, wconn.Close())
+	MustTrue(t, time.Since(start) >= 50*time.Millisecond)
+	var lost = atomic.LoadInt64(&observer.lost)
+	MustTrue(t, lost > 0 && lost < int64(size))
+}
+
+func TestConnectionFlushAsync(t *testing.T) {
+	var size = 1024 * 1024
+	var peer = &net.UnixAddr{Net: "unix", Name: "peer"}
+	r, w := GetSysFdPairs()
+	defer syscall.Close(r)
+	var wconn = &connection{}
+	wconn.init(&netFD{fd: w, remoteAddr: peer}, nil)
+
+	// FlushAsync returns at once, and the callbacks are called in order after the data is sent.
+	var order = make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		var i = i
+		_, err := wconn.WriteBinary(make([]byte, size))
+		MustNil(t, err)
+		MustNil(t, wconn.FlushAsync(func(err error) {
+			MustNil(t, err)
+			order <- i
+		}))
+	}
+	MustTrue(t, len(order) == 0)
+	// the data flushed synchronously is sent after the data of FlushAsync.
+	var flushed = make(chan error, 1)
+	go func() {
+		_, err := wconn.WriteString("sync")
+		if err == nil {
+			err = wconn.Flush()
+		}
+		flushed <- err
+	}()
+	var buf = make([]byte, size)
+	var n int
+	for n < 3*size+4 {
+		m, err := syscall.Read(r, buf)
+		MustNil(t, err)
+		if n+m == 3*size+4 {
+			Equal(t, string(buf[m-4:m]), "sync")
+		}
+		n += m
+	}
+	MustNil(t, <-flushed)
+	for i := 0; i < 3; i++ {
+		Equal(t, <-order, i)
+	}
+
+	// the callback pending is called with an error when closed.
+	_, err := wconn.WriteBinary(make([]byte, 4*size))
+	MustNil(t, err)
+	var failed = make(chan error, 1)
+	MustNil(t, wconn.FlushAsync(func(err error) {
+		failed <- err
+	}))
+	MustNil(t, wconn.Close())
+	MustTrue(t, errors.Is(<-failed, ErrConnClosed))
+	MustTrue(t, errors.Is(wconn.FlushAsync(func(err error) {}), ErrConnClosed))
+}
+
+func TestConnectionBinary(t *testing.T) {
+	r, w := GetSysFdPairs()
+	var rconn, wconn = &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, nil)
+	wconn.init(&netFD{fd: w}, nil)
+	defer rconn.Close()
+	defer wconn.Close()
+
+	MustNil(t, wconn.WriteUint32(0x01020304))
+	MustNil(t, wconn.WriteUint64LE(0x0102030405060708))
+	MustNil(t, wconn.WriteVarint(-300))
+	MustNil(t, wconn.Flush())
+	v32, err := rconn.ReadUint32()
+	MustNil(t, err)
+	Equal(t, v32, uint32(0x01020304))
+	v64, err := rconn.ReadUint64LE()
+	MustNil(t, err)
+	Equal(t, v64, uint64(0x0102030405060708))
+	iv, err := rconn.ReadVarint()
+	MustNil(t, err)
+	Equal(t, iv, int64(-300))
+
+	// the varint is read until it's complete.
+	var p [binary.MaxVarintLen64]byte
+	var n = binary.PutUvarint(p[:], 1<<40)
+	go func() {
+		for i := 0; i < n; i++ {
+			time.Sleep(5 * time.Millisecond)
+			wconn.WriteByte(p[i])
+			wconn.Flush()
+		}
+	}()
+	uv, err := rconn.ReadUvarint()
+	MustNil(t, err)
+	Equal(t, uv, uint64(1<<40))
+}
+
+func TestConnectionUntilBytes(t *testing.T) {
+	r, w := GetSysFdPairs()
+	var rconn, wconn = &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, nil)
+	wconn.init(&netFD{fd: w}, nil)
+	defer rconn.Close()
+	defer wconn.Close()
+
+	// the delim spans the data arriving at different times.
+	var pieces = []string{"Host: a\r", "\n", "\r", "\nbody", "\r\n"}
+	go func() {
+		for _, p := range pieces {
+			time.Sleep(5 * time.Millisecond)
+			wconn.WriteString(p)
+			wconn.Flush()
+		}
+	}()
+	line, err := rconn.UntilBytes([]byte("\r\n\r\n"), 0)
+	MustNil(t, err)
+	Equal(t, string(line), "Host: a\r\n\r\n")
+	line, err = rconn.UntilBytes([]byte("\r\n"), 0)
+	MustNil(t, err)
+	Equal(t, string(line), "body\r\n")
+
+	// the line is too long.
+	_, err = wconn.WriteString("0123456789")
+	MustNil(t, err)
+	MustNil(t, wconn.Flush())
+	_, err = rconn.UntilBytes([]byte("\r\n"), 8)
+	MustTrue(t, errors.Is(err, ErrTooLong))
+	Equal(t, rconn.Index([]byte("89"), 0), 8)
+}
+
+func TestConnectionBufferBudget(t *testing.T) {
+	var allocator = &countAllocator{}
+	var opts = &options{}
+	WithAllocator(allocator).f(opts)
+	var inuse = ReadMemStats().InUse
+
+	r, w := GetSysFdPairs()
+	var rconn = &connection{}
+	rconn.init(&netFD{fd: r}, opts)
+	defer rconn.Close()
+	MustTrue(t, atomic.LoadInt64(&allocator.malloc) > 0)
+	Equal(t, rconn.MemStats().InUse, atomic.LoadInt64(&allocator.malloc))
+
+	// the connection created before the budget is set is also limited.
+	var limit = 256 * 1024
+	SetBufferBudget(inuse + int64(limit))
+	defer SetBufferBudget(0)
+
+	var size, total = 64 * 1024, 8 * 1024 * 1024
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer syscall.Close(w)
+		var msg = make([]byte, size)
+		for i := 0; i < total/size; i++ {
+			for sent := 0; sent < size; {
+				n, err := syscall.Write(w, msg[sent:])
+				if err != nil {
+					panic(err)
+				}
+				sent += n
+			}
+		}
+	}()
+
+	// nobody reads the input, so the poller stops reading when the budget is exhausted.
+	for !rconn.operator.isPaused() {
+		time.Sleep(time.Millisecond)
+	}
+	MustTrue(t, budget.exhausted())
+	MustTrue(t, rconn.Reader().Len() > 0)
+	MustTrue(t, rconn.Reader().Len() < total)
+	// the write waits for the budget, or fails fast.
+	rconn.SetWriteTimeout(10 * time.Millisecond)
+	_, err := rconn.Writer().WriteBinary([]byte("hello"))
+	MustTrue(t, errors.Is(err, ErrWriteTimeout))
+	rconn.outputBound.failFast = true
+	_, err = rconn.Writer().WriteBinary([]byte("hello"))
+	MustTrue(t, errors.Is(err, ErrBudgetExhausted))
+
+	// reading resumes once the input is drained and the memory is freed.
+	for read := 0; read < total; {
+		var n = rconn.Reader().Len()
+		if n == 0 {
+			n = 1
+		}
+		if n > total-read {
+			n = total - read
+		}
+		_, err = rconn.Reader().Next(n)
+		MustNil(t, err)
+		MustNil(t, rconn.Reader().Release())
+		read += n
+	}
+	MustTrue(t, !budget.exhausted())
+	var stats = rconn.MemStats()
+	MustTrue(t, stats.Allocated >= int64(total))
+	MustTrue(t, stats.InUse <= stats.Allocated)
+}
+
+func TestConnectionCopy(t *testing.T) {
+	r, w := GetSysFdPairs()
+	var rconn, wconn = &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, &options{})
+	wconn.init(&netFD{fd: w}, &options{})
+	defer rconn.Close()
+
+	// ReadFrom reads into the output buffer, and WriteTo writes from the input buffer until closed by the peer.
+	var data = bytes.Repeat([]byte("netpoll"), 64*1024)
+	go func() {
+		n, err := wconn.ReadFrom(io.LimitReader(bytes.NewReader(data), int64(len(data))))
+		if err != nil || n != int64(len(data)) {
+			panic(fmt.Sprintf("read from: n=%d, err=%v", n, err))
+		}
+		wconn.Close()
+	}()
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, rconn)
+	MustNil(t, err)
+	Equal(t, n, int64(len(data)))
+	MustTrue(t, bytes.Equal(buf.Bytes(), data))
+}
@@ -0,0 +1,136 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"sync"
+	"syscall"
+)
+
+// defaultZeroCopyThreshold is the minimum size of a flush to be sent by zerocopy.
+// Pinning pages and handling the completion notification cost more than copying small payloads.
+const defaultZeroCopyThreshold = 16 * block1k
+
+// zerocopy tracks the output sent by MSG_ZEROCOPY.
+// The kernel still references the memory after sendmsg returns, so the sent nodes are held
+// until the completion notification is read from the error queue, and can't be reused before that.
+type zerocopy struct {
+	threshold int // zerocopy is disabled if threshold <= 0
+	sending   bool
+	fallback  bool
+
+	mu      sync.Mutex
+	seq     uint32 // the number of the next zerocopy send, same as the kernel counter
+	pending []zerocopySend
+}
+
+type zerocopySend struct {
+	seq uint32
+	buf *LinkBuffer
+}
+
+// zeroCopyPoll is implemented by the polls which read the completions of zerocopy from the error queue.
+type zeroCopyPoll interface {
+	errqueue(operator *FDOperator) (ok bool)
+}
+
+// initZeroCopy enables SO_ZEROCOPY on the socket if the option is set and the poll supports it.
+func (c *connection) initZeroCopy(opts *options) {
+	if opts == nil || opts.zeroCopyThreshold <= 0 {
+		return
+	}
+	if _, ok := c.operator.poll.(zeroCopyPoll); !ok {
+		return
+	}
+	if setZeroCopy(c.fd) != nil {
+		return
+	}
+	c.supportZeroCopy = true
+	c.zerocopy.threshold = opts.zeroCopyThreshold
+}
+
+// useZeroCopy decides whether to send the output of size by zerocopy.
+func (c *connection) useZeroCopy(size int) bool {
+	return c.supportZeroCopy && size >= c.zerocopy.threshold
+}
+
+// skipOutput drops the n bytes sent from the output buffer.
+// If the bytes are sent by zerocopy, they will be held until acked by the kernel.
+func (c *connection) skipOutput(n int, zerocopy bool) (err error) {
+	defer c.completeFlush(n)
+	if !zerocopy {
+		err = c.outputBuffer.Skip(n)
+		c.outputBuffer.Release()
+		return err
+	}
+	// Slice refers the sent nodes and releases the output buffer.
+	r, err := c.outputBuffer.Slice(n)
+	if err != nil {
+		return err
+	}
+	zc := &c.zerocopy
+	zc.mu.Lock()
+	zc.pending = append(zc.pending, zerocopySend{seq: zc.seq, buf: r.(*LinkBuffer)})
+	zc.seq++
+	zc.mu.Unlock()
+	return nil
+}
+
+// zeroCopyAck implements FDOperator, releases the zerocopy sends numbered in [lo, hi].
+func (c *connection) zeroCopyAck(lo, hi uint32) {
+	zc := &c.zerocopy
+	zc.mu.Lock()
+	var i int
+	// the sequence numbers are increasing and may wrap around.
+	for i = 0; i < len(zc.pending) && int32(zc.pending[i].seq-hi) <= 0; i++ {
+		zc.pending[i].buf.Close()
+		zc.pending[i].buf = nil
+	}
+	zc.pending = zc.pending[:copy(zc.pending, zc.pending[i:])]
+	zc.mu.Unlock()
+}
+
+// zeroCopyPending returns the number of zerocopy sends that have not been acked.
+func (c *connection) zeroCopyPending() int {
+	c.zerocopy.mu.Lock()
+	defer c.zerocopy.mu.Unlock()
+	return len(c.zerocopy.pending)
+}
+
+// closeZeroCopy is called before the fd is closed, and drains the acks that have arrived.
+// The sends still pending are given up to GC instead of being recycled into mcache,
+// since the kernel may still be referencing them.
+func (c *connection) closeZeroCopy() {
+	if !c.supportZeroCopy || c.zeroCopyPending() == 0 {
+		return
+	}
+	var oob = make([]byte, zeroCopyOOBSize)
+	for {
+		lo, hi, err := recvZeroCopyAck(c.fd, oob)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			break
+		}
+		c.zeroCopyAck(lo, hi)
+	}
+	c.zerocopy.mu.Lock()
+	c.zerocopy.pending = nil
+	c.zerocopy.mu.Unlock()
+}
@@ -92,3 +92,10 @@ type OnConnect func(ctx context.Context, connection Connection) context.Context
 //
 // Return: error is unused which will be ignored directly.
 type OnRequest func(ctx context.Context, connection Connection) error
+
+// OnWritable is called when the pending output of connection has been drained below the low water mark
+// after a write exceeded the limit set by WithOutputLimit, so that the async producers can resume writing.
+// It is executed asynchronously by the Executor of connection.
+//
+// Return: error is unused which will be ignored directly.
+type OnWritable func(ctx context.Context, connection Connection) error
@@ -16,6 +16,7 @@ package netpoll
 
 import (
 	"runtime"
+	"sync"
 	"sync/atomic"
 )
 
@@ -30,6 +31,14 @@ type FDOperator struct {
 	OnWrite func(p Poll) error
 	OnHup   func(p Poll) error
 
+	// OnRdHup is optional, and is called instead of OnHup if only the peer has shut down its writing,
+	// e.g. EPOLLRDHUP without EPOLLHUP. The operator must stop reading, since the EOF is always readable.
+	OnRdHup func(p Poll) error
+
+	// OnAccept is optional, and is called with the conn fd instead of OnRead,
+	// if the poll accepts conns by itself, e.g. the multishot accept of io_uring.
+	OnAccept func(fd int, p Poll) error
+
 	// The following is the required fn, which must exist when used, or directly panic.
 	// Fns are only called by the poll when handles connection events.
 	Inputs   func(vs [][]byte) (rs [][]byte)
@@ -39,17 +48,90 @@ type FDOperator struct {
 	Outputs   func(vs [][]byte) (rs [][]byte, supportZeroCopy bool)
 	OutputAck func(n int) (err error)
 
-	// poll is the registered location of the file descriptor.
+	// ZeroCopyAck is optional, and is called when the kernel notifies that
+	// the zerocopy sends numbered in [lo, hi] have completed.
+	ZeroCopyAck func(lo, hi uint32)
+
+	// OutputFile is optional, and is called to send the file at the head of the output
+	// when Outputs returns no bytes.
+	OutputFile func() (err error)
+
+	// poll is the registered location of the file descriptor, which may be changed by migrate.
 	poll Poll
 
+	// mu serializes PollPauseRead, PollResumeRead and migrate, and protects poll and event.
+	// PollR2RW and PollRW2R, which are serialized by the connection, don't take mu unless it's locked.
+	mu sync.Mutex
+	// locked is 1 if mu is held by lock, and controls counts the Controls in progress without mu.
+	locked   int32
+	controls int32
+	// event is the last event controlled successfully.
+	event PollEvent
+	// events counts the events handled by poll, which is used to find the hot operators.
+	events uint64
+	// paused is 1 if the readable monitor is removed by PollPauseRead, changed with mu held.
+	paused int32
+
 	// private, used by operatorCache
 	next  *FDOperator
-	state int32 // CAS: 0(unused) 1(inuse) 2(do-done)
-	index int32 // index in operatorCache
+	cache *operatorCache // the cache allocating the operator
+	state int32          // CAS: 0(unused) 1(inuse) 2(do-done)
+	index int32          // index in operatorCache
 }
 
 func (op *FDOperator) Control(event PollEvent) error {
-	return op.poll.Control(op, event)
+	switch event {
+	case PollR2RW, PollRW2R:
+		atomic.AddInt32(&op.controls, 1)
+		if atomic.LoadInt32(&op.locked) == 0 {
+			var err = op.control(event)
+			atomic.AddInt32(&op.controls, -1)
+			return err
+		}
+		atomic.AddInt32(&op.controls, -1)
+	}
+	op.lock()
+	defer op.unlock()
+	switch event {
+	case PollPauseRead, PollResumeRead:
+		var paused int32
+		if event == PollPauseRead {
+			paused = 1
+		}
+		if atomic.LoadInt32(&op.paused) == paused {
+			return nil
+		}
+		atomic.StoreInt32(&op.paused, paused)
+		var err = op.poll.Control(op, event)
+		if err != nil {
+			atomic.StoreInt32(&op.paused, 1-paused)
+		}
+		return err
+	}
+	return op.control(event)
+}
+
+func (op *FDOperator) control(event PollEvent) error {
+	var err = op.poll.Control(op, event)
+	if err == nil {
+		op.event = event
+	}
+	return err
+}
+
+// lock locks mu, and waits for the Controls in progress without mu,
+// so that all Controls take mu until unlock.
+func (op *FDOperator) lock() {
+	op.mu.Lock()
+	atomic.StoreInt32(&op.locked, 1)
+	for atomic.LoadInt32(&op.controls) > 0 {
+		runtime.Gosched()
+	}
+}
+
+func (op *FDOperator) unlock() {
+	atomic.StoreInt32(&op.locked, 0)
+	op.mu.Unlock()
 }
 
 func (op *FDOperator) Free() {
@@ -57,7 +139,11 @@ func (op *FDOperator) Free() {
 }
 
 func (op *FDOperator) do() (can bool) {
-	return atomic.CompareAndSwapInt32(&op.state, 1, 2)
+	if atomic.CompareAndSwapInt32(&op.state, 1, 2) {
+		atomic.AddUint64(&op.events, 1)
+		return true
+	}
+	return false
 }
 
 func (op *FDOperator) done() {
@@ -86,10 +172,18 @@ func (op *FDOperator) isUnused() bool {
 	return atomic.LoadInt32(&op.state) == 0
 }
 
+func (op *FDOperator) isPaused() bool {
+	return atomic.LoadInt32(&op.paused) == 1
+}
+
 func (op *FDOperator) reset() {
 	op.FD = 0
-	op.OnRead, op.OnWrite, op.OnHup = nil, nil, nil
+	op.OnRead, op.OnWrite, op.OnHup, op.OnAccept = nil, nil, nil, nil
+	op.OnRdHup = nil
 	op.Inputs, op.InputAck = nil, nil
 	op.Outputs, op.OutputAck = nil, nil
-	op.poll = nil
+	op.ZeroCopyAck, op.OutputFile = nil, nil
+	op.poll, op.event = nil, 0
+	atomic.StoreUint64(&op.events, 0)
+	atomic.StoreInt32(&op.paused, 0)
 }
@@ -35,6 +35,8 @@ type operatorCache struct {
 	// to reduce GC pressure, we only store op index here
 	freelist   []int32
 	freelocked int32
+	// foreign stores the freeable operators allocated by other caches, which are migrated to this poll.
+	foreign []*FDOperator
 }
 
 func (c *operatorCache) alloc() *FDOperator {
@@ -47,7 +49,7 @@ func (c *operatorCache) alloc() *FDOperator {
 		}
 		index := int32(len(c.cache))
 		for i := uintptr(0); i < n; i++ {
-			pd := &FDOperator{index: index}
+			pd := &FDOperator{index: index, cache: c}
 			c.cache = append(c.cache, pd)
 			pd.next = c.first
 			c.first = pd
@@ -67,13 +69,26 @@ func (c *operatorCache) freeable(op *FDOperator) {
 	op.unused()
 	op.reset()
 	lock(&c.freelocked)
-	c.freelist = append(c.freelist, op.index)
+	if op.cache == c {
+		c.freelist = append(c.freelist, op.index)
+	} else if op.cache != nil {
+		c.foreign = append(c.foreign, op)
+	}
 	unlock(&c.freelocked)
 }
 
 func (c *operatorCache) free() {
 	lock(&c.freelocked)
 	defer unlock(&c.freelocked)
+	// the foreign operators are given back to the caches allocating them.
+	for i, op := range c.foreign {
+		lock(&op.cache.locked)
+		op.next = op.cache.first
+		op.cache.first = op
+		unlock(&op.cache.locked)
+		c.foreign[i] = nil
+	}
+	c.foreign = c.foreign[:0]
 	if len(c.freelist) == 0 {
 		return
 	}
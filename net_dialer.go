@@ -29,13 +29,27 @@ func DialConnection(network, address string, timeout time.Duration) (connection
 }
 
 // NewDialer only support TCP and unix socket now.
-func NewDialer() Dialer {
-	return &dialer{}
+// The connections are registered to the default pollers, unless WithPollManager is used,
+// and only WithHalfClose, WithLinger and WithFlushOnClose of the other options work for Dialer.
+func NewDialer(ops ...Option) Dialer {
+	var opts = &options{}
+	for _, do := range ops {
+		do.f(opts)
+	}
+	return &dialer{opts: &options{
+		manager:      opts.manager,
+		halfClose:    opts.halfClose,
+		linger:       opts.linger,
+		lingerSet:    opts.lingerSet,
+		closeTimeout: opts.closeTimeout,
+	}}
 }
 
 var defaultDialer = NewDialer()
 
-type dialer struct{}
+type dialer struct {
+	opts *options
+}
 
 // DialTimeout implements Dialer.
 func (d *dialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
@@ -59,7 +73,7 @@ func (d *dialer) DialConnection(network, address string, timeout time.Duration)
 		raddr := &UnixAddr{
 			UnixAddr: net.UnixAddr{Name: address, Net: network},
 		}
-		return DialUnix(network, nil, raddr)
+		return dialUnix(network, nil, raddr, d.opts)
 	default:
 		return nil, net.UnknownNetworkError(network)
 	}
@@ -95,9 +109,9 @@ func (d *dialer) dialTCP(ctx context.Context, network, address string) (connecti
 		tcpAddr.Port = portnum
 		tcpAddr.Zone = ipaddr.Zone
 		if ipaddr.IP != nil && ipaddr.IP.To4() == nil {
-			connection, err = DialTCP(ctx, "tcp6", nil, tcpAddr)
+			connection, err = dialTCP(ctx, "tcp6", nil, tcpAddr, d.opts)
 		} else {
-			connection, err = DialTCP(ctx, "tcp", nil, tcpAddr)
+			connection, err = dialTCP(ctx, "tcp", nil, tcpAddr, d.opts)
 		}
 		if err == nil {
 			return connection, nil
@@ -122,4 +136,13 @@ func (d *dialer) dialTCP(ctx context.Context, network, address string) (connecti
 type sysDialer struct {
 	net.Dialer
 	network, address string
+	opts             *options // the options of connections, nil means the default
+}
+
+// pollManager returns the pollers registering the connections, nil means the default.
+func (sd *sysDialer) pollManager() *manager {
+	if sd.opts == nil {
+		return nil
+	}
+	return sd.opts.manager
 }
@@ -104,6 +104,21 @@ func (ln *listener) Accept() (net.Conn, error) {
 	return nfd, nil
 }
 
+// newConn wraps the fd accepted by the poll, and closes it if failed.
+func (ln *listener) newConn(fd int) (net.Conn, error) {
+	var sa, err = syscall.Getpeername(fd)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	var nfd = &netFD{}
+	nfd.fd = fd
+	nfd.localAddr = ln.addr
+	nfd.network = ln.addr.Network()
+	nfd.remoteAddr = sockaddrToAddr(sa)
+	return nfd, nil
+}
+
 // TODO: UDPAccept Not implemented.
 func (ln *listener) UDPAccept() (net.Conn, error) {
 	return nil, Exception(ErrUnsupported, "UDP")
@@ -50,6 +50,16 @@ type netFD struct {
 	network       string // tcp tcp4 tcp6, udp, udp4, udp6, ip, ip4, ip6, unix, unixgram, unixpacket
 	localAddr     net.Addr
 	remoteAddr    net.Addr
+	// manager is the pollers registering the fd, nil means the default pollers.
+	manager *manager
+}
+
+// pollManager returns the pollers registering the fd.
+func (c *netFD) pollManager() *manager {
+	if c.manager != nil {
+		return c.manager
+	}
+	return pollmanager
 }
 
 func newNetFD(fd, family, sotype int, net string) *netFD {
@@ -134,7 +144,7 @@ func (c *netFD) connect(ctx context.Context, la, ra syscall.Sockaddr) (rsa sysca
 		return nil, os.NewSyscallError("connect", err)
 	}
 
-	c.pd = newPollDesc(c.fd)
+	c.pd = newPollDesc(c.fd, c.pollManager())
 	for {
 		// Performing multiple connect system calls on a
 		// non-blocking socket under Unix variants does not
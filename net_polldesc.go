@@ -22,9 +22,9 @@ import (
 )
 
 // TODO: recycle *pollDesc
-func newPollDesc(fd int) *pollDesc {
+func newPollDesc(fd int, m *manager) *pollDesc {
 	pd := &pollDesc{}
-	poll := pollmanager.Pick()
+	poll := m.Pick()
 	op := poll.Alloc()
 	op.FD = fd
 	op.OnWrite = pd.onwrite
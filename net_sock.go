@@ -39,12 +39,12 @@ type sockaddr interface {
 	toLocal(net string) sockaddr
 }
 
-func internetSocket(ctx context.Context, net string, laddr, raddr sockaddr, sotype, proto int, mode string) (conn *netFD, err error) {
+func internetSocket(ctx context.Context, net string, laddr, raddr sockaddr, sotype, proto int, mode string, m *manager) (conn *netFD, err error) {
 	if (runtime.GOOS == "aix" || runtime.GOOS == "windows" || runtime.GOOS == "openbsd" || runtime.GOOS == "nacl") && raddr.isWildcard() {
 		raddr = raddr.toLocal(net)
 	}
 	family, ipv6only := favoriteAddrFamily(net, laddr, raddr)
-	return socket(ctx, net, family, sotype, proto, ipv6only, laddr, raddr)
+	return socket(ctx, net, family, sotype, proto, ipv6only, laddr, raddr, m)
 }
 
 // favoriteAddrFamily returns the appropriate address family for the
@@ -101,8 +101,8 @@ func favoriteAddrFamily(network string, laddr, raddr sockaddr) (family int, ipv6
 }
 
 // socket returns a network file descriptor that is ready for
-// asynchronous I/O using the network poller.
-func socket(ctx context.Context, net string, family, sotype, proto int, ipv6only bool, laddr, raddr sockaddr) (netfd *netFD, err error) {
+// asynchronous I/O using the network poller of m, or the default one if m is nil.
+func socket(ctx context.Context, net string, family, sotype, proto int, ipv6only bool, laddr, raddr sockaddr, m *manager) (netfd *netFD, err error) {
 	// syscall.Socket & set socket options
 	var fd int
 	fd, err = sysSocket(family, sotype, proto)
@@ -116,6 +116,7 @@ func socket(ctx context.Context, net string, family, sotype, proto int, ipv6only
 	}
 
 	netfd = newNetFD(fd, family, sotype, net)
+	netfd.manager = m
 	err = netfd.dial(ctx, laddr, raddr)
 	if err != nil {
 		netfd.Close()
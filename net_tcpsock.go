@@ -138,9 +138,9 @@ type TCPConnection struct {
 }
 
 // newTCPConnection wraps *TCPConnection.
-func newTCPConnection(conn Conn) (connection *TCPConnection, err error) {
+func newTCPConnection(conn Conn, opts *options) (connection *TCPConnection, err error) {
 	connection = &TCPConnection{}
-	err = connection.init(conn, nil)
+	err = connection.init(conn, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -155,6 +155,11 @@ func newTCPConnection(conn Conn) (connection *TCPConnection, err error) {
 // If the IP field of raddr is nil or an unspecified IP address, the
 // local system is assumed.
 func DialTCP(ctx context.Context, network string, laddr, raddr *TCPAddr) (*TCPConnection, error) {
+	return dialTCP(ctx, network, laddr, raddr, nil)
+}
+
+// dialTCP dials with the options of Dialer, or the default options if opts is nil.
+func dialTCP(ctx context.Context, network string, laddr, raddr *TCPAddr, opts *options) (*TCPConnection, error) {
 	switch network {
 	case "tcp", "tcp4", "tcp6":
 	default:
@@ -166,7 +171,7 @@ func DialTCP(ctx context.Context, network string, laddr, raddr *TCPAddr) (*TCPCo
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	sd := &sysDialer{network: network, address: raddr.String()}
+	sd := &sysDialer{network: network, address: raddr.String(), opts: opts}
 	c, err := sd.dialTCP(ctx, laddr, raddr)
 	if err != nil {
 		return nil, &net.OpError{Op: "dial", Net: network, Source: laddr.opAddr(), Addr: raddr.opAddr(), Err: err}
@@ -175,7 +180,7 @@ func DialTCP(ctx context.Context, network string, laddr, raddr *TCPAddr) (*TCPCo
 }
 
 func (sd *sysDialer) dialTCP(ctx context.Context, laddr, raddr *TCPAddr) (*TCPConnection, error) {
-	conn, err := internetSocket(ctx, sd.network, laddr, raddr, syscall.SOCK_STREAM, 0, "dial")
+	conn, err := internetSocket(ctx, sd.network, laddr, raddr, syscall.SOCK_STREAM, 0, "dial", sd.pollManager())
 
 	// TCP has a rarely used mechanism called a 'simultaneous connection' in
 	// which Dial("tcp", addr1, addr2) run on the machine at addr1 can
@@ -205,13 +210,13 @@ func (sd *sysDialer) dialTCP(ctx context.Context, laddr, raddr *TCPAddr) (*TCPCo
 		if err == nil {
 			conn.Close()
 		}
-		conn, err = internetSocket(ctx, sd.network, laddr, raddr, syscall.SOCK_STREAM, 0, "dial")
+		conn, err = internetSocket(ctx, sd.network, laddr, raddr, syscall.SOCK_STREAM, 0, "dial", sd.pollManager())
 	}
 
 	if err != nil {
 		return nil, err
 	}
-	return newTCPConnection(conn)
+	return newTCPConnection(conn, sd.opts)
 }
 
 func selfConnect(conn *netFD, err error) bool {
@@ -74,9 +74,9 @@ type UnixConnection struct {
 }
 
 // newUnixConnection wraps UnixConnection.
-func newUnixConnection(conn Conn) (connection *UnixConnection, err error) {
+func newUnixConnection(conn Conn, opts *options) (connection *UnixConnection, err error) {
 	connection = &UnixConnection{}
-	err = connection.init(conn, nil)
+	err = connection.init(conn, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -90,12 +90,17 @@ func newUnixConnection(conn Conn) (connection *UnixConnection, err error) {
 // If laddr is non-nil, it is used as the local address for the
 // connection.
 func DialUnix(network string, laddr, raddr *UnixAddr) (*UnixConnection, error) {
+	return dialUnix(network, laddr, raddr, nil)
+}
+
+// dialUnix dials with the options of Dialer, or the default options if opts is nil.
+func dialUnix(network string, laddr, raddr *UnixAddr, opts *options) (*UnixConnection, error) {
 	switch network {
 	case "unix", "unixgram", "unixpacket":
 	default:
 		return nil, &net.OpError{Op: "dial", Net: network, Source: laddr.opAddr(), Addr: raddr.opAddr(), Err: net.UnknownNetworkError(network)}
 	}
-	sd := &sysDialer{network: network, address: raddr.String()}
+	sd := &sysDialer{network: network, address: raddr.String(), opts: opts}
 	c, err := sd.dialUnix(context.Background(), laddr, raddr)
 	if err != nil {
 		return nil, &net.OpError{Op: "dial", Net: network, Source: laddr.opAddr(), Addr: raddr.opAddr(), Err: err}
@@ -104,14 +109,14 @@ func DialUnix(network string, laddr, raddr *UnixAddr) (*UnixConnection, error) {
 }
 
 func (sd *sysDialer) dialUnix(ctx context.Context, laddr, raddr *UnixAddr) (*UnixConnection, error) {
-	conn, err := unixSocket(ctx, sd.network, laddr, raddr, "dial")
+	conn, err := unixSocket(ctx, sd.network, laddr, raddr, "dial", sd.pollManager())
 	if err != nil {
 		return nil, err
 	}
-	return newUnixConnection(conn)
+	return newUnixConnection(conn, sd.opts)
 }
 
-func unixSocket(ctx context.Context, network string, laddr, raddr sockaddr, mode string) (conn *netFD, err error) {
+func unixSocket(ctx context.Context, network string, laddr, raddr sockaddr, mode string, m *manager) (conn *netFD, err error) {
 	var sotype int
 	switch network {
 	case "unix":
@@ -140,5 +145,5 @@ func unixSocket(ctx context.Context, network string, laddr, raddr sockaddr, mode
 		return nil, errors.New("unknown mode: " + mode)
 	}
 
-	return socket(ctx, network, syscall.AF_UNIX, sotype, 0, false, laddr, raddr)
+	return socket(ctx, network, syscall.AF_UNIX, sotype, 0, false, laddr, raddr, m)
 }
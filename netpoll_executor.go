@@ -0,0 +1,213 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// Executor runs the tasks of connections, which call OnConnect and OnRequest serially.
+// It can be registered to EventLoop by WithExecutor, and the default is GopoolExecutor.
+//
+// If Execute returns an error, the task is not run and the connection will be closed.
+type Executor interface {
+	Execute(ctx context.Context, task func()) error
+}
+
+// ExecutorMetrics is implemented by the Executors that queue tasks, such as NewWorkerPoolExecutor.
+type ExecutorMetrics interface {
+	// QueueDepth returns the number of tasks waiting in the queue.
+	QueueDepth() int
+	// Workers returns the number of running workers.
+	Workers() int
+	// Rejected returns the total number of rejected tasks.
+	Rejected() uint64
+}
+
+// RejectPolicy decides what to do when the queue of the worker pool is full.
+type RejectPolicy int
+
+const (
+	// RejectAbort returns ErrTaskRejected, and the connection will be closed.
+	RejectAbort RejectPolicy = iota
+	// RejectCallerRuns runs the task in the caller goroutine, which is usually the poller.
+	RejectCallerRuns
+)
+
+var defaultExecutor Executor = GopoolExecutor()
+
+func disableGopool() error {
+	defaultExecutor = GoExecutor()
+	return nil
+}
+
+// GopoolExecutor runs tasks by gopool, which reuses goroutines to avoid stack expansion.
+func GopoolExecutor() Executor {
+	return gopoolExecutor{}
+}
+
+// GoExecutor runs each task in a new goroutine.
+func GoExecutor() Executor {
+	return goExecutor{}
+}
+
+// InlineExecutor runs tasks directly in the caller goroutine, which is usually the poller.
+// It is only suitable for very cheap handlers that never block,
+// because a running task delays all the other connections of the poller.
+// In particular, the handler must not wait for the data which has not been received,
+// nor flush more data than the socket buffer can hold, since both of them rely on the poller.
+func InlineExecutor() Executor {
+	return inlineExecutor{}
+}
+
+// NewWorkerPoolExecutor creates an Executor with at most workers goroutines and a queue of queueSize tasks.
+// Workers are started on demand and exit when the queue is empty.
+// policy decides what to do when the queue is full.
+// The returned Executor implements ExecutorMetrics.
+func NewWorkerPoolExecutor(workers, queueSize int, policy RejectPolicy) (Executor, error) {
+	if workers <= 0 {
+		return nil, fmt.Errorf("set invalid workers[%d]", workers)
+	}
+	if queueSize < 0 {
+		return nil, fmt.Errorf("set invalid queueSize[%d]", queueSize)
+	}
+	switch policy {
+	case RejectAbort, RejectCallerRuns:
+	default:
+		return nil, fmt.Errorf("set invalid RejectPolicy[%d]", policy)
+	}
+	return &workerPool{
+		queue:      make(chan func(), queueSize),
+		maxWorkers: int32(workers),
+		policy:     policy,
+	}, nil
+}
+
+type gopoolExecutor struct{}
+
+func (gopoolExecutor) Execute(ctx context.Context, task func()) error {
+	gopool.CtxGo(ctx, task)
+	return nil
+}
+
+type goExecutor struct{}
+
+func (goExecutor) Execute(ctx context.Context, task func()) error {
+	go task()
+	return nil
+}
+
+type inlineExecutor struct{}
+
+func (inlineExecutor) Execute(ctx context.Context, task func()) error {
+	task()
+	return nil
+}
+
+type workerPool struct {
+	queue      chan func()
+	workers    int32
+	maxWorkers int32
+	policy     RejectPolicy
+	rejected   uint64
+}
+
+var _ ExecutorMetrics = &workerPool{}
+
+// Execute implements Executor.
+func (p *workerPool) Execute(ctx context.Context, task func()) error {
+	select {
+	case p.queue <- task:
+	default:
+		// the queue is full, or there is no queue, try to hand over to a new worker
+		if p.acquire() {
+			go p.worker(task)
+			return nil
+		}
+		atomic.AddUint64(&p.rejected, 1)
+		if p.policy == RejectCallerRuns {
+			p.run(task)
+			return nil
+		}
+		return Exception(ErrTaskRejected, "when queue is full")
+	}
+	if p.acquire() {
+		go p.worker(nil)
+	}
+	return nil
+}
+
+// QueueDepth implements ExecutorMetrics.
+func (p *workerPool) QueueDepth() int {
+	return len(p.queue)
+}
+
+// Workers implements ExecutorMetrics.
+func (p *workerPool) Workers() int {
+	return int(atomic.LoadInt32(&p.workers))
+}
+
+// Rejected implements ExecutorMetrics.
+func (p *workerPool) Rejected() uint64 {
+	return atomic.LoadUint64(&p.rejected)
+}
+
+// acquire reserves a worker if the limit is not reached.
+func (p *workerPool) acquire() bool {
+	for {
+		n := atomic.LoadInt32(&p.workers)
+		if n >= p.maxWorkers {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&p.workers, n, n+1) {
+			return true
+		}
+	}
+}
+
+func (p *workerPool) worker(task func()) {
+	if task != nil {
+		p.run(task)
+	}
+	for {
+		select {
+		case task = <-p.queue:
+			p.run(task)
+		default:
+			atomic.AddInt32(&p.workers, -1)
+			// double check the task queued before the worker exits
+			if len(p.queue) == 0 || !p.acquire() {
+				return
+			}
+		}
+	}
+}
+
+func (p *workerPool) run(task func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Printf("NETPOLL: panic in executor: %v: %s", r, debug.Stack())
+		}
+	}()
+	task()
+}
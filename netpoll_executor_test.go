@@ -0,0 +1,191 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolExecutor(t *testing.T) {
+	_, err := NewWorkerPoolExecutor(0, 1, RejectAbort)
+	MustTrue(t, err != nil)
+	_, err = NewWorkerPoolExecutor(1, -1, RejectAbort)
+	MustTrue(t, err != nil)
+	_, err = NewWorkerPoolExecutor(1, 1, RejectPolicy(10))
+	MustTrue(t, err != nil)
+
+	executor, err := NewWorkerPoolExecutor(1, 1, RejectAbort)
+	MustNil(t, err)
+	var metrics = executor.(ExecutorMetrics)
+	var block, started = make(chan struct{}), make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	MustNil(t, executor.Execute(context.Background(), func() {
+		close(started)
+		<-block
+		wg.Done()
+	}))
+	<-started
+	Equal(t, metrics.Workers(), 1)
+	MustNil(t, executor.Execute(context.Background(), func() { wg.Done() }))
+	Equal(t, metrics.QueueDepth(), 1)
+	// the worker is busy and the queue is full
+	err = executor.Execute(context.Background(), func() {})
+	MustTrue(t, errors.Is(err, ErrTaskRejected))
+	Equal(t, metrics.Rejected(), uint64(1))
+	close(block)
+	wg.Wait()
+	for metrics.Workers() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	Equal(t, metrics.QueueDepth(), 0)
+
+	// caller runs
+	executor, err = NewWorkerPoolExecutor(1, 0, RejectCallerRuns)
+	MustNil(t, err)
+	metrics = executor.(ExecutorMetrics)
+	block, started = make(chan struct{}), make(chan struct{})
+	MustNil(t, executor.Execute(context.Background(), func() {
+		close(started)
+		<-block
+	}))
+	<-started
+	var inline bool
+	MustNil(t, executor.Execute(context.Background(), func() { inline = true }))
+	MustTrue(t, inline)
+	Equal(t, metrics.Rejected(), uint64(1))
+	close(block)
+
+	// panic is recovered
+	executor, err = NewWorkerPoolExecutor(4, 16, RejectAbort)
+	MustNil(t, err)
+	var sum int32
+	wg.Add(100)
+	for i := 0; i < 100; i++ {
+		err = executor.Execute(context.Background(), func() {
+			defer wg.Done()
+			if atomic.AddInt32(&sum, 1) == 50 {
+				panic("test")
+			}
+		})
+		if err != nil {
+			// rejected, run it here
+			atomic.AddInt32(&sum, 1)
+			wg.Done()
+		}
+	}
+	wg.Wait()
+	Equal(t, atomic.LoadInt32(&sum), int32(100))
+}
+
+type testExecutor struct {
+	executed int32
+	reject   int32
+}
+
+func (e *testExecutor) Execute(ctx context.Context, task func()) error {
+	atomic.AddInt32(&e.executed, 1)
+	if atomic.LoadInt32(&e.reject) == 1 {
+		return Exception(ErrTaskRejected, "by test")
+	}
+	go task()
+	return nil
+}
+
+func TestEventLoopExecutor(t *testing.T) {
+	var network, address = "tcp", ":18894"
+	var executor = &testExecutor{}
+	var loop = newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			input, err := connection.Reader().Next(connection.Reader().Len())
+			if err != nil {
+				return err
+			}
+			connection.Writer().WriteBinary(input)
+			return connection.Writer().Flush()
+		},
+		WithExecutor(executor),
+	)
+	defer loop.Shutdown(context.Background())
+	time.Sleep(10 * time.Millisecond)
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	_, err = conn.Writer().WriteString("hello")
+	MustNil(t, err)
+	MustNil(t, conn.Writer().Flush())
+	output, err := conn.Reader().Next(5)
+	MustNil(t, err)
+	Equal(t, string(output), "hello")
+	MustTrue(t, atomic.LoadInt32(&executor.executed) > 0)
+	MustNil(t, conn.Close())
+
+	// the connection is closed if the task is rejected
+	atomic.StoreInt32(&executor.reject, 1)
+	conn, err = DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	_, err = conn.Writer().WriteString("hello")
+	MustNil(t, err)
+	MustNil(t, conn.Writer().Flush())
+	_, err = conn.Reader().Next(5)
+	MustTrue(t, err != nil)
+	MustNil(t, conn.Close())
+}
+
+func TestEventLoopInlineExecutor(t *testing.T) {
+	var network, address = "tcp", ":18895"
+	var loop = newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			input, err := connection.Reader().Next(connection.Reader().Len())
+			if err != nil {
+				return err
+			}
+			if string(input) == "close" {
+				return connection.Close()
+			}
+			connection.Writer().WriteBinary(input)
+			return connection.Writer().Flush()
+		},
+		WithExecutor(InlineExecutor()),
+	)
+	defer loop.Shutdown(context.Background())
+	time.Sleep(10 * time.Millisecond)
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	for i := 0; i < 10; i++ {
+		_, err = conn.Writer().WriteString("hello")
+		MustNil(t, err)
+		MustNil(t, conn.Writer().Flush())
+		output, err := conn.Reader().Next(5)
+		MustNil(t, err)
+		Equal(t, string(output), "hello")
+	}
+	// close in the poller
+	_, err = conn.Writer().WriteString("close")
+	MustNil(t, err)
+	MustNil(t, conn.Writer().Flush())
+	_, err = conn.Reader().Next(1)
+	MustTrue(t, err != nil)
+}
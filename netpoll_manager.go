@@ -0,0 +1,102 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"errors"
+	"time"
+)
+
+// PollManager manages a group of pollers with its own settings, so that the components in one process,
+// e.g. an internal RPC server and a latency-sensitive gateway, can be isolated with their own pollers.
+// The EventLoop and Dialer use the pollers of PollManager by WithPollManager.
+//
+// The package-level settings, e.g. SetNumLoops, apply to the default PollManager,
+// which is used by the EventLoop and Dialer without WithPollManager.
+type PollManager struct {
+	m *manager
+}
+
+// NewPollManager creates a PollManager with numLoops pollers, the pollers are balanced by RoundRobin by default.
+func NewPollManager(numLoops int) (*PollManager, error) {
+	var m = &manager{}
+	m.SetLoadBalance(RoundRobin)
+	if err := m.SetNumLoops(numLoops); err != nil {
+		return nil, err
+	}
+	return &PollManager{m: m}, nil
+}
+
+// DefaultPollManager returns the default PollManager.
+func DefaultPollManager() *PollManager {
+	return &PollManager{m: pollmanager}
+}
+
+// SetNumLoops is like the package-level SetNumLoops.
+func (pm *PollManager) SetNumLoops(numLoops int) error {
+	return pm.m.SetNumLoops(numLoops)
+}
+
+// SetLoadBalance is like the package-level SetLoadBalance.
+func (pm *PollManager) SetLoadBalance(lb LoadBalance) error {
+	return pm.m.SetLoadBalance(lb)
+}
+
+// SetLoadBalancer is like the package-level SetLoadBalancer.
+func (pm *PollManager) SetLoadBalancer(lb LoadBalancer) error {
+	return pm.m.SetLoadBalancer(lb)
+}
+
+// SetPollType is like the package-level SetPollType.
+func (pm *PollManager) SetPollType(t PollType) error {
+	return pm.m.SetPollType(t)
+}
+
+// SetPollerLockOSThread is like the package-level SetPollerLockOSThread.
+func (pm *PollManager) SetPollerLockOSThread(lock bool) error {
+	return pm.m.SetLockOSThread(lock)
+}
+
+// SetPollerAffinity is like the package-level SetPollerAffinity.
+func (pm *PollManager) SetPollerAffinity(cpus ...int) error {
+	return pm.m.SetAffinity(cpus)
+}
+
+// SetBusyPoll is like the package-level SetBusyPoll.
+func (pm *PollManager) SetBusyPoll(timeout time.Duration) error {
+	return pm.m.SetBusyPoll(timeout)
+}
+
+// SetAutoScale is like the package-level SetAutoScale.
+func (pm *PollManager) SetAutoScale(min, max int) error {
+	return pm.m.SetAutoScale(min, max)
+}
+
+// Rebalance is like the package-level Rebalance.
+func (pm *PollManager) Rebalance() error {
+	return pm.m.Rebalance()
+}
+
+// Close closes all the pollers, it should be called after the EventLoops and connections using them are closed.
+// The default PollManager can't be closed.
+func (pm *PollManager) Close() error {
+	if pm.m == pollmanager {
+		return errors.New("the default PollManager can't be closed")
+	}
+	return pm.m.Close()
+}
@@ -0,0 +1,86 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func hasPoll(pm *PollManager, poll Poll) bool {
+	for _, p := range pm.m.polls {
+		if p == poll {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPollManagerIsolated(t *testing.T) {
+	svrpm, err := NewPollManager(2)
+	MustNil(t, err)
+	defer svrpm.Close()
+	clipm, err := NewPollManager(1)
+	MustNil(t, err)
+	defer clipm.Close()
+	_, err = NewPollManager(0)
+	MustTrue(t, err != nil)
+	MustTrue(t, DefaultPollManager().Close() != nil)
+
+	var network, address = "tcp", ":18893"
+	var accepted = make(chan Poll, 1)
+	var loop = newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			input, err := connection.Reader().Next(connection.Reader().Len())
+			if err != nil {
+				return err
+			}
+			connection.Writer().WriteBinary(input)
+			return connection.Writer().Flush()
+		},
+		WithOnConnect(func(ctx context.Context, conn Connection) context.Context {
+			// the operator is read before it is freed by the poller.
+			accepted <- conn.(*connection).operator.poll
+			return ctx
+		}),
+		WithPollManager(svrpm),
+	)
+	defer loop.Shutdown(context.Background())
+	time.Sleep(10 * time.Millisecond)
+
+	var dialer = NewDialer(WithPollManager(clipm))
+	conn, err := dialer.DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	MustTrue(t, hasPoll(clipm, conn.(*TCPConnection).operator.poll))
+	MustTrue(t, hasPoll(svrpm, <-accepted))
+
+	_, err = conn.Writer().WriteString("hello")
+	MustNil(t, err)
+	MustNil(t, conn.Writer().Flush())
+	output, err := conn.Reader().Next(5)
+	MustNil(t, err)
+	Equal(t, string(output), "hello")
+
+	// the settings are isolated.
+	MustNil(t, svrpm.SetLoadBalance(LeastConnections))
+	Equal(t, svrpm.m.balance.LoadBalance(), LeastConnections)
+	Equal(t, clipm.m.balance.LoadBalance(), RoundRobin)
+	Equal(t, pollmanager.balance.LoadBalance(), RoundRobin)
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"net"
+)
+
+// Observer is notified of the lifecycle events of connections, which is designed for
+// tracing and monitoring, e.g. attaching OpenTelemetry spans without forking netpoll.
+// It can be registered to EventLoop by WithObserver.
+//
+// All methods are called synchronously in the goroutine where the event happens
+// (the poller, the OnRequest task or the user goroutine), so they must be cheap and must not block.
+// An implementation can embed NoopObserver to only care about some of the events.
+type Observer interface {
+	// OnAccept is called when the server accepts a new conn, before the Connection is initialized.
+	OnAccept(conn net.Conn)
+
+	// OnPrepare is called when the connection is being prepared, before OnPrepare is executed.
+	OnPrepare(connection Connection)
+
+	// OnConnect is called before OnConnect is executed.
+	OnConnect(connection Connection)
+
+	// OnRequestStart and OnRequestEnd are called around each execution of OnRequest,
+	// and err is the return of OnRequest.
+	OnRequestStart(connection Connection)
+	OnRequestEnd(connection Connection, err error)
+
+	// OnFlushStart and OnFlushEnd are called around each flush of the output buffer.
+	// size is the number of bytes to be sent, n is the number of bytes actually sent.
+	// OnFlushEnd of Flush is called when it returns, after the poller has sent the rest if it can't be sent at once,
+	// and OnFlushEnd of FlushAsync is called before its callback, which may be in the goroutine of the poller.
+	OnFlushStart(connection Connection, size int)
+	OnFlushEnd(connection Connection, n int, err error)
+
+	// OnReadTimeout is called when waiting for read exceeds the read timeout.
+	OnReadTimeout(connection Connection)
+
+	// OnWriteTimeout is called when waiting for flush exceeds the write timeout.
+	OnWriteTimeout(connection Connection)
+
+	// OnHup is called when the connection is closed by the poller, e.g. hangup by the peer.
+	OnHup(connection Connection)
+
+	// OnClose is called when the connection is closed by the user.
+	OnClose(connection Connection)
+
+	// OnCloseCallback is called after all the CloseCallbacks of the connection have been executed.
+	OnCloseCallback(connection Connection)
+
+	// OnOutputLost is called when the connection is released with n bytes of output unsent,
+	// which is only reported if WithFlushOnClose is used.
+	OnOutputLost(connection Connection, n int)
+}
+
+// NoopObserver implements Observer and does nothing.
+type NoopObserver struct{}
+
+var _ Observer = NoopObserver{}
+
+// OnAccept implements Observer.
+func (NoopObserver) OnAccept(conn net.Conn) {}
+
+// OnPrepare implements Observer.
+func (NoopObserver) OnPrepare(connection Connection) {}
+
+// OnConnect implements Observer.
+func (NoopObserver) OnConnect(connection Connection) {}
+
+// OnRequestStart implements Observer.
+func (NoopObserver) OnRequestStart(connection Connection) {}
+
+// OnRequestEnd implements Observer.
+func (NoopObserver) OnRequestEnd(connection Connection, err error) {}
+
+// OnFlushStart implements Observer.
+func (NoopObserver) OnFlushStart(connection Connection, size int) {}
+
+// OnFlushEnd implements Observer.
+func (NoopObserver) OnFlushEnd(connection Connection, n int, err error) {}
+
+// OnReadTimeout implements Observer.
+func (NoopObserver) OnReadTimeout(connection Connection) {}
+
+// OnWriteTimeout implements Observer.
+func (NoopObserver) OnWriteTimeout(connection Connection) {}
+
+// OnHup implements Observer.
+func (NoopObserver) OnHup(connection Connection) {}
+
+// OnClose implements Observer.
+func (NoopObserver) OnClose(connection Connection) {}
+
+// OnCloseCallback implements Observer.
+func (NoopObserver) OnCloseCallback(connection Connection) {}
+
+// OnOutputLost implements Observer.
+func (NoopObserver) OnOutputLost(connection Connection, n int) {}
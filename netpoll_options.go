@@ -27,6 +27,8 @@ import (
 // If the number of cores in your service process is less than 20c, theoretically only one poller is needed.
 // Otherwise you may need to adjust the number of pollers to achieve the best results.
 // Experience recommends assigning a poller every 20c.
+// If numLoops is decreased, the connections of the redundant pollers are moved to the others,
+// and the redundant pollers are closed after they become empty.
 //
 // You can only use SetNumLoops before any connection is created. An example usage:
 // func init() {
@@ -36,6 +38,55 @@ func SetNumLoops(numLoops int) error {
 	return setNumLoops(numLoops)
 }
 
+// SetAutoScale scales the number of pollers in [min, max] automatically,
+// a poller is added if the pollers are busy handling events most of the time,
+// and removed if the rest pollers are still not busy without it.
+// The auto-scaling is disabled by default or if max <= 0.
+func SetAutoScale(min, max int) error {
+	return setAutoScale(min, max)
+}
+
+// Rebalance moves the connections handling the most events from the busiest poller to the idlest one,
+// which can be used when the pollers are unbalanced by some long-lived hot connections.
+// It's safe to call Rebalance at any time, but the connections are not moved under IOUringPoll.
+func Rebalance() error {
+	return rebalance()
+}
+
+// SetPollType sets the implementation of pollers, and EpollPoll is used by default.
+// If the PollType is not supported by the system, it falls back to EpollPoll.
+//
+// Like SetNumLoops, you can only use SetPollType before any connection is created.
+func SetPollType(t PollType) error {
+	return setPollType(t)
+}
+
+// SetPollerLockOSThread runs each poller on a locked OS thread by runtime.LockOSThread,
+// which avoids the poller being migrated between threads by the Go scheduler.
+//
+// Like SetNumLoops, you can only use SetPollerLockOSThread before any connection is created.
+func SetPollerLockOSThread(lock bool) error {
+	return setPollerLockOSThread(lock)
+}
+
+// SetPollerAffinity runs each poller on a locked OS thread, and binds the threads of the pollers
+// to the set of cpus by sched_setaffinity, which is only supported on linux.
+// The affinity is removed by calling SetPollerAffinity with no cpus.
+//
+// Like SetNumLoops, you can only use SetPollerAffinity before any connection is created.
+func SetPollerAffinity(cpus ...int) error {
+	return setPollerAffinity(cpus)
+}
+
+// SetBusyPoll makes pollers keep polling without blocking for the timeout after being idle,
+// which reduces the latency of wakeup at the cost of cpu. The busy poll is disabled by default or if timeout is 0.
+// It works better with SetPollerLockOSThread or SetPollerAffinity, since the poller occupies a thread while spinning.
+//
+// Like SetNumLoops, you can only use SetBusyPoll before any connection is created.
+func SetBusyPoll(timeout time.Duration) error {
+	return setBusyPoll(timeout)
+}
+
 // LoadBalance sets the load balancing method. Load balancing is always a best effort to attempt
 // to distribute the incoming connections between multiple polls.
 // This option only works when NumLoops is set.
@@ -43,12 +94,19 @@ func SetLoadBalance(lb LoadBalance) error {
 	return setLoadBalance(lb)
 }
 
+// SetLoadBalancer sets the custom LoadBalancer, which replaces the LoadBalance set by SetLoadBalance.
+// This option only works when NumLoops is set.
+func SetLoadBalancer(lb LoadBalancer) error {
+	return setLoadBalancer(lb)
+}
+
 func SetLoggerOutput(w io.Writer) {
 	setLoggerOutput(w)
 }
 
 // DisableGopool will remove gopool(the goroutine pool used to run OnRequest),
 // which means that OnRequest will be run via `go OnRequest(...)`.
+// It changes the default Executor to GoExecutor, and WithExecutor can be used per EventLoop instead.
 // Usually, OnRequest will cause stack expansion, which can be solved by reusing goroutine.
 // But if you can confirm that the OnRequest will not cause stack expansion,
 // it is recommended to use DisableGopool to reduce redundancy and improve performance.
@@ -56,6 +114,105 @@ func DisableGopool() error {
 	return disableGopool()
 }
 
+// WithExecutor registers the Executor to run OnConnect and OnRequest of connections.
+func WithExecutor(executor Executor) Option {
+	return Option{func(op *options) {
+		op.executor = executor
+	}}
+}
+
+// WithInputWaterMarks limits the memory of the input buffer of connections.
+// The poller stops reading from the connection when the unread data in the input buffer exceeds high,
+// and restarts when it has been drained to low, or the reader is waiting for more data.
+// It is disabled if high <= 0, and low is set to high/2 if it is not in [0, high).
+func WithInputWaterMarks(high, low int) Option {
+	return Option{func(op *options) {
+		if high <= 0 {
+			op.highWater, op.lowWater = 0, 0
+			return
+		}
+		if low < 0 || low >= high {
+			low = high / 2
+		}
+		op.highWater, op.lowWater = high, low
+	}}
+}
+
+// WithOutputLimit limits the pending output of connections, which is the data written but not sent yet.
+// If a write would make it exceed max, the write fails with ErrOutputFull if failFast is true,
+// otherwise the write is blocked until the flushed output is sent down to low or the write timeout expires.
+// The output written but not flushed is never flushed by the write, so it's still allowed if the flushed output is drained.
+// A write is always allowed if there is no pending output, so a single write can be larger than max.
+// After a write exceeded max, OnWritable is called once the pending output is drained to low.
+// It is disabled if max <= 0, and low is set to max/2 if it is not in [0, max).
+// failFast also makes the write fail with ErrBudgetExhausted instead of waiting if the buffer budget is exhausted,
+// even if the limit is disabled.
+func WithOutputLimit(max, low int, failFast bool) Option {
+	return Option{func(op *options) {
+		if max <= 0 {
+			op.outputBound.max, op.outputBound.low, op.outputBound.failFast = 0, 0, failFast
+			return
+		}
+		if low < 0 || low >= max {
+			low = max / 2
+		}
+		op.outputBound.max, op.outputBound.low, op.outputBound.failFast = max, low, failFast
+	}}
+}
+
+// WithOnWritable registers the OnWritable method to EventLoop, which works with WithOutputLimit.
+func WithOnWritable(onWritable OnWritable) Option {
+	return Option{func(op *options) {
+		op.outputBound.onWritable = onWritable
+	}}
+}
+
+// WithHalfClose makes connections of EventLoop or Dialer stay writable after the peer shuts down its writing,
+// instead of being closed as a hang-up. Then the reader gets ErrEOF after the rest data,
+// and OnRequest is called once more to see it even if there is no data.
+// Note that a closed peer can't be told apart from a half-closed one until writing,
+// so the connection must be closed by the user.
+func WithHalfClose(enable bool) Option {
+	return Option{func(op *options) {
+		op.halfClose = enable
+	}}
+}
+
+// WithLinger sets SO_LINGER of connections, so that closing waits at most d (rounded up to seconds)
+// for the unsent data in the kernel to be sent. Zero d makes closing discard the unsent data and
+// reset the connection like Abort, and negative d keeps the default behavior of sending in the background.
+func WithLinger(d time.Duration) Option {
+	return Option{func(op *options) {
+		op.lingerSet = d >= 0
+		op.linger = int((d + time.Second - 1) / time.Second)
+	}}
+}
+
+// WithFlushOnClose makes Close of connections wait at most timeout for the pending output to be sent,
+// instead of discarding it. The output unsent after the timeout is reported by Observer.OnOutputLost.
+// Note that it's only about the output buffer of netpoll, and WithLinger works for the kernel.
+func WithFlushOnClose(timeout time.Duration) Option {
+	return Option{func(op *options) {
+		op.closeTimeout = timeout
+	}}
+}
+
+// WithAllocator sets the Allocator of the buffers of connections instead of the global one,
+// and the memory is still counted to ReadMemStats and the budget set by SetBufferBudget.
+func WithAllocator(allocator Allocator) Option {
+	return Option{func(op *options) {
+		op.allocator = allocator
+	}}
+}
+
+// WithSizePolicy sets the SizePolicy of reading of connections, see NewSizePolicy.
+// By default, the size starts from 512 bytes and grows up to 8MB.
+func WithSizePolicy(policy SizePolicy) Option {
+	return Option{func(op *options) {
+		op.sizePolicy = policy
+	}}
+}
+
 // WithOnPrepare registers the OnPrepare method to EventLoop.
 func WithOnPrepare(onPrepare OnPrepare) Option {
 	return Option{func(op *options) {
@@ -91,6 +248,36 @@ func WithIdleTimeout(timeout time.Duration) Option {
 	}}
 }
 
+// WithZeroCopy enables MSG_ZEROCOPY sending of connections, which is only supported on linux 4.14+.
+// Only the flushes with at least threshold bytes are sent by zerocopy, and threshold <= 0 means the default 16KB.
+// The flushed buffer is held until the kernel notifies the completion, so the memory usage would be higher.
+// Note that the slices submitted by WriteBinary or WriteDirect must not be changed until then.
+func WithZeroCopy(threshold int) Option {
+	return Option{func(op *options) {
+		if threshold <= 0 {
+			threshold = defaultZeroCopyThreshold
+		}
+		op.zeroCopyThreshold = threshold
+	}}
+}
+
+// WithPollManager registers the connections of EventLoop or Dialer to the pollers of PollManager,
+// instead of the default pollers.
+func WithPollManager(pm *PollManager) Option {
+	return Option{func(op *options) {
+		if pm != nil {
+			op.manager = pm.m
+		}
+	}}
+}
+
+// WithObserver registers the Observer to EventLoop, which is notified of the connection lifecycle events.
+func WithObserver(observer Observer) Option {
+	return Option{func(op *options) {
+		op.observer = observer
+	}}
+}
+
 // Option .
 type Option struct {
 	f func(*options)
@@ -103,4 +290,26 @@ type options struct {
 	readTimeout  time.Duration
 	writeTimeout time.Duration
 	idleTimeout  time.Duration
+	observer     Observer
+	executor     Executor
+	highWater    int
+	lowWater     int
+	outputBound  outputBound
+	halfClose    bool
+	linger       int // seconds of SO_LINGER, which is set only if lingerSet
+	lingerSet    bool
+	closeTimeout time.Duration
+	allocator    Allocator
+	sizePolicy   SizePolicy
+
+	zeroCopyThreshold int
+	manager           *manager
+}
+
+// pollManager returns the pollers used by the EventLoop or Dialer.
+func (o *options) pollManager() *manager {
+	if o.manager != nil {
+		return o.manager
+	}
+	return pollmanager
 }
@@ -20,6 +20,7 @@ package netpoll
 import (
 	"context"
 	"errors"
+	"net"
 	"strings"
 	"sync"
 	"time"
@@ -49,7 +50,11 @@ func (s *server) Run() (err error) {
 		OnRead: s.OnRead,
 		OnHup:  s.OnHup,
 	}
-	s.operator.poll = pollmanager.Pick()
+	// the conns can be accepted by the poll only if the listener is created by netpoll.
+	if ln, ok := s.ln.(*listener); ok && ln.pconn == nil {
+		s.operator.OnAccept = s.OnAccept
+	}
+	s.operator.poll = s.opts.pollManager().Pick()
 	err = s.operator.Control(PollReadable)
 	if err != nil {
 		s.onQuit(err)
@@ -60,6 +65,9 @@ func (s *server) Run() (err error) {
 // Close this server with deadline.
 func (s *server) Close(ctx context.Context) error {
 	s.operator.Control(PollDetach)
+	if w, ok := s.operator.poll.(detachWaiter); ok {
+		w.waitDetached(&s.operator)
+	}
 	s.ln.Close()
 
 	var ticker = time.NewTicker(time.Second)
@@ -105,7 +113,24 @@ func (s *server) OnRead(p Poll) error {
 	if conn == nil {
 		return nil
 	}
-	// store & register connection
+	return s.onAccept(conn)
+}
+
+// OnAccept implements FDOperator.
+func (s *server) OnAccept(fd int, p Poll) error {
+	conn, err := s.ln.(*listener).newConn(fd)
+	if err != nil {
+		logger.Println("NETPOLL: accept conn failed:", err.Error())
+		return err
+	}
+	return s.onAccept(conn)
+}
+
+// onAccept stores & registers the accepted conn.
+func (s *server) onAccept(conn net.Conn) error {
+	if s.opts.observer != nil {
+		s.opts.observer.OnAccept(conn)
+	}
 	var connection = &connection{}
 	connection.init(conn.(Conn), s.opts)
 	if !connection.IsActive() {
@@ -20,7 +20,10 @@ package netpoll
 import (
 	"context"
 	"errors"
+	"io"
 	"math/rand"
+	"net"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -279,6 +282,113 @@ func TestCloseAndWrite(t *testing.T) {
 	MustNil(t, err)
 }
 
+type testObserver struct {
+	NoopObserver
+	accept, prepare, connect, requestStart, requestEnd int32
+	flushStart, flushEnd, flushBytes, hup, close       int32
+	closed                                             chan struct{}
+}
+
+func (o *testObserver) OnAccept(conn net.Conn)               { atomic.AddInt32(&o.accept, 1) }
+func (o *testObserver) OnPrepare(connection Connection)      { atomic.AddInt32(&o.prepare, 1) }
+func (o *testObserver) OnConnect(connection Connection)      { atomic.AddInt32(&o.connect, 1) }
+func (o *testObserver) OnRequestStart(connection Connection) { atomic.AddInt32(&o.requestStart, 1) }
+func (o *testObserver) OnRequestEnd(connection Connection, err error) {
+	atomic.AddInt32(&o.requestEnd, 1)
+}
+func (o *testObserver) OnFlushStart(connection Connection, size int) {
+	atomic.AddInt32(&o.flushStart, 1)
+}
+func (o *testObserver) OnFlushEnd(connection Connection, n int, err error) {
+	atomic.AddInt32(&o.flushEnd, 1)
+	atomic.AddInt32(&o.flushBytes, int32(n))
+}
+func (o *testObserver) OnHup(connection Connection)   { atomic.AddInt32(&o.hup, 1) }
+func (o *testObserver) OnClose(connection Connection) { atomic.AddInt32(&o.close, 1) }
+func (o *testObserver) OnCloseCallback(connection Connection) {
+	close(o.closed)
+}
+
+func TestObserver(t *testing.T) {
+	var network, address = "tcp", ":8888"
+	var observer = &testObserver{closed: make(chan struct{})}
+	var loop = newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			input, err := connection.Reader().Next(4)
+			MustNil(t, err)
+			_, err = connection.Writer().WriteBinary(input)
+			MustNil(t, err)
+			return connection.Writer().Flush()
+		},
+		WithOnConnect(func(ctx context.Context, connection Connection) context.Context {
+			return ctx
+		}),
+		WithObserver(observer),
+	)
+	var conn, err = DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	_, err = conn.Writer().WriteString("ping")
+	MustNil(t, err)
+	err = conn.Writer().Flush()
+	MustNil(t, err)
+	_, err = conn.Reader().Next(4)
+	MustNil(t, err)
+	err = conn.Close()
+	MustNil(t, err)
+	<-observer.closed
+
+	Equal(t, atomic.LoadInt32(&observer.accept), int32(1))
+	Equal(t, atomic.LoadInt32(&observer.prepare), int32(1))
+	Equal(t, atomic.LoadInt32(&observer.connect), int32(1))
+	Equal(t, atomic.LoadInt32(&observer.requestStart), int32(1))
+	Equal(t, atomic.LoadInt32(&observer.requestEnd), int32(1))
+	Equal(t, atomic.LoadInt32(&observer.flushStart), int32(1))
+	Equal(t, atomic.LoadInt32(&observer.flushEnd), int32(1))
+	Equal(t, atomic.LoadInt32(&observer.flushBytes), int32(4))
+	Equal(t, atomic.LoadInt32(&observer.hup), int32(1))
+	Equal(t, atomic.LoadInt32(&observer.close), int32(0))
+
+	err = loop.Shutdown(context.Background())
+	MustNil(t, err)
+}
+
+func TestObserverFlushAsync(t *testing.T) {
+	var network, address = "tcp", ":8889"
+	var observer = &testObserver{closed: make(chan struct{})}
+	var size = 8 * 1024 * 1024
+	var sent = make(chan error, 1)
+	var loop = newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			_, err := connection.Reader().Next(4)
+			MustNil(t, err)
+			_, err = connection.Writer().Malloc(size)
+			MustNil(t, err)
+			// the output much larger than the socket buffer is sent by the poller later.
+			return connection.(AsyncFlusher).FlushAsync(func(err error) {
+				sent <- err
+			})
+		},
+		WithObserver(observer),
+	)
+	// the peer doesn't read until the socket buffer is full.
+	conn, err := net.Dial(network, address)
+	MustNil(t, err)
+	_, err = conn.Write([]byte("ping"))
+	MustNil(t, err)
+	time.Sleep(50 * time.Millisecond)
+	Equal(t, atomic.LoadInt32(&observer.flushStart), int32(1))
+	Equal(t, atomic.LoadInt32(&observer.flushEnd), int32(0))
+	_, err = io.ReadFull(conn, make([]byte, size))
+	MustNil(t, err)
+	MustNil(t, <-sent)
+	Equal(t, atomic.LoadInt32(&observer.flushEnd), int32(1))
+	Equal(t, atomic.LoadInt32(&observer.flushBytes), int32(size))
+	MustNil(t, conn.Close())
+
+	err = loop.Shutdown(context.Background())
+	MustNil(t, err)
+}
+
 func newTestEventLoop(network, address string, onRequest OnRequest, opts ...Option) EventLoop {
 	var listener, _ = CreateListener(network, address)
 	var eventLoop, _ = NewEventLoop(onRequest, opts...)
@@ -19,6 +19,7 @@
 package netpoll
 
 import (
+	"context"
 	"net"
 	"time"
 )
@@ -50,8 +51,81 @@ func WithIdleTimeout(timeout time.Duration) Option {
 	return Option{}
 }
 
+// WithZeroCopy enables MSG_ZEROCOPY sending of connections.
+func WithZeroCopy(threshold int) Option {
+	return Option{}
+}
+
+// WithObserver registers the Observer to EventLoop.
+func WithObserver(observer Observer) Option {
+	return Option{}
+}
+
+// Executor runs the tasks of connections.
+type Executor interface {
+	Execute(ctx context.Context, task func()) error
+}
+
+// WithExecutor registers the Executor to run OnConnect and OnRequest of connections.
+func WithExecutor(executor Executor) Option {
+	return Option{}
+}
+
+// WithInputWaterMarks limits the memory of the input buffer of connections.
+func WithInputWaterMarks(high, low int) Option {
+	return Option{}
+}
+
+// WithOutputLimit limits the pending output of connections.
+func WithOutputLimit(max, low int, failFast bool) Option {
+	return Option{}
+}
+
+// WithOnWritable registers the OnWritable method to EventLoop.
+func WithOnWritable(onWritable OnWritable) Option {
+	return Option{}
+}
+
+// WithHalfClose makes connections stay writable after the peer shuts down its writing.
+func WithHalfClose(enable bool) Option {
+	return Option{}
+}
+
+// WithLinger sets SO_LINGER of connections.
+func WithLinger(d time.Duration) Option {
+	return Option{}
+}
+
+// WithFlushOnClose makes Close of connections wait for the pending output to be sent.
+func WithFlushOnClose(timeout time.Duration) Option {
+	return Option{}
+}
+
+// WithAllocator sets the Allocator of the buffers of connections instead of the global one.
+func WithAllocator(allocator Allocator) Option {
+	return Option{}
+}
+
+// WithSizePolicy sets the SizePolicy of reading of connections.
+func WithSizePolicy(policy SizePolicy) Option {
+	return Option{}
+}
+
+// PollManager manages a group of pollers with its own settings.
+type PollManager struct{}
+
+// NewPollManager creates a PollManager with numLoops pollers.
+func NewPollManager(numLoops int) (*PollManager, error) {
+	return nil, Exception(ErrUnsupported, "PollManager")
+}
+
+// WithPollManager registers the connections of EventLoop or Dialer to the pollers of PollManager.
+func WithPollManager(pm *PollManager) Option {
+	return Option{}
+}
+
 // NewDialer only support TCP and unix socket now.
-func NewDialer() Dialer {
+func NewDialer(ops ...Option) Dialer {
 	return nil
 }
 
@@ -97,6 +97,7 @@ type Reader interface {
 	// recycle the memory after confirming that the previously read data is no longer in use.
 	// After invoking Release, the slices obtained by the method such as Next, Peek, Skip will
 	// become an invalid address and cannot be used anymore.
+	// Build with the tag netpoll_safe to make the misuse fault at once, see nocopy_safe.go.
 	Release() (err error)
 
 	// Len returns the total length of the readable data in the reader.
@@ -192,6 +193,96 @@ type Writer interface {
 	MallocLen() (length int)
 }
 
+// FileWriter is an optional interface of Writer, which sends the data of a file descriptor
+// without copying it into user space. The Writer of Connection implements FileWriter on linux.
+type FileWriter interface {
+	// WriteFile queues length bytes of fd into the output stream, which will be sent in order
+	// with the data written before and after it, after submission(e.g. Flush).
+	//
+	// If fd is a regular file, the data is sent from offset by sendfile, and the file offset is not changed.
+	// If fd is a pipe or a socket, offset is ignored and the data is moved by splice, which is useful for proxies.
+	// The pipe or socket is set non-blocking, and it's spliced by the goroutine of Flush instead of the poller.
+	// A socket fd must not be the one managed by netpoll, since the poller is also reading it.
+	//
+	// The fd is not closed by the Writer, and it must stay open until the data has been sent.
+	// The length is counted by the output limit and the buffer budget like the other writes.
+	WriteFile(fd int, offset int64, length int) (err error)
+}
+
+// VectorReader is an optional interface of Reader, which reads the bytes without copying them into a slice.
+// The Reader of Connection and LinkBuffer implement VectorReader.
+type VectorReader interface {
+	// NextVectors is the same as Next, but returns the bytes as slices pointing into the buffer nodes,
+	// which is never copied even if the bytes span the nodes. The slices are only valid until Release.
+	// It suits the consumers working with non-contiguous memory, e.g. hashing or writev.
+	NextVectors(n int) (vs [][]byte, err error)
+
+	// PeekVectors returns the next n bytes as NextVectors without advancing the reader.
+	PeekVectors(n int) (vs [][]byte, err error)
+}
+
+// DelimiterReader is an optional interface of Reader, which finds the multi-byte delimiters
+// even if they span the buffer nodes. The Reader of Connection and LinkBuffer implement DelimiterReader.
+type DelimiterReader interface {
+	// UntilBytes is the same as Until, but reads until the first occurrence of the multi-byte delim.
+	// If maxLen > 0, and the line including delim would be longer than it,
+	// UntilBytes returns ErrTooLong without advancing the reader. 0 means unlimited.
+	UntilBytes(delim []byte, maxLen int) (line []byte, err error)
+
+	// Index returns the index of the first instance of sep in the readable data at or after from,
+	// or -1 if sep is not present. It doesn't wait for more data, and from can resume the last search.
+	Index(sep []byte, from int) (i int)
+}
+
+// BinaryReader is an optional interface of Reader, which reads the integers encoded in binary.
+// The Reader of Connection and LinkBuffer implement BinaryReader.
+type BinaryReader interface {
+	// ReadUint16, ReadUint32 and ReadUint64 read a big-endian integer, and the LE versions read a little-endian one.
+	// They are faster implementations of Next, which don't allocate even if the bytes span the buffer nodes.
+	// For example, ReadUint32 replaces:
+	//
+	//  var p, err = Next(4)
+	//  return binary.BigEndian.Uint32(p), err
+	//
+	ReadUint16() (v uint16, err error)
+	ReadUint32() (v uint32, err error)
+	ReadUint64() (v uint64, err error)
+	ReadUint16LE() (v uint16, err error)
+	ReadUint32LE() (v uint32, err error)
+	ReadUint64LE() (v uint64, err error)
+
+	// ReadUvarint reads an unsigned integer encoded by binary.PutUvarint, and returns ErrVarintOverflow
+	// if it's longer than binary.MaxVarintLen64. The reader is not advanced if an error is returned.
+	ReadUvarint() (v uint64, err error)
+
+	// ReadVarint reads a signed integer encoded by binary.PutVarint.
+	// The reader is not advanced if an error is returned.
+	ReadVarint() (v int64, err error)
+}
+
+// BinaryWriter is an optional interface of Writer, which writes the integers encoded in binary.
+// The Writer of Connection and LinkBuffer implement BinaryWriter.
+type BinaryWriter interface {
+	// WriteUint16, WriteUint32 and WriteUint64 write a big-endian integer, and the LE versions write a little-endian one.
+	// For example, WriteUint32 replaces:
+	//
+	//  var buf, _ = Malloc(4)
+	//  binary.BigEndian.PutUint32(buf, v)
+	//
+	WriteUint16(v uint16) (err error)
+	WriteUint32(v uint32) (err error)
+	WriteUint64(v uint64) (err error)
+	WriteUint16LE(v uint16) (err error)
+	WriteUint32LE(v uint32) (err error)
+	WriteUint64LE(v uint64) (err error)
+
+	// WriteUvarint writes an unsigned integer encoded as binary.PutUvarint.
+	WriteUvarint(v uint64) (err error)
+
+	// WriteVarint writes a signed integer encoded as binary.PutVarint.
+	WriteVarint(v int64) (err error)
+}
+
 // ReadWriter is a combination of Reader and Writer.
 type ReadWriter interface {
 	Reader
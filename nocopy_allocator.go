@@ -0,0 +1,280 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Allocator allocates the memory of LinkBuffer, which can be set globally by SetAllocator,
+// or per connection by WithAllocator. The default Allocator recycles the memory by mcache.
+type Allocator interface {
+	// Malloc returns a buffer of length size, whose capacity is at least capacity.
+	Malloc(size, capacity int) []byte
+	// Free recycles the buffer returned by Malloc, which won't be used any more.
+	Free(buf []byte)
+}
+
+// MemStats records the memory allocated by LinkBuffers, in bytes of capacity.
+type MemStats struct {
+	Allocated int64 // cumulative bytes allocated
+	InUse     int64 // bytes allocated and not freed yet
+}
+
+// SetAllocator sets the global Allocator, which is used by the LinkBuffers created later without WithAllocator.
+// The buffers created before are still freed by the Allocator they were allocated by.
+// A nil Allocator restores the default one.
+func SetAllocator(allocator Allocator) {
+	if allocator == nil {
+		allocator = defaultAllocator
+	}
+	globalAccount.Store(newMemAccount(allocator))
+}
+
+// ReadMemStats returns the memory allocated by all the LinkBuffers.
+func ReadMemStats() (stats MemStats) {
+	for i := range memTotal {
+		stats.Allocated += atomic.LoadInt64(&memTotal[i].Allocated)
+		stats.InUse += atomic.LoadInt64(&memTotal[i].InUse)
+	}
+	return stats
+}
+
+// SetBufferBudget limits the memory in use by all the LinkBuffers of the process, 0 means unlimited.
+// Once the budget is exhausted, connections stop reading until the memory is freed, unless the reader
+// is waiting for more data, and writing to connections is blocked until the memory is freed or the write
+// timeout expires, or fails with ErrBudgetExhausted if failFast of WithOutputLimit is set.
+// The budget is a soft limit, which may be exceeded by the buffers being allocated.
+// The memory of all the buffers is counted, including the ones created before the budget is set.
+func SetBufferBudget(limit int64) {
+	if limit < 0 {
+		limit = 0
+	}
+	atomic.StoreInt64(&budget.limit, limit)
+	budget.wake()
+}
+
+// memShards is the number of shards of the total, which spreads the updates of the connections
+// over the cache lines.
+const memShards = 32
+
+// memBatch is the memory in use batched by a shard before it's added to memApprox,
+// so the error of memApprox is less than memShards*memBatch.
+const memBatch = 16 * 1024
+
+type memShard struct {
+	MemStats
+	pending int64    // the memory in use not added to memApprox yet
+	_       [40]byte // pad to a cache line
+}
+
+var memTotal [memShards]memShard
+
+// memApprox is the approximate memory in use of the total, which is read by the budget at once
+// instead of summing the shards, unless it's close to the limit.
+var memApprox int64
+
+// memShardNext assigns the shards to the accounts in turn.
+var memShardNext uint32
+
+// add counts the memory to the shard, and batches the memory in use to memApprox.
+func (s *memShard) add(allocated, inuse int64) {
+	if allocated > 0 {
+		atomic.AddInt64(&s.Allocated, allocated)
+	}
+	atomic.AddInt64(&s.InUse, inuse)
+	if p := atomic.AddInt64(&s.pending, inuse); p >= memBatch || p <= -memBatch {
+		if atomic.CompareAndSwapInt64(&s.pending, p, 0) {
+			atomic.AddInt64(&memApprox, p)
+		}
+	}
+}
+
+// memInUse returns the memory in use of the total.
+func memInUse() (inuse int64) {
+	for i := range memTotal {
+		inuse += atomic.LoadInt64(&memTotal[i].InUse)
+	}
+	return inuse
+}
+
+// defaultAllocator is replaced by the safe mode built with the tag netpoll_safe.
+var defaultAllocator Allocator = mcacheAllocator{}
+
+// safeMode is set if built with the tag netpoll_safe.
+var safeMode = false
+
+// safeTake records the caller taking a slice of buf, and safeCatch reports the fault of accessing
+// the slices released, which are only used if safeMode is set.
+var (
+	safeTake  = func(buf []byte) {}
+	safeCatch = func(panicOnFault bool) {}
+)
+
+var globalAccount atomic.Value // *memAccount
+
+func init() {
+	SetAllocator(nil)
+}
+
+// defaultAccount returns the account of the global Allocator.
+func defaultAccount() *memAccount {
+	return globalAccount.Load().(*memAccount)
+}
+
+// newMemAccount creates an account for the buffers of a connection, with the global Allocator if allocator is nil.
+func newMemAccount(allocator Allocator) *memAccount {
+	if allocator == nil {
+		allocator = defaultAccount().allocator
+	}
+	return &memAccount{
+		allocator: allocator,
+		total:     &memTotal[atomic.AddUint32(&memShardNext, 1)%memShards],
+	}
+}
+
+// memAccount allocates the memory by its Allocator, and counts the memory to itself and the total.
+type memAccount struct {
+	allocator Allocator
+	stats     MemStats
+	total     *memShard // the shard of the total counted to
+	detached  int32     // the memory is no longer counted to the total
+}
+
+func (a *memAccount) malloc(size, capacity int) []byte {
+	if a == nil {
+		a = defaultAccount()
+	}
+	var buf = a.allocator.Malloc(size, capacity)
+	a.count(int64(cap(buf)))
+	return buf
+}
+
+func (a *memAccount) free(buf []byte) {
+	if a == nil {
+		a = defaultAccount()
+	}
+	var n = int64(cap(buf))
+	a.allocator.Free(buf)
+	a.count(-n)
+	if n > 0 {
+		budget.wake()
+	}
+}
+
+// count adds the delta to itself first, and then to the total unless detached,
+// so that detach won't miss or double count the concurrent delta.
+func (a *memAccount) count(delta int64) {
+	if delta > 0 {
+		atomic.AddInt64(&a.stats.Allocated, delta)
+	}
+	atomic.AddInt64(&a.stats.InUse, delta)
+	if atomic.LoadInt32(&a.detached) != 0 {
+		return
+	}
+	a.total.add(delta, delta)
+}
+
+// detach stops counting the memory in use to the total, which is used when the buffers are given up to GC.
+func (a *memAccount) detach() {
+	if !atomic.CompareAndSwapInt32(&a.detached, 0, 1) {
+		return
+	}
+	a.total.add(0, -atomic.LoadInt64(&a.stats.InUse))
+	budget.wake()
+}
+
+func (a *memAccount) memStats() MemStats {
+	return MemStats{
+		Allocated: atomic.LoadInt64(&a.stats.Allocated),
+		InUse:     atomic.LoadInt64(&a.stats.InUse),
+	}
+}
+
+// mcacheAllocator is the default Allocator.
+type mcacheAllocator struct{}
+
+func (mcacheAllocator) Malloc(size, capacity int) []byte {
+	return malloc(size, capacity)
+}
+
+func (mcacheAllocator) Free(buf []byte) {
+	free(buf)
+}
+
+// ------------------------------------------ implement buffer budget ------------------------------------------
+
+var budget bufferBudget
+
+// bufferBudget calls the waiters back once the memory in use drops below the limit.
+type bufferBudget struct {
+	limit   int64
+	waiting int32
+	mu      sync.Mutex
+	waiters []func()
+}
+
+// exhausted checks whether the memory in use has reached the limit,
+// which only sums the shards if memApprox is too close to the limit to tell.
+func (b *bufferBudget) exhausted() bool {
+	var limit = atomic.LoadInt64(&b.limit)
+	if limit == 0 {
+		return false
+	}
+	var approx, slack = atomic.LoadInt64(&memApprox), int64(memShards * memBatch)
+	if approx < limit-slack {
+		return false
+	}
+	if approx >= limit+slack {
+		return true
+	}
+	return memInUse() >= limit
+}
+
+// wait returns true if the budget is available, otherwise fn is called back once it's available again.
+// Note that fn may still be called if true is returned.
+func (b *bufferBudget) wait(fn func()) bool {
+	if !b.exhausted() {
+		return true
+	}
+	b.mu.Lock()
+	b.waiters = append(b.waiters, fn)
+	atomic.StoreInt32(&b.waiting, 1)
+	b.mu.Unlock()
+	// the memory may be freed before waiting.
+	return !b.exhausted()
+}
+
+// wake calls all the waiters back in a new goroutine if the budget is available,
+// since it may be called by the poller or with the locks held.
+func (b *bufferBudget) wake() {
+	if atomic.LoadInt32(&b.waiting) == 0 || b.exhausted() {
+		return
+	}
+	b.mu.Lock()
+	var waiters = b.waiters
+	b.waiters = nil
+	atomic.StoreInt32(&b.waiting, 0)
+	b.mu.Unlock()
+	if len(waiters) == 0 {
+		return
+	}
+	go func() {
+		for _, fn := range waiters {
+			fn()
+		}
+	}()
+}
@@ -0,0 +1,97 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+type countAllocator struct {
+	malloc, free int64
+}
+
+func (a *countAllocator) Malloc(size, capacity int) []byte {
+	atomic.AddInt64(&a.malloc, int64(capacity))
+	return make([]byte, size, capacity)
+}
+
+func (a *countAllocator) Free(buf []byte) {
+	atomic.AddInt64(&a.free, int64(cap(buf)))
+}
+
+func TestLinkBufferAllocator(t *testing.T) {
+	var allocator = &countAllocator{}
+	var total = ReadMemStats()
+	var account = newMemAccount(allocator)
+
+	buf := newLinkBuffer(account, 0)
+	_, err := buf.Malloc(block8k)
+	MustNil(t, err)
+	MustNil(t, buf.Flush())
+	var stats = account.memStats()
+	Equal(t, stats.InUse, allocator.malloc)
+	Equal(t, stats.Allocated, allocator.malloc)
+	MustTrue(t, stats.InUse >= block8k)
+	MustTrue(t, ReadMemStats().Allocated-total.Allocated >= stats.Allocated)
+
+	// the buffer sliced is still in use until released.
+	r, err := buf.Slice(block8k)
+	MustNil(t, err)
+	MustNil(t, buf.Close())
+	Equal(t, account.memStats().InUse, stats.InUse)
+	_, err = r.Next(block8k)
+	MustNil(t, err)
+	MustNil(t, r.Release())
+	Equal(t, account.memStats().InUse, int64(0))
+	Equal(t, account.memStats().Allocated, stats.Allocated)
+	Equal(t, allocator.free, allocator.malloc)
+
+	// the buffers created later use the global allocator, but the former are freed by their own.
+	var global = &countAllocator{}
+	SetAllocator(global)
+	defer SetAllocator(nil)
+	b1 := NewLinkBuffer(block1k)
+	MustTrue(t, global.malloc >= block1k)
+	SetAllocator(nil)
+	MustNil(t, b1.Close())
+	Equal(t, global.free, global.malloc)
+
+	// detached memory is no longer counted to the total.
+	buf = newLinkBuffer(account, block1k)
+	var inuse = ReadMemStats().InUse
+	account.detach()
+	Equal(t, ReadMemStats().InUse, inuse-account.memStats().InUse)
+	MustNil(t, buf.Close())
+	Equal(t, account.memStats().InUse, int64(0))
+}
+
+func TestBufferBudget(t *testing.T) {
+	var inuse = ReadMemStats().InUse
+	SetBufferBudget(inuse + block8k)
+	defer SetBufferBudget(0)
+	MustTrue(t, !budget.exhausted())
+
+	var woken = make(chan struct{}, 1)
+	buf := NewLinkBuffer(block8k * 2)
+	MustTrue(t, budget.exhausted())
+	MustTrue(t, !budget.wait(func() { woken <- struct{}{} }))
+	MustNil(t, buf.Close())
+	<-woken
+	MustTrue(t, !budget.exhausted())
+}
@@ -0,0 +1,224 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var _ VectorReader = &LinkBuffer{}
+var _ DelimiterReader = &LinkBuffer{}
+var _ BinaryReader = &LinkBuffer{}
+var _ BinaryWriter = &LinkBuffer{}
+
+// ------------------------------------------ implement binary reader ------------------------------------------
+
+// ReadUint16 implements BinaryReader.
+func (b *LinkBuffer) ReadUint16() (v uint16, err error) {
+	var p [2]byte
+	if err = b.readFull(p[:]); err != nil {
+		return v, err
+	}
+	return binary.BigEndian.Uint16(p[:]), nil
+}
+
+// ReadUint32 implements BinaryReader.
+func (b *LinkBuffer) ReadUint32() (v uint32, err error) {
+	var p [4]byte
+	if err = b.readFull(p[:]); err != nil {
+		return v, err
+	}
+	return binary.BigEndian.Uint32(p[:]), nil
+}
+
+// ReadUint64 implements BinaryReader.
+func (b *LinkBuffer) ReadUint64() (v uint64, err error) {
+	var p [8]byte
+	if err = b.readFull(p[:]); err != nil {
+		return v, err
+	}
+	return binary.BigEndian.Uint64(p[:]), nil
+}
+
+// ReadUint16LE implements BinaryReader.
+func (b *LinkBuffer) ReadUint16LE() (v uint16, err error) {
+	var p [2]byte
+	if err = b.readFull(p[:]); err != nil {
+		return v, err
+	}
+	return binary.LittleEndian.Uint16(p[:]), nil
+}
+
+// ReadUint32LE implements BinaryReader.
+func (b *LinkBuffer) ReadUint32LE() (v uint32, err error) {
+	var p [4]byte
+	if err = b.readFull(p[:]); err != nil {
+		return v, err
+	}
+	return binary.LittleEndian.Uint32(p[:]), nil
+}
+
+// ReadUint64LE implements BinaryReader.
+func (b *LinkBuffer) ReadUint64LE() (v uint64, err error) {
+	var p [8]byte
+	if err = b.readFull(p[:]); err != nil {
+		return v, err
+	}
+	return binary.LittleEndian.Uint64(p[:]), nil
+}
+
+// ReadUvarint implements BinaryReader.
+func (b *LinkBuffer) ReadUvarint() (v uint64, err error) {
+	return readUvarint(b, func(n int) error {
+		return errors.New("link buffer read uvarint not enough")
+	})
+}
+
+// ReadVarint implements BinaryReader.
+func (b *LinkBuffer) ReadVarint() (v int64, err error) {
+	ux, err := b.ReadUvarint()
+	return unzigzag(ux), err
+}
+
+// readFull reads len(p) bytes into p, which copies the bytes across the nodes without allocating.
+func (b *LinkBuffer) readFull(p []byte) (err error) {
+	if b.peekBytes(p) < len(p) {
+		return fmt.Errorf("link buffer read[%d] not enough", len(p))
+	}
+	return b.Skip(len(p))
+}
+
+// readUvarint reads an uvarint from buf, and calls wait to wait for at least n bytes readable if it's incomplete.
+func readUvarint(buf *LinkBuffer, wait func(n int) error) (v uint64, err error) {
+	var p [binary.MaxVarintLen64]byte
+	for {
+		var l = buf.peekBytes(p[:])
+		var n int
+		if v, n = binary.Uvarint(p[:l]); n > 0 {
+			return v, buf.Skip(n)
+		}
+		if n < 0 || l == len(p) {
+			return 0, Exception(ErrVarintOverflow, "when read uvarint")
+		}
+		if err = wait(l + 1); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// unzigzag decodes the signed integer from the uvarint, as binary.Varint.
+func unzigzag(ux uint64) (x int64) {
+	x = int64(ux >> 1)
+	if ux&1 != 0 {
+		x = ^x
+	}
+	return x
+}
+
+// ------------------------------------------ implement binary writer ------------------------------------------
+
+// WriteUint16 implements BinaryWriter.
+func (b *LinkBuffer) WriteUint16(v uint16) (err error) {
+	buf, err := b.Malloc(2)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint16(buf, v)
+	return nil
+}
+
+// WriteUint32 implements BinaryWriter.
+func (b *LinkBuffer) WriteUint32(v uint32) (err error) {
+	buf, err := b.Malloc(4)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(buf, v)
+	return nil
+}
+
+// WriteUint64 implements BinaryWriter.
+func (b *LinkBuffer) WriteUint64(v uint64) (err error) {
+	buf, err := b.Malloc(8)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint64(buf, v)
+	return nil
+}
+
+// WriteUint16LE implements BinaryWriter.
+func (b *LinkBuffer) WriteUint16LE(v uint16) (err error) {
+	buf, err := b.Malloc(2)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint16(buf, v)
+	return nil
+}
+
+// WriteUint32LE implements BinaryWriter.
+func (b *LinkBuffer) WriteUint32LE(v uint32) (err error) {
+	buf, err := b.Malloc(4)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(buf, v)
+	return nil
+}
+
+// WriteUint64LE implements BinaryWriter.
+func (b *LinkBuffer) WriteUint64LE(v uint64) (err error) {
+	buf, err := b.Malloc(8)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(buf, v)
+	return nil
+}
+
+// WriteUvarint implements BinaryWriter.
+func (b *LinkBuffer) WriteUvarint(v uint64) (err error) {
+	buf, err := b.Malloc(uvarintLen(v))
+	if err != nil {
+		return err
+	}
+	binary.PutUvarint(buf, v)
+	return nil
+}
+
+// WriteVarint implements BinaryWriter.
+func (b *LinkBuffer) WriteVarint(v int64) (err error) {
+	return b.WriteUvarint(zigzag(v))
+}
+
+// uvarintLen returns the number of bytes to encode v by binary.PutUvarint.
+func uvarintLen(v uint64) (n int) {
+	for n = 1; v >= 0x80; n++ {
+		v >>= 7
+	}
+	return n
+}
+
+// zigzag encodes the signed integer as an uvarint, as binary.PutVarint.
+func zigzag(x int64) (ux uint64) {
+	ux = uint64(x) << 1
+	if x < 0 {
+		ux = ^ux
+	}
+	return ux
+}
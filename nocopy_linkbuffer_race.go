@@ -21,6 +21,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
 	"sync"
 	"sync/atomic"
@@ -38,12 +39,17 @@ var LinkBufferCap = block4k
 
 // NewLinkBuffer size defines the initial capacity, but there is no readable data.
 func NewLinkBuffer(size ...int) *LinkBuffer {
-	var buf = &LinkBuffer{}
 	var l int
 	if len(size) > 0 {
 		l = size[0]
 	}
-	var node = newLinkBufferNode(l)
+	return newLinkBuffer(defaultAccount(), l)
+}
+
+// newLinkBuffer creates a LinkBuffer whose memory is allocated by the account.
+func newLinkBuffer(account *memAccount, size int) *LinkBuffer {
+	var buf = &LinkBuffer{account: account}
+	var node = newLinkBufferNode(size, account)
 	buf.head, buf.read, buf.flush, buf.write = node, node, node, node
 	return buf
 }
@@ -59,7 +65,8 @@ type LinkBuffer struct {
 	flush *linkBufferNode // malloc head
 	write *linkBufferNode // malloc tail
 
-	caches [][]byte // buf allocated by Next when cross-package, which should be freed when release
+	caches  [][]byte    // buf allocated by Next when cross-package, which should be freed when release
+	account *memAccount // the memory is allocated by
 }
 
 var _ Reader = &LinkBuffer{}
@@ -98,8 +105,11 @@ func (b *LinkBuffer) Next(n int) (p []byte, err error) {
 	// multiple nodes
 	var pIdx int
 	if block1k < n && n <= mallocMax {
-		p = malloc(n, n)
+		p = b.account.malloc(n, n)
 		b.caches = append(b.caches, p)
+		if safeMode {
+			safeTake(p)
+		}
 	} else {
 		p = make([]byte, n)
 	}
@@ -137,8 +147,11 @@ func (b *LinkBuffer) Peek(n int) (p []byte, err error) {
 	// multiple nodes
 	var pIdx int
 	if block1k < n && n <= mallocMax {
-		p = malloc(n, n)
+		p = b.account.malloc(n, n)
 		b.caches = append(b.caches, p)
+		if safeMode {
+			safeTake(p)
+		}
 	} else {
 		p = make([]byte, n)
 	}
@@ -158,6 +171,59 @@ func (b *LinkBuffer) Peek(n int) (p []byte, err error) {
 	return p, nil
 }
 
+// NextVectors implements VectorReader.
+func (b *LinkBuffer) NextVectors(n int) (vs [][]byte, err error) {
+	b.Lock()
+	defer b.Unlock()
+	if n <= 0 {
+		return
+	}
+	// check whether enough or not.
+	if b.Len() < n {
+		return vs, fmt.Errorf("link buffer next vectors[%d] not enough", n)
+	}
+	b.recalLen(-n) // re-cal length
+
+	var l int
+	for ack := n; ack > 0; ack = ack - l {
+		l = b.read.Len()
+		if l >= ack {
+			vs = append(vs, b.read.Next(ack))
+			break
+		} else if l > 0 {
+			vs = append(vs, b.read.Next(l))
+		}
+		b.read = b.read.next
+	}
+	return vs, nil
+}
+
+// PeekVectors implements VectorReader.
+func (b *LinkBuffer) PeekVectors(n int) (vs [][]byte, err error) {
+	b.Lock()
+	defer b.Unlock()
+	if n <= 0 {
+		return
+	}
+	// check whether enough or not.
+	if b.Len() < n {
+		return vs, fmt.Errorf("link buffer peek vectors[%d] not enough", n)
+	}
+	var node = b.read
+	var l int
+	for ack := n; ack > 0; ack = ack - l {
+		l = node.Len()
+		if l >= ack {
+			vs = append(vs, node.Peek(ack))
+			break
+		} else if l > 0 {
+			vs = append(vs, node.Peek(l))
+		}
+		node = node.next
+	}
+	return vs, nil
+}
+
 // Skip implements Reader.
 func (b *LinkBuffer) Skip(n int) (err error) {
 	b.Lock()
@@ -183,6 +249,19 @@ func (b *LinkBuffer) Skip(n int) (err error) {
 	return nil
 }
 
+// peekBytes copies the readable bytes into p without advancing the reader, and returns the number of bytes copied.
+func (b *LinkBuffer) peekBytes(p []byte) (n int) {
+	b.Lock()
+	defer b.Unlock()
+	if l := b.Len(); l < len(p) {
+		p = p[:l]
+	}
+	for node := b.read; n < len(p); node = node.next {
+		n += copy(p[n:], node.buf[node.off:])
+	}
+	return n
+}
+
 // Until returns a slice ends with the delim in the buffer.
 func (b *LinkBuffer) Until(delim byte) (line []byte, err error) {
 	b.Lock()
@@ -194,6 +273,30 @@ func (b *LinkBuffer) Until(delim byte) (line []byte, err error) {
 	return b.Next(n + 1)
 }
 
+// UntilBytes returns a slice ends with the delim in the buffer.
+func (b *LinkBuffer) UntilBytes(delim []byte, maxLen int) (line []byte, err error) {
+	n := b.indexBytes(delim, 0)
+	if n < 0 {
+		// the line will be longer than the buffer if the delim comes later.
+		if maxLen > 0 && b.Len() >= maxLen {
+			return nil, Exception(ErrTooLong, "when until bytes")
+		}
+		return nil, fmt.Errorf("link buffer read slice cannot find delim: %q", delim)
+	}
+	if maxLen > 0 && n+len(delim) > maxLen {
+		return nil, Exception(ErrTooLong, "when until bytes")
+	}
+	return b.Next(n + len(delim))
+}
+
+// Index returns the index of the first sep in the buffer at or after from, or -1 if sep is not present.
+func (b *LinkBuffer) Index(sep []byte, from int) (i int) {
+	if from < 0 {
+		from = 0
+	}
+	return b.indexBytes(sep, from)
+}
+
 // Release the node that has been read.
 // b.flush == nil indicates that this LinkBuffer is created by LinkBuffer.Slice
 func (b *LinkBuffer) Release() (err error) {
@@ -212,7 +315,7 @@ func (b *LinkBuffer) release() (err error) {
 		node.Release()
 	}
 	for i := range b.caches {
-		free(b.caches[i])
+		b.account.free(b.caches[i])
 		b.caches[i] = nil
 	}
 	b.caches = b.caches[:0]
@@ -309,7 +412,8 @@ func (b *LinkBuffer) Slice(n int) (r Reader, err error) {
 
 	// just use for range
 	p := &LinkBuffer{
-		length: int32(n),
+		length:  int32(n),
+		account: b.account,
 	}
 	defer func() {
 		// set to read-only
@@ -399,7 +503,7 @@ func (b *LinkBuffer) Flush() (err error) {
 	b.mallocSize = 0
 	// FIXME: The tail node must not be larger than 8KB to prevent Out Of Memory.
 	if cap(b.write.buf) > pagesize {
-		b.write.next = newLinkBufferNode(0)
+		b.write.next = newLinkBufferNode(0, nil)
 		b.write = b.write.next
 	}
 	var n int
@@ -490,7 +594,7 @@ func (b *LinkBuffer) WriteBinary(p []byte) (n int, err error) {
 	// TODO: Verify that all nocopy is possible under mcache.
 	if n > BinaryInplaceThreshold {
 		// expand buffer directly with nocopy
-		b.write.next = newLinkBufferNode(0)
+		b.write.next = newLinkBufferNode(0, nil)
 		b.write = b.write.next
 		b.write.buf, b.write.malloc = p[:0], n
 		return n, nil
@@ -521,10 +625,10 @@ func (b *LinkBuffer) WriteDirect(p []byte, remainLen int) error {
 	malloc += len(origin.buf)
 
 	// Create dataNode and newNode and insert them into the chain
-	dataNode := newLinkBufferNode(0)
+	dataNode := newLinkBufferNode(0, nil)
 	dataNode.buf, dataNode.malloc = p[:0], n
 
-	newNode := newLinkBufferNode(0)
+	newNode := newLinkBufferNode(0, nil)
 	newNode.off = malloc
 	newNode.buf = origin.buf[:malloc]
 	newNode.malloc = origin.malloc
@@ -593,6 +697,62 @@ func (b *LinkBuffer) Bytes() []byte {
 	return p[:n]
 }
 
+// WriteTo implements io.WriterTo, which writes the readable data of each node to w directly,
+// until there is no readable data or an error occurs. The data written is skipped,
+// and releasing it is left to the caller, as the other read methods do.
+func (b *LinkBuffer) WriteTo(w io.Writer) (n int64, err error) {
+	b.Lock()
+	defer b.Unlock()
+	var p []byte
+	var m int
+	for b.Len() > 0 {
+		for b.read.Len() == 0 {
+			b.read = b.read.next
+		}
+		p = b.read.Peek(b.read.Len())
+		m, err = w.Write(p)
+		if m < 0 || m > len(p) {
+			m, err = 0, fmt.Errorf("link buffer write to invalid count[%d]", m)
+		}
+		b.read.off += m
+		b.recalLen(-m) // re-cal length
+		n += int64(m)
+		if err == nil && m < len(p) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ReadFrom implements io.ReaderFrom, which reads from r into the nodes directly until io.EOF or an error occurs.
+// The data read is flushed, including the data allocated by Malloc before.
+func (b *LinkBuffer) ReadFrom(r io.Reader) (n int64, err error) {
+	var buf []byte
+	var m int
+	for {
+		size := b.MallocLen()
+		if buf, err = b.Malloc(pagesize); err != nil {
+			return n, err
+		}
+		m, err = r.Read(buf)
+		if m < 0 || m > len(buf) {
+			m, err = 0, fmt.Errorf("link buffer read from invalid count[%d]", m)
+		}
+		b.MallocAck(size + m)
+		b.Flush()
+		n += int64(m)
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return n, err
+		}
+	}
+}
+
 // GetBytes will read and fill the slice p as much as possible.
 func (b *LinkBuffer) GetBytes(p [][]byte) (vs [][]byte) {
 	b.Lock()
@@ -624,7 +784,7 @@ func (b *LinkBuffer) book(bookSize, maxSize int) (p []byte) {
 	// grow linkBuffer
 	if l == 0 {
 		l = maxSize
-		b.write.next = newLinkBufferNode(maxSize)
+		b.write.next = newLinkBufferNode(maxSize, b.account)
 		b.write = b.write.next
 	}
 	if l > bookSize {
@@ -690,17 +850,91 @@ func (b *LinkBuffer) indexByte(c byte, skip int) int {
 	return -1
 }
 
+// indexBytes returns the index of the first sep in the buffer after skipping skip bytes, or -1 if not found.
+// It searches node by node, and the sep can span the nodes.
+func (b *LinkBuffer) indexBytes(sep []byte, skip int) int {
+	b.Lock()
+	defer b.Unlock()
+	size := b.Len()
+	if len(sep) == 0 {
+		if skip > size {
+			return -1
+		}
+		return skip
+	}
+	var unread, n, l int
+	node := b.read
+	for unread = size; unread > 0; unread -= n {
+		l = node.Len()
+		if l >= unread { // last node
+			n = unread
+		} else { // read full node
+			n = l
+		}
+
+		// skip current node
+		if skip >= n {
+			skip -= n
+			node = node.next
+			continue
+		}
+		var p = node.Peek(n)
+		for skip < n {
+			i := bytes.IndexByte(p[skip:], sep[0])
+			if i < 0 {
+				break
+			}
+			skip += i
+			var past = size - unread + skip
+			if past+len(sep) > size {
+				return -1
+			}
+			if n-skip >= len(sep) {
+				if bytes.Equal(p[skip:skip+len(sep)], sep) {
+					return past
+				}
+			} else if bytes.Equal(p[skip:], sep[:n-skip]) && hasPrefixAt(node.next, sep[n-skip:]) {
+				// the sep spans the nodes.
+				return past
+			}
+			skip++
+		}
+		skip = 0 // no skip bytes
+		node = node.next
+	}
+	return -1
+}
+
+// hasPrefixAt reports whether the data beginning at node starts with prefix,
+// which must be no longer than the readable data.
+func hasPrefixAt(node *linkBufferNode, prefix []byte) bool {
+	for len(prefix) > 0 {
+		var p = node.buf[node.off:]
+		if len(p) > len(prefix) {
+			p = p[:len(prefix)]
+		}
+		if !bytes.Equal(p, prefix[:len(p)]) {
+			return false
+		}
+		prefix = prefix[len(p):]
+		node = node.next
+	}
+	return true
+}
+
 // resetTail will reset tail node or add an empty tail node to
 // guarantee the tail node is not larger than 8KB
 func (b *LinkBuffer) resetTail(maxSize int) {
 	// FIXME: The tail node must not be larger than 8KB to prevent Out Of Memory.
-	if maxSize <= pagesize {
+	// The safe mode never reuses the tail node, so that it can be released.
+	// The tail node allocated before maxSize shrinks isn't reused either.
+	if maxSize <= pagesize && cap(b.write.buf) <= pagesize && !safeMode {
 		b.write.Reset()
 		return
 	}
 
 	// set nil tail
-	b.write.next = newLinkBufferNode(0)
+	b.write.next = newLinkBufferNode(0, nil)
 	b.write = b.write.next
 	b.flush = b.write
 	return
@@ -714,8 +948,8 @@ func (b *LinkBuffer) recalLen(delta int) (length int) {
 // ------------------------------------------ implement link node ------------------------------------------
 
 // newLinkBufferNode create or reuse linkBufferNode.
-// Nodes with size <= 0 are marked as readonly, which means the node.buf is not allocated by this mcache.
-func newLinkBufferNode(size int) *linkBufferNode {
+// Nodes with size <= 0 are marked as readonly, which means the node.buf is not allocated by the account.
+func newLinkBufferNode(size int, account *memAccount) *linkBufferNode {
 	var node = linkedPool.Get().(*linkBufferNode)
 	// reset node offset
 	node.off, node.malloc, node.refer, node.readonly = 0, 0, 1, false
@@ -726,7 +960,11 @@ func newLinkBufferNode(size int) *linkBufferNode {
 	if size < LinkBufferCap {
 		size = LinkBufferCap
 	}
-	node.buf = malloc(0, size)
+	if account == nil {
+		account = defaultAccount()
+	}
+	node.buf = account.malloc(0, size)
+	node.account = account
 	return node
 }
 
@@ -746,6 +984,7 @@ type linkBufferNode struct {
 	readonly bool            // read-only node, introduced by Refer, WriteString, WriteBinary, etc., default false
 	origin   *linkBufferNode // the root node of the extends
 	next     *linkBufferNode // the next node of the linked buffer
+	account  *memAccount     // the account node.buf is allocated by, nil if readonly
 }
 
 func (node *linkBufferNode) Len() (l int) {
@@ -766,16 +1005,25 @@ func (node *linkBufferNode) Reset() {
 }
 
 func (node *linkBufferNode) Next(n int) (p []byte) {
+	if safeMode {
+		safeTake(node.buf)
+	}
 	off := node.off
 	node.off += n
 	return node.buf[off:node.off]
 }
 
 func (node *linkBufferNode) Peek(n int) (p []byte) {
+	if safeMode {
+		safeTake(node.buf)
+	}
 	return node.buf[node.off : node.off+n]
 }
 
 func (node *linkBufferNode) Malloc(n int) (buf []byte) {
+	if safeMode {
+		safeTake(node.buf)
+	}
 	malloc := node.malloc
 	node.malloc += n
 	return node.buf[malloc:node.malloc]
@@ -784,7 +1032,7 @@ func (node *linkBufferNode) Malloc(n int) (buf []byte) {
 // Refer holds a reference count at the same time as Next, and releases the real buffer after Release.
 // The node obtained by Refer is read-only.
 func (node *linkBufferNode) Refer(n int) (p *linkBufferNode) {
-	p = newLinkBufferNode(0)
+	p = newLinkBufferNode(0, nil)
 	p.buf = node.Next(n)
 
 	if node.origin != nil {
@@ -805,11 +1053,11 @@ func (node *linkBufferNode) Release() (err error) {
 	}
 	// release self
 	if atomic.AddInt32(&node.refer, -1) == 0 {
-		// readonly nodes cannot recycle node.buf, other node.buf are recycled to mcache.
+		// readonly nodes cannot recycle node.buf, other node.buf are recycled to the account.
 		if !node.readonly {
-			free(node.buf)
+			node.account.free(node.buf)
 		}
-		node.buf, node.origin, node.next = nil, nil, nil
+		node.buf, node.origin, node.next, node.account = nil, nil, nil, nil
 		linkedPool.Put(node)
 	}
 	return nil
@@ -825,7 +1073,7 @@ func (b *LinkBuffer) growth(n int) {
 	// Must skip read-only node.
 	for b.write.readonly || cap(b.write.buf)-b.write.malloc < n {
 		if b.write.next == nil {
-			b.write.next = newLinkBufferNode(n)
+			b.write.next = newLinkBufferNode(n, b.account)
 			b.write = b.write.next
 			return
 		}
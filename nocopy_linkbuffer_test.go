@@ -19,7 +19,10 @@ package netpoll
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"sync/atomic"
 	"testing"
 )
@@ -562,6 +565,230 @@ func TestLinkBufferIndexByte(t *testing.T) {
 	}
 }
 
+func TestLinkBufferBinary(t *testing.T) {
+	var data = make([]byte, 28+2*binary.MaxVarintLen64)
+	binary.BigEndian.PutUint16(data[0:], 0x0102)
+	binary.BigEndian.PutUint32(data[2:], 0x03040506)
+	binary.BigEndian.PutUint64(data[6:], 0x0708090a0b0c0d0e)
+	binary.LittleEndian.PutUint16(data[14:], 0x0102)
+	binary.LittleEndian.PutUint32(data[16:], 0x03040506)
+	binary.LittleEndian.PutUint64(data[20:], 0x0708090a0b0c0d0e)
+	var n = 28
+	n += binary.PutUvarint(data[n:], 300)
+	n += binary.PutVarint(data[n:], -12345)
+	data = data[:n]
+
+	// write
+	var lb = NewLinkBuffer()
+	MustNil(t, lb.WriteUint16(0x0102))
+	MustNil(t, lb.WriteUint32(0x03040506))
+	MustNil(t, lb.WriteUint64(0x0708090a0b0c0d0e))
+	MustNil(t, lb.WriteUint16LE(0x0102))
+	MustNil(t, lb.WriteUint32LE(0x03040506))
+	MustNil(t, lb.WriteUint64LE(0x0708090a0b0c0d0e))
+	MustNil(t, lb.WriteUvarint(300))
+	MustNil(t, lb.WriteVarint(-12345))
+	lb.Flush()
+	MustTrue(t, bytes.Equal(lb.Bytes(), data))
+
+	// read the values across the nodes
+	for size := 1; size <= len(data); size++ {
+		lb = newSplitLinkBuffer(data, size)
+		v16, err := lb.ReadUint16()
+		MustNil(t, err)
+		Equal(t, v16, uint16(0x0102))
+		v32, err := lb.ReadUint32()
+		MustNil(t, err)
+		Equal(t, v32, uint32(0x03040506))
+		v64, err := lb.ReadUint64()
+		MustNil(t, err)
+		Equal(t, v64, uint64(0x0708090a0b0c0d0e))
+		v16, err = lb.ReadUint16LE()
+		MustNil(t, err)
+		Equal(t, v16, uint16(0x0102))
+		v32, err = lb.ReadUint32LE()
+		MustNil(t, err)
+		Equal(t, v32, uint32(0x03040506))
+		v64, err = lb.ReadUint64LE()
+		MustNil(t, err)
+		Equal(t, v64, uint64(0x0708090a0b0c0d0e))
+		uv, err := lb.ReadUvarint()
+		MustNil(t, err)
+		Equal(t, uv, uint64(300))
+		iv, err := lb.ReadVarint()
+		MustNil(t, err)
+		Equal(t, iv, int64(-12345))
+		Equal(t, lb.Len(), 0)
+	}
+
+	// not enough or invalid, and the reader is not advanced.
+	lb = newSplitLinkBuffer([]byte{1, 2, 3}, 2)
+	_, err := lb.ReadUint32()
+	MustTrue(t, err != nil)
+	Equal(t, lb.Len(), 3)
+	lb = newSplitLinkBuffer([]byte{0x80, 0x80}, 1)
+	_, err = lb.ReadUvarint()
+	MustTrue(t, err != nil)
+	Equal(t, lb.Len(), 2)
+	lb = newSplitLinkBuffer(bytes.Repeat([]byte{0xff}, 11), 3)
+	_, err = lb.ReadUvarint()
+	MustTrue(t, errors.Is(err, ErrVarintOverflow))
+	Equal(t, lb.Len(), 11)
+
+	// no allocation even if the values span the nodes.
+	lb = newSplitLinkBuffer(make([]byte, 4*101), 3)
+	var allocs = testing.AllocsPerRun(100, func() {
+		_, _ = lb.ReadUint32()
+	})
+	Equal(t, allocs, float64(0))
+	Equal(t, lb.Len(), 0)
+}
+
+func TestLinkBufferUntilBytes(t *testing.T) {
+	var data = []byte("GET / HTTP/1.1\r\nHost: a\r\n\r\nbody\r\r\n\r")
+	var delim = []byte("\r\n\r\n")
+	var head = bytes.Index(data, delim)
+	for size := 1; size <= len(data); size++ {
+		lb := newSplitLinkBuffer(data, size)
+		Equal(t, lb.Index(delim, 0), head)
+		Equal(t, lb.Index([]byte("body"), -1), bytes.Index(data, []byte("body")))
+		Equal(t, lb.Index([]byte("\r\n\rx"), 0), -1)
+		Equal(t, lb.Index(delim, head+1), -1)
+		Equal(t, lb.Index([]byte("\r\n"), head+1), head+2)
+
+		_, err := lb.UntilBytes(delim, head+len(delim)-1)
+		MustTrue(t, errors.Is(err, ErrTooLong))
+		line, err := lb.UntilBytes(delim, head+len(delim))
+		MustNil(t, err)
+		Equal(t, string(line), string(data[:head+len(delim)]))
+		line, err = lb.UntilBytes([]byte("\r\n"), 0)
+		MustNil(t, err)
+		Equal(t, string(line), "body\r\r\n")
+		_, err = lb.UntilBytes([]byte("\r\n"), 0)
+		MustTrue(t, err != nil)
+		_, err = lb.UntilBytes([]byte("\r\n"), 1)
+		MustTrue(t, errors.Is(err, ErrTooLong))
+		Equal(t, lb.Len(), 1)
+	}
+}
+
+func TestLinkBufferVectors(t *testing.T) {
+	var data = []byte("0123456789abcdef")
+	var lb = newSplitLinkBuffer(data, 3)
+	peek, err := lb.PeekVectors(7)
+	MustNil(t, err)
+	Equal(t, len(peek), 3)
+	Equal(t, string(bytes.Join(peek, nil)), "0123456")
+	Equal(t, lb.Len(), len(data))
+
+	// the vectors point into the nodes.
+	vs, err := lb.NextVectors(7)
+	MustNil(t, err)
+	Equal(t, len(vs), 3)
+	Equal(t, string(bytes.Join(vs, nil)), "0123456")
+	for i := range vs {
+		Equal(t, &vs[i][0], &peek[i][0])
+	}
+	Equal(t, lb.Len(), len(data)-7)
+	vs, err = lb.NextVectors(2)
+	MustNil(t, err)
+	Equal(t, len(vs), 1)
+	Equal(t, string(vs[0]), "78")
+	vs, err = lb.NextVectors(7)
+	MustNil(t, err)
+	Equal(t, string(bytes.Join(vs, nil)), "9abcdef")
+	_, err = lb.NextVectors(1)
+	MustTrue(t, err != nil)
+	_, err = lb.PeekVectors(1)
+	MustTrue(t, err != nil)
+	MustNil(t, lb.Release())
+}
+
+func TestLinkBufferWriteToReadFrom(t *testing.T) {
+	var data = []byte("0123456789abcdef")
+	var lb = newSplitLinkBuffer(data, 3)
+	var w = &writesRecorder{}
+	n, err := lb.WriteTo(w)
+	MustNil(t, err)
+	Equal(t, n, int64(len(data)))
+	Equal(t, w.String(), string(data))
+	// each node is written directly.
+	Equal(t, w.writes, 6)
+	Equal(t, lb.Len(), 0)
+
+	// the slices read before are not released by WriteTo, and so their memory is not reused.
+	lb = NewLinkBuffer(pagesize)
+	var large = bytes.Repeat(data, pagesize/len(data))
+	for i := 0; i < 2; i++ {
+		buf, _ := lb.Malloc(len(large))
+		copy(buf, large)
+		MustNil(t, lb.Flush())
+	}
+	prev, err := lb.Next(len(large))
+	MustNil(t, err)
+	w = &writesRecorder{}
+	n, err = lb.WriteTo(w)
+	MustNil(t, err)
+	Equal(t, n, int64(len(large)))
+	for i := 0; i < 4; i++ {
+		buf, _ := lb.Malloc(len(large))
+		copy(buf, bytes.Repeat([]byte{'x'}, len(buf)))
+		MustNil(t, lb.Flush())
+	}
+	MustTrue(t, bytes.Equal(prev, large))
+	MustNil(t, lb.Release())
+
+	// the data malloced before is flushed with the data read.
+	lb = NewLinkBuffer()
+	MustNil(t, lb.WriteByte('>'))
+	large = bytes.Repeat(data, 1024)
+	n, err = lb.ReadFrom(bytes.NewReader(large))
+	MustNil(t, err)
+	Equal(t, n, int64(len(large)))
+	Equal(t, lb.Len(), len(large)+1)
+	Equal(t, string(lb.Bytes()), ">"+string(large))
+
+	// the data written is skipped if w fails.
+	w = &writesRecorder{limit: 5}
+	n, err = lb.WriteTo(w)
+	Equal(t, err, io.ErrShortWrite)
+	Equal(t, n, int64(5))
+	Equal(t, lb.Len(), len(large)-4)
+	MustNil(t, lb.Close())
+}
+
+type writesRecorder struct {
+	bytes.Buffer
+	writes int
+	limit  int
+}
+
+func (w *writesRecorder) Write(p []byte) (n int, err error) {
+	w.writes++
+	if w.limit > 0 && len(p) > w.limit-w.Len() {
+		p = p[:w.limit-w.Len()]
+	}
+	return w.Buffer.Write(p)
+}
+
+// newSplitLinkBuffer returns a LinkBuffer of data, with each node holding size bytes at most.
+func newSplitLinkBuffer(data []byte, size int) *LinkBuffer {
+	var lb = NewLinkBuffer()
+	for len(data) > 0 {
+		var n = size
+		if n > len(data) {
+			n = len(data)
+		}
+		var node = NewLinkBuffer()
+		buf, _ := node.Malloc(n)
+		copy(buf, data[:n])
+		lb.Append(node)
+		data = data[n:]
+	}
+	lb.Flush()
+	return lb
+}
+
 func BenchmarkStringToSliceByte(b *testing.B) {
 	b.StopTimer()
 	s := "hello world"
@@ -606,7 +833,7 @@ func BenchmarkPoolGet(b *testing.B) {
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
-			v = newLinkBufferNode(0)
+			v = newLinkBufferNode(0, nil)
 			v.Release()
 		}
 	})
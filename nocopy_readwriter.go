@@ -52,6 +52,22 @@ func (r *zcReader) Peek(n int) (buf []byte, err error) {
 	return r.buf.Peek(n)
 }
 
+// NextVectors implements VectorReader.
+func (r *zcReader) NextVectors(n int) (vs [][]byte, err error) {
+	if err = r.waitRead(n); err != nil {
+		return vs, err
+	}
+	return r.buf.NextVectors(n)
+}
+
+// PeekVectors implements VectorReader.
+func (r *zcReader) PeekVectors(n int) (vs [][]byte, err error) {
+	if err = r.waitRead(n); err != nil {
+		return vs, err
+	}
+	return r.buf.PeekVectors(n)
+}
+
 // Skip implements Reader.
 func (r *zcReader) Skip(n int) (err error) {
 	if err = r.waitRead(n); err != nil {
@@ -102,10 +118,79 @@ func (r *zcReader) ReadByte() (b byte, err error) {
 	return r.buf.ReadByte()
 }
 
+// ReadUint16 implements BinaryReader.
+func (r *zcReader) ReadUint16() (v uint16, err error) {
+	if err = r.waitRead(2); err != nil {
+		return v, err
+	}
+	return r.buf.ReadUint16()
+}
+
+// ReadUint32 implements BinaryReader.
+func (r *zcReader) ReadUint32() (v uint32, err error) {
+	if err = r.waitRead(4); err != nil {
+		return v, err
+	}
+	return r.buf.ReadUint32()
+}
+
+// ReadUint64 implements BinaryReader.
+func (r *zcReader) ReadUint64() (v uint64, err error) {
+	if err = r.waitRead(8); err != nil {
+		return v, err
+	}
+	return r.buf.ReadUint64()
+}
+
+// ReadUint16LE implements BinaryReader.
+func (r *zcReader) ReadUint16LE() (v uint16, err error) {
+	if err = r.waitRead(2); err != nil {
+		return v, err
+	}
+	return r.buf.ReadUint16LE()
+}
+
+// ReadUint32LE implements BinaryReader.
+func (r *zcReader) ReadUint32LE() (v uint32, err error) {
+	if err = r.waitRead(4); err != nil {
+		return v, err
+	}
+	return r.buf.ReadUint32LE()
+}
+
+// ReadUint64LE implements BinaryReader.
+func (r *zcReader) ReadUint64LE() (v uint64, err error) {
+	if err = r.waitRead(8); err != nil {
+		return v, err
+	}
+	return r.buf.ReadUint64LE()
+}
+
+// ReadUvarint implements BinaryReader.
+func (r *zcReader) ReadUvarint() (v uint64, err error) {
+	return readUvarint(r.buf, r.waitRead)
+}
+
+// ReadVarint implements BinaryReader.
+func (r *zcReader) ReadVarint() (v int64, err error) {
+	ux, err := r.ReadUvarint()
+	return unzigzag(ux), err
+}
+
 func (r *zcReader) Until(delim byte) (line []byte, err error) {
 	return r.buf.Until(delim)
 }
 
+// UntilBytes implements DelimiterReader.
+func (r *zcReader) UntilBytes(delim []byte, maxLen int) (line []byte, err error) {
+	return r.buf.UntilBytes(delim, maxLen)
+}
+
+// Index implements DelimiterReader.
+func (r *zcReader) Index(sep []byte, from int) (i int) {
+	return r.buf.Index(sep, from)
+}
+
 func (r *zcReader) waitRead(n int) (err error) {
 	for r.buf.Len() < n {
 		err = r.fill(n)
@@ -210,6 +295,46 @@ func (w *zcWriter) WriteByte(b byte) (err error) {
 	return w.buf.WriteByte(b)
 }
 
+// WriteUint16 implements BinaryWriter.
+func (w *zcWriter) WriteUint16(v uint16) (err error) {
+	return w.buf.WriteUint16(v)
+}
+
+// WriteUint32 implements BinaryWriter.
+func (w *zcWriter) WriteUint32(v uint32) (err error) {
+	return w.buf.WriteUint32(v)
+}
+
+// WriteUint64 implements BinaryWriter.
+func (w *zcWriter) WriteUint64(v uint64) (err error) {
+	return w.buf.WriteUint64(v)
+}
+
+// WriteUint16LE implements BinaryWriter.
+func (w *zcWriter) WriteUint16LE(v uint16) (err error) {
+	return w.buf.WriteUint16LE(v)
+}
+
+// WriteUint32LE implements BinaryWriter.
+func (w *zcWriter) WriteUint32LE(v uint32) (err error) {
+	return w.buf.WriteUint32LE(v)
+}
+
+// WriteUint64LE implements BinaryWriter.
+func (w *zcWriter) WriteUint64LE(v uint64) (err error) {
+	return w.buf.WriteUint64LE(v)
+}
+
+// WriteUvarint implements BinaryWriter.
+func (w *zcWriter) WriteUvarint(v uint64) (err error) {
+	return w.buf.WriteUvarint(v)
+}
+
+// WriteVarint implements BinaryWriter.
+func (w *zcWriter) WriteVarint(v int64) (err error) {
+	return w.buf.WriteVarint(v)
+}
+
 // zcWriter implements ReadWriter.
 type zcReadWriter struct {
 	*zcReader
@@ -0,0 +1,193 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build netpoll_safe && !windows
+// +build netpoll_safe,!windows
+
+// The safe mode built with the tag netpoll_safe detects the slices used after Release, which is only for debugging.
+// The memory of LinkBuffer is mapped out of the Go heap, and protected from access once released,
+// so reading or writing the slices returned by Next, Peek, etc. after Release faults at once
+// with the stack of the misuse, instead of corrupting the data silently.
+// The stacks of the callers taking the slices of each buffer are recorded, and reported by the panic of
+// releasing a buffer twice, or by OnRequest faulting on a buffer released, which is recovered and panics again.
+
+package netpoll
+
+import (
+	"fmt"
+	"math/bits"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+func init() {
+	safeMode = true
+	var allocator = &safeAllocator{pages: make(map[uintptr]*safeBuffer)}
+	defaultAllocator = allocator
+	safeTake = allocator.take
+	safeCatch = allocator.catch
+	SetAllocator(nil)
+}
+
+// safeQuarantine is the number of the released buffers kept protected,
+// beyond which the earliest is unmapped and the address may be reused.
+// It's limited since each buffer is a memory mapping.
+const safeQuarantine = 8192
+
+// safeTakers is the number of the latest callers recorded for each buffer.
+const safeTakers = 4
+
+// safeAllocator maps each buffer by mmap, and never reuses it until unmapped from the quarantine.
+type safeAllocator struct {
+	mu         sync.Mutex
+	pages      map[uintptr]*safeBuffer // the buffers mapped by the address of each page
+	quarantine []*safeBuffer           // the buffers released and protected, in order of release
+}
+
+// safeBuffer is a memory mapping, which records the callers taking its slices.
+type safeBuffer struct {
+	mem      []byte
+	released bool
+	takers   [][]uintptr // the stacks of the latest callers, the last is the latest
+}
+
+func (a *safeAllocator) Malloc(size, capacity int) []byte {
+	if capacity <= 0 {
+		return make([]byte, size, capacity)
+	}
+	// align up to the power of 2 like mcache, so that the buffers behave the same as the default.
+	capacity = 1 << bits.Len(uint(capacity-1))
+	var pageSize = syscall.Getpagesize()
+	var n = (capacity + pageSize - 1) / pageSize * pageSize
+	mem, err := syscall.Mmap(-1, 0, n, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		panic(fmt.Sprintf("NETPOLL: safe mode mmap %d bytes failed: %v", n, err))
+	}
+	var sb = &safeBuffer{mem: mem}
+	a.mu.Lock()
+	for off := 0; off < n; off += pageSize {
+		a.pages[bufferAddr(mem)+uintptr(off)] = sb
+	}
+	a.mu.Unlock()
+	return mem[:size:capacity]
+}
+
+func (a *safeAllocator) Free(buf []byte) {
+	if cap(buf) == 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var addr = bufferAddr(buf)
+	var sb = a.pages[addr]
+	if sb == nil || sb.released || bufferAddr(sb.mem) != addr {
+		var takers string
+		if sb != nil {
+			takers = sb.stacks()
+		}
+		panic(fmt.Sprintf("NETPOLL: safe mode frees the buffer %#x not allocated or released twice%s", addr, takers))
+	}
+	if err := syscall.Mprotect(sb.mem, syscall.PROT_NONE); err != nil {
+		panic(fmt.Sprintf("NETPOLL: safe mode mprotect the buffer %#x failed: %v", addr, err))
+	}
+	sb.released = true
+	a.quarantine = append(a.quarantine, sb)
+	if len(a.quarantine) > safeQuarantine {
+		var earliest = a.quarantine[0]
+		for off := 0; off < len(earliest.mem); off += syscall.Getpagesize() {
+			delete(a.pages, bufferAddr(earliest.mem)+uintptr(off))
+		}
+		syscall.Munmap(earliest.mem)
+		a.quarantine[0] = nil
+		a.quarantine = a.quarantine[1:]
+	}
+}
+
+// take records the stack of the caller taking a slice of buf.
+func (a *safeAllocator) take(buf []byte) {
+	if cap(buf) == 0 {
+		return
+	}
+	var pcs = make([]uintptr, 32)
+	pcs = pcs[:runtime.Callers(2, pcs)]
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var sb = a.lookup(bufferAddr(buf))
+	if sb == nil || sb.released {
+		return
+	}
+	if len(sb.takers) == safeTakers {
+		sb.takers = sb.takers[:copy(sb.takers, sb.takers[1:])]
+	}
+	sb.takers = append(sb.takers, pcs)
+}
+
+// catch is deferred with the previous setting of SetPanicOnFault, and reports the callers taking
+// the slices of the buffer released if the fault is recovered, and then panics again.
+func (a *safeAllocator) catch(panicOnFault bool) {
+	debug.SetPanicOnFault(panicOnFault)
+	var r = recover()
+	if r == nil {
+		return
+	}
+	if fault, ok := r.(interface{ Addr() uintptr }); ok {
+		if takers := a.takers(fault.Addr()); takers != "" {
+			logger.Printf("NETPOLL: safe mode faults on the buffer %#x released%s", fault.Addr(), takers)
+		}
+	}
+	panic(r)
+}
+
+// takers returns the stacks of the callers taking the slices of the buffer at addr,
+// or an empty string if it's not a buffer released.
+func (a *safeAllocator) takers(addr uintptr) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var sb = a.lookup(addr)
+	if sb == nil || !sb.released {
+		return ""
+	}
+	return sb.stacks()
+}
+
+// lookup returns the buffer containing addr, which must be called with mu locked.
+func (a *safeAllocator) lookup(addr uintptr) *safeBuffer {
+	return a.pages[addr&^uintptr(syscall.Getpagesize()-1)]
+}
+
+// stacks formats the stacks of the callers, from the latest.
+func (sb *safeBuffer) stacks() string {
+	var s strings.Builder
+	for i := len(sb.takers) - 1; i >= 0; i-- {
+		s.WriteString(", taken by:\n")
+		var frames = runtime.CallersFrames(sb.takers[i])
+		for {
+			frame, more := frames.Next()
+			fmt.Fprintf(&s, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+			if !more {
+				break
+			}
+		}
+	}
+	return s.String()
+}
+
+// bufferAddr returns the address of the underlying array of buf.
+func bufferAddr(buf []byte) uintptr {
+	return uintptr(unsafe.Pointer(&buf[:1][0]))
+}
@@ -0,0 +1,116 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build netpoll_safe && !windows
+// +build netpoll_safe,!windows
+
+package netpoll
+
+import (
+	"bytes"
+	"os"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestSafeLinkBuffer(t *testing.T) {
+	buf := NewLinkBuffer()
+	var msg = make([]byte, pagesize)
+	msg[0], msg[len(msg)-1] = 'a', 'z'
+	for i := 0; i < 2; i++ {
+		w, err := buf.Malloc(len(msg))
+		MustNil(t, err)
+		copy(w, msg)
+		MustNil(t, buf.Flush())
+	}
+
+	p, err := buf.Next(len(msg))
+	MustNil(t, err)
+	Equal(t, p[0], byte('a'))
+	Equal(t, p[len(p)-1], byte('z'))
+	MustNil(t, buf.Release())
+
+	// any access of the slice after Release faults.
+	MustTrue(t, faulted(func() { sink = p[0] }))
+	MustTrue(t, faulted(func() { p[len(p)-1] = 0 }))
+
+	// the tail node drained is not reused, but released.
+	p, err = buf.Next(len(msg))
+	MustNil(t, err)
+	buf.resetTail(pagesize)
+	MustNil(t, buf.Release())
+	MustTrue(t, faulted(func() { sink = p[0] }))
+
+	// releasing the buffer twice panics.
+	var b = defaultAllocator.Malloc(0, block1k)
+	defaultAllocator.Free(b)
+	MustTrue(t, faulted(func() { defaultAllocator.Free(b) }))
+	MustNil(t, buf.Close())
+}
+
+func TestSafeTakers(t *testing.T) {
+	// the first node is released after read, while the second is still in use.
+	buf := NewLinkBuffer()
+	for i := 0; i < 2; i++ {
+		_, err := buf.Malloc(pagesize)
+		MustNil(t, err)
+		MustNil(t, buf.Flush())
+	}
+	var p = takeSafeSlice(t, buf, pagesize)
+	MustNil(t, buf.Release())
+	var allocator = defaultAllocator.(*safeAllocator)
+	MustTrue(t, strings.Contains(allocator.takers(bufferAddr(p)), "takeSafeSlice"))
+
+	// the fault recovered reports the caller taking the slice.
+	var out bytes.Buffer
+	setLoggerOutput(&out)
+	defer setLoggerOutput(os.Stderr)
+	MustTrue(t, faulted(func() {
+		defer safeCatch(debug.SetPanicOnFault(true))
+		sink = p[0]
+	}))
+	MustTrue(t, strings.Contains(out.String(), "takeSafeSlice"))
+
+	// so does the panic of releasing twice.
+	var b = defaultAllocator.Malloc(0, block1k)
+	safeTake(b)
+	defaultAllocator.Free(b)
+	var msg string
+	func() {
+		defer func() {
+			msg, _ = recover().(string)
+		}()
+		defaultAllocator.Free(b)
+	}()
+	MustTrue(t, strings.Contains(msg, "TestSafeTakers"))
+	MustNil(t, buf.Close())
+}
+
+func takeSafeSlice(t *testing.T, buf *LinkBuffer, n int) []byte {
+	p, err := buf.Next(n)
+	MustNil(t, err)
+	return p
+}
+
+var sink byte
+
+func faulted(f func()) (ok bool) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		ok = recover() != nil
+	}()
+	f()
+	return false
+}
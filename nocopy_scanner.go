@@ -0,0 +1,141 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"bufio"
+	"errors"
+)
+
+// Scanner reads the tokens split by a bufio.SplitFunc from a Reader, like bufio.Scanner.
+// The split function is called on the data peeked from the Reader, which is the memory of the node directly
+// if the data is in one node, so the token is valid only until the next call of Scan.
+// Scanner releases the Reader on Scan, so the data read from the Reader elsewhere should not be used any more.
+type Scanner struct {
+	r     Reader
+	split bufio.SplitFunc
+	max   int
+	token []byte
+	err   error
+	done  bool
+}
+
+// NewScanner returns a Scanner reading from r, which splits the lines by bufio.ScanLines by default.
+func NewScanner(r Reader) *Scanner {
+	return &Scanner{
+		r:     r,
+		split: bufio.ScanLines,
+		max:   bufio.MaxScanTokenSize,
+	}
+}
+
+// Split sets the split function of the Scanner, which must be called before Scan.
+func (s *Scanner) Split(split bufio.SplitFunc) {
+	s.split = split
+}
+
+// Buffer sets the maximum size of a token, which is bufio.MaxScanTokenSize by default.
+func (s *Scanner) Buffer(max int) {
+	s.max = max
+}
+
+// Scan advances to the next token, which is available by Bytes or Text.
+// It returns false when the scan stops, by reaching the end of the input or an error.
+// For a connection, Scan waits for more data until the read timeout, or the connection is closed.
+func (s *Scanner) Scan() bool {
+	if s.done {
+		return false
+	}
+	s.token = nil
+	var n = s.r.Len()
+	var atEOF bool
+	for {
+		// the token of the last scan and the data skipped are not used any more.
+		if err := s.r.Release(); err != nil {
+			return s.stop(err)
+		}
+		if n > 0 || atEOF {
+			// the split function sees the max bytes at most, like the buffer of bufio.Scanner.
+			var size = n
+			if size > s.max {
+				size = s.max
+			}
+			data, err := s.r.Peek(size)
+			if err != nil {
+				return s.stop(err)
+			}
+			advance, token, err := s.split(data, atEOF && size == n)
+			if err != nil {
+				if err == bufio.ErrFinalToken {
+					s.token, s.done = token, true
+					return token != nil
+				}
+				return s.stop(err)
+			}
+			if advance < 0 {
+				return s.stop(bufio.ErrNegativeAdvance)
+			}
+			if advance > size {
+				return s.stop(bufio.ErrAdvanceTooFar)
+			}
+			if err = s.r.Skip(advance); err != nil {
+				return s.stop(err)
+			}
+			n -= advance
+			if token != nil {
+				s.token = token
+				return true
+			}
+			if advance > 0 {
+				continue
+			}
+		}
+		if atEOF && n <= s.max {
+			s.done = true
+			return false
+		}
+		if n >= s.max {
+			return s.stop(bufio.ErrTooLong)
+		}
+		// wait for more data, and all the data is readable if r is a LinkBuffer.
+		if _, err := s.r.Peek(n + 1); err != nil {
+			if _, ok := s.r.(*LinkBuffer); !ok && !errors.Is(err, ErrEOF) && !errors.Is(err, ErrConnClosed) {
+				return s.stop(err)
+			}
+			atEOF = true
+		}
+		n = s.r.Len()
+	}
+}
+
+// Bytes returns the token of the last Scan, which may refer to the memory of the Reader.
+func (s *Scanner) Bytes() []byte {
+	return s.token
+}
+
+// Text returns the token of the last Scan as a string, which is copied.
+func (s *Scanner) Text() string {
+	return string(s.token)
+}
+
+// Err returns the first error that stopped the scan, or nil if the end of the input is reached.
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+func (s *Scanner) stop(err error) bool {
+	s.err, s.done = err, true
+	return false
+}
@@ -0,0 +1,103 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"bufio"
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func TestScanner(t *testing.T) {
+	// the lines cross the nodes, and the last one has no newline.
+	var lb = newSplitLinkBuffer([]byte("hello\nnetpoll\r\n\nworld"), 4)
+	var s = NewScanner(lb)
+	var lines []string
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	MustNil(t, s.Err())
+	Equal(t, len(lines), 4)
+	Equal(t, lines[0], "hello")
+	Equal(t, lines[1], "netpoll")
+	Equal(t, lines[2], "")
+	Equal(t, lines[3], "world")
+	Equal(t, lb.Len(), 0)
+	MustTrue(t, !s.Scan())
+
+	// the token in one node refers to the node.
+	lb = newSplitLinkBuffer([]byte("hello world  netpoll"), 64)
+	p, _ := lb.Peek(1)
+	s = NewScanner(lb)
+	s.Split(bufio.ScanWords)
+	MustTrue(t, s.Scan())
+	Equal(t, s.Text(), "hello")
+	Equal(t, &s.Bytes()[0], &p[0])
+	MustTrue(t, s.Scan())
+	Equal(t, s.Text(), "world")
+	MustTrue(t, s.Scan())
+	Equal(t, s.Text(), "netpoll")
+	MustTrue(t, !s.Scan())
+	MustNil(t, s.Err())
+
+	// the token is too long.
+	lb = newSplitLinkBuffer([]byte("hello world\n"), 4)
+	s = NewScanner(lb)
+	s.Buffer(8)
+	MustTrue(t, !s.Scan())
+	Equal(t, s.Err(), bufio.ErrTooLong)
+
+	// the split function stops the scan.
+	var stop = errors.New("stop")
+	lb = newSplitLinkBuffer([]byte("a,b,c"), 4)
+	s = NewScanner(lb)
+	s.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if data[0] == 'b' {
+			return 0, nil, stop
+		}
+		return 2, data[:1], nil
+	})
+	MustTrue(t, s.Scan())
+	Equal(t, s.Text(), "a")
+	MustTrue(t, !s.Scan())
+	Equal(t, s.Err(), stop)
+}
+
+func TestScannerConnection(t *testing.T) {
+	r, w := GetSysFdPairs()
+	var rconn = &connection{}
+	rconn.init(&netFD{fd: r}, &options{})
+	defer rconn.Close()
+
+	// Scan waits for the rest of the line, and stops when the peer closes.
+	var s = NewScanner(rconn)
+	go func() {
+		syscall.Write(w, []byte("hello\nnet"))
+		syscall.Write(w, []byte("poll\nworld"))
+		syscall.Close(w)
+	}()
+	MustTrue(t, s.Scan())
+	Equal(t, s.Text(), "hello")
+	MustTrue(t, s.Scan())
+	Equal(t, s.Text(), "netpoll")
+	MustTrue(t, s.Scan())
+	Equal(t, s.Text(), "world")
+	MustTrue(t, !s.Scan())
+	MustNil(t, s.Err())
+}
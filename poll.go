@@ -14,6 +14,8 @@
 
 package netpoll
 
+import "time"
+
 // Poll monitors fd(file descriptor), calls the FDOperator to perform specific actions,
 // and shields underlying differences. On linux systems, poll uses epoll by default,
 // and kevent by default on bsd systems.
@@ -42,6 +44,51 @@ type Poll interface {
 	Free(operator *FDOperator)
 }
 
+// detachWaiter is implemented by the polls whose PollDetach takes effect asynchronously,
+// e.g. the requests of io_uring still reference the fd until they are canceled.
+type detachWaiter interface {
+	// waitDetached blocks until the detached operator is removed from poll,
+	// it must not be called in the poller.
+	waitDetached(operator *FDOperator)
+}
+
+// busyPoller is implemented by the polls which support busy polling, see SetBusyPoll.
+type busyPoller interface {
+	setBusyPoll(timeout time.Duration)
+}
+
+// busyPoll is embedded in the polls to keep polling without blocking for a while after being idle.
+type busyPoll struct {
+	busyTimeout time.Duration // disabled if <= 0
+	busyStart   int64         // the time in ns when the poll becomes idle, 0 if not started
+}
+
+func (b *busyPoll) setBusyPoll(timeout time.Duration) {
+	b.busyTimeout = timeout
+}
+
+// busySpin reports whether the idle poll should keep polling without blocking.
+func (b *busyPoll) busySpin() bool {
+	if b.busyTimeout <= 0 {
+		return false
+	}
+	var now = time.Now().UnixNano()
+	if b.busyStart == 0 {
+		b.busyStart = now
+		return true
+	}
+	if now-b.busyStart < int64(b.busyTimeout) {
+		return true
+	}
+	b.busyStart = 0
+	return false
+}
+
+// busyReset restarts the timing of busySpin when events are polled.
+func (b *busyPoll) busyReset() {
+	b.busyStart = 0
+}
+
 // PollEvent defines the operation of poll.Control.
 type PollEvent int
 
@@ -67,4 +114,23 @@ const (
 
 	// PollRW2R is used to remove the writable monitor of FDOperator, generally used with PollR2RW.
 	PollRW2R PollEvent = 0x6
+
+	// PollPauseRead is used to remove the readable monitor of FDOperator,
+	// which is called when the input buffer of connection exceeds the high water mark.
+	PollPauseRead PollEvent = 0x7
+
+	// PollResumeRead is used to restore the readable monitor of FDOperator, generally used with PollPauseRead.
+	PollResumeRead PollEvent = 0x8
+)
+
+// PollType defines the implementation of poll.
+type PollType int
+
+const (
+	// EpollPoll uses epoll on linux and kqueue on bsd, which is the default.
+	EpollPoll PollType = iota
+
+	// IOUringPoll uses io_uring on linux 5.19+, and falls back to EpollPoll if it's not supported.
+	// Connections with MSG_ZEROCOPY enabled by WithZeroCopy send by copying under IOUringPoll.
+	IOUringPoll
 )
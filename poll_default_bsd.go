@@ -19,8 +19,10 @@
 package netpoll
 
 import (
+	"runtime"
 	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -48,6 +50,9 @@ func openDefaultPoll() *defaultPoll {
 }
 
 type defaultPoll struct {
+	busyPoll
+	pollLoad
+	pollRegistry
 	fd      int
 	trigger uint32
 	opcache *operatorCache // operator cache
@@ -64,8 +69,13 @@ func (p *defaultPoll) Wait() error {
 		barriers[i].ivs = make([]syscall.Iovec, caps)
 	}
 	// wait
+	var spin = &syscall.Timespec{}
 	for {
-		n, err := syscall.Kevent(p.fd, nil, events, nil)
+		var timeout *syscall.Timespec
+		if p.busySpin() {
+			timeout = spin
+		}
+		n, err := syscall.Kevent(p.fd, nil, events, timeout)
 		if err != nil && err != syscall.EINTR {
 			// exit gracefully
 			if err == syscall.EBADF {
@@ -73,6 +83,12 @@ func (p *defaultPoll) Wait() error {
 			}
 			return err
 		}
+		var start = time.Now()
+		if n > 0 {
+			p.busyReset()
+		} else if timeout != nil {
+			runtime.Gosched()
+		}
 		for i := 0; i < n; i++ {
 			// trigger
 			if events[i].Ident == 0 {
@@ -107,8 +123,12 @@ func (p *defaultPoll) Wait() error {
 
 			// check hup
 			if events[i].Flags&syscall.EV_EOF != 0 {
-				p.appendHup(operator)
-				continue
+				if events[i].Filter != syscall.EVFILT_READ || operator.OnRdHup == nil {
+					p.appendHup(operator)
+					continue
+				}
+				// the peer only shut down its writing, and the fd is still writable.
+				operator.OnRdHup(p)
 			}
 
 			// check poll out
@@ -120,8 +140,7 @@ func (p *defaultPoll) Wait() error {
 					// only for connection
 					var bs, supportZeroCopy = operator.Outputs(barriers[i].bs)
 					if len(bs) > 0 {
-						// TODO: Let the upper layer pass in whether to use ZeroCopy.
-						var n, err = sendmsg(operator.FD, bs, barriers[i].ivs, false && supportZeroCopy)
+						var n, err = sendmsg(operator.FD, bs, barriers[i].ivs, supportZeroCopy)
 						operator.OutputAck(n)
 						if err != nil && err != syscall.EAGAIN {
 							logger.Printf("NETPOLL: sendmsg(fd=%d) failed: %s", operator.FD, err.Error())
@@ -136,6 +155,10 @@ func (p *defaultPoll) Wait() error {
 		// hup conns together to avoid blocking the poll.
 		p.detaches()
 		p.opcache.free()
+		p.round()
+		if n > 0 {
+			p.addEvents(n, start)
+		}
 	}
 }
 
@@ -176,11 +199,39 @@ func (p *defaultPoll) Control(operator *FDOperator, event PollEvent) error {
 		evs[0].Filter, evs[0].Flags = syscall.EVFILT_WRITE, syscall.EV_ADD|syscall.EV_ENABLE
 	case PollRW2R:
 		evs[0].Filter, evs[0].Flags = syscall.EVFILT_WRITE, syscall.EV_DELETE|syscall.EV_ONESHOT
+	case PollPauseRead:
+		evs[0].Filter, evs[0].Flags = syscall.EVFILT_READ, syscall.EV_DISABLE
+	case PollResumeRead:
+		evs[0].Filter, evs[0].Flags = syscall.EVFILT_READ, syscall.EV_ENABLE
 	}
 	_, err := syscall.Kevent(p.fd, evs, nil, nil)
+	if err == nil {
+		switch event {
+		case PollReadable, PollWritable:
+			p.addConns(1)
+			p.register(operator)
+		case PollDetach:
+			p.addConns(-1)
+			p.deregister(operator)
+		}
+	}
 	return err
 }
 
+// attach implements migratablePoll.
+func (p *defaultPoll) attach(operator *FDOperator) error {
+	var evs = make([]syscall.Kevent_t, 1)
+	evs[0].Ident = uint64(operator.FD)
+	*(**FDOperator)(unsafe.Pointer(&evs[0].Udata)) = operator
+	evs[0].Filter, evs[0].Flags = syscall.EVFILT_READ, syscall.EV_ADD|syscall.EV_ENABLE
+	if _, err := syscall.Kevent(p.fd, evs, nil, nil); err != nil {
+		return err
+	}
+	p.addConns(1)
+	p.register(operator)
+	return nil
+}
+
 func (p *defaultPoll) Alloc() (operator *FDOperator) {
 	op := p.opcache.alloc()
 	op.poll = p
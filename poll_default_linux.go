@@ -21,6 +21,7 @@ import (
 	"runtime"
 	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -32,6 +33,7 @@ func openPoll() Poll {
 func openDefaultPoll() *defaultPoll {
 	var poll = defaultPoll{}
 	poll.buf = make([]byte, 8)
+	poll.oob = make([]byte, zeroCopyOOBSize)
 	var p, err = EpollCreate(0)
 	if err != nil {
 		panic(err)
@@ -54,9 +56,13 @@ func openDefaultPoll() *defaultPoll {
 
 type defaultPoll struct {
 	pollArgs
+	busyPoll
+	pollLoad
+	pollRegistry
 	fd      int            // epoll fd
 	wop     *FDOperator    // eventfd, wake epoll_wait
 	buf     []byte         // read wfd trigger msg
+	oob     []byte         // read error queue
 	trigger uint32         // trigger flag
 	opcache *operatorCache // operator cache
 	// fns for handle events
@@ -97,15 +103,22 @@ func (p *defaultPoll) Wait() (err error) {
 		}
 		if n <= 0 {
 			msec = -1
+			if p.busySpin() {
+				msec = 0
+			}
 			runtime.Gosched()
 			continue
 		}
 		msec = 0
+		p.busyReset()
+		var start = time.Now()
 		if p.Handler(p.events[:n]) {
 			return nil
 		}
 		// we can make sure that there is no op remaining if Handler finished
 		p.opcache.free()
+		p.round()
+		p.addEvents(n, start)
 	}
 }
 
@@ -155,19 +168,22 @@ func (p *defaultPoll) handler(events []epollevent) (closed bool) {
 		}
 
 		// check hup
-		if evt&(syscall.EPOLLHUP|syscall.EPOLLRDHUP) != 0 {
+		if evt&syscall.EPOLLHUP != 0 || (evt&syscall.EPOLLRDHUP != 0 && operator.OnRdHup == nil) {
 			p.appendHup(operator)
 			continue
 		}
+		if evt&syscall.EPOLLRDHUP != 0 {
+			// the peer only shut down its writing, and the fd is still writable.
+			operator.OnRdHup(p)
+		}
 		if evt&syscall.EPOLLERR != 0 {
-			// Under block-zerocopy, the kernel may give an error callback, which is not a real error, just an EAGAIN.
-			// So here we need to check this error, if it is EAGAIN then do nothing, otherwise still mark as hup.
-			if _, _, _, _, err := syscall.Recvmsg(operator.FD, nil, nil, syscall.MSG_ERRQUEUE); err != syscall.EAGAIN {
+			// Under zerocopy, the kernel notifies the completion of sends through the error queue,
+			// which is not a real error. So here we need to drain the error queue,
+			// and only mark as hup if there is a real error.
+			if !p.errqueue(operator) {
 				p.appendHup(operator)
-			} else {
-				operator.done()
+				continue
 			}
-			continue
 		}
 		// check poll out
 		if evt&syscall.EPOLLOUT != 0 {
@@ -178,14 +194,20 @@ func (p *defaultPoll) handler(events []epollevent) (closed bool) {
 				// for connection
 				var bs, supportZeroCopy = operator.Outputs(p.barriers[i].bs)
 				if len(bs) > 0 {
-					// TODO: Let the upper layer pass in whether to use ZeroCopy.
-					var n, err = sendmsg(operator.FD, bs, p.barriers[i].ivs, false && supportZeroCopy)
+					var n, err = sendmsg(operator.FD, bs, p.barriers[i].ivs, supportZeroCopy)
 					operator.OutputAck(n)
-					if err != nil && err != syscall.EAGAIN {
+					// ENOBUFS means that the zerocopy send exceeds the optmem limit, the connection will retry by copying.
+					if err != nil && err != syscall.EAGAIN && !(err == syscall.ENOBUFS && supportZeroCopy) {
 						logger.Printf("NETPOLL: sendmsg(fd=%d) failed: %s", operator.FD, err.Error())
 						p.appendHup(operator)
 						continue
 					}
+				} else if operator.OutputFile != nil {
+					if err := operator.OutputFile(); err != nil {
+						logger.Printf("NETPOLL: sendfile(fd=%d) failed: %s", operator.FD, err.Error())
+						p.appendHup(operator)
+						continue
+					}
 				}
 			} else {
 				logger.Printf("NETPOLL: operator has critical problem! event=%d operator=%v", evt, operator)
@@ -198,6 +220,24 @@ func (p *defaultPoll) handler(events []epollevent) (closed bool) {
 	return false
 }
 
+// errqueue drains the error queue of the operator, and returns false if there is a real error.
+func (p *defaultPoll) errqueue(operator *FDOperator) (ok bool) {
+	for {
+		lo, hi, err := recvZeroCopyAck(operator.FD, p.oob)
+		switch err {
+		case nil:
+			if operator.ZeroCopyAck != nil {
+				operator.ZeroCopyAck(lo, hi)
+			}
+		case syscall.EINTR:
+		case syscall.EAGAIN:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
 // Close will write 10000000
 func (p *defaultPoll) Close() error {
 	_, err := syscall.Write(p.wop.FD, []byte{1, 0, 0, 0, 0, 0, 0, 0})
@@ -231,11 +271,37 @@ func (p *defaultPoll) Control(operator *FDOperator, event PollEvent) error {
 	case PollDetach: // deregister
 		op, evt.events = syscall.EPOLL_CTL_DEL, syscall.EPOLLIN|syscall.EPOLLOUT|syscall.EPOLLRDHUP|syscall.EPOLLERR
 	case PollR2RW: // connection wait read/write
-		op, evt.events = syscall.EPOLL_CTL_MOD, syscall.EPOLLIN|syscall.EPOLLOUT|syscall.EPOLLRDHUP|syscall.EPOLLERR
+		op, evt.events = syscall.EPOLL_CTL_MOD, epollReadEvents(operator)|syscall.EPOLLOUT|syscall.EPOLLERR
 	case PollRW2R: // connection wait read
-		op, evt.events = syscall.EPOLL_CTL_MOD, syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLERR
+		op, evt.events = syscall.EPOLL_CTL_MOD, epollReadEvents(operator)|syscall.EPOLLERR
+	case PollPauseRead, PollResumeRead: // connection stop or restart reading
+		op, evt.events = syscall.EPOLL_CTL_MOD, epollReadEvents(operator)|epollWriteEvents(operator)|syscall.EPOLLERR
 	}
-	return EpollCtl(p.fd, op, operator.FD, &evt)
+	var err = EpollCtl(p.fd, op, operator.FD, &evt)
+	if err == nil && operator != p.wop {
+		switch op {
+		case syscall.EPOLL_CTL_ADD:
+			p.addConns(1)
+			p.register(operator)
+		case syscall.EPOLL_CTL_DEL:
+			p.addConns(-1)
+			p.deregister(operator)
+		}
+	}
+	return err
+}
+
+// attach implements migratablePoll.
+func (p *defaultPoll) attach(operator *FDOperator) error {
+	var evt epollevent
+	*(**FDOperator)(unsafe.Pointer(&evt.data)) = operator
+	evt.events = syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLERR
+	if err := EpollCtl(p.fd, syscall.EPOLL_CTL_ADD, operator.FD, &evt); err != nil {
+		return err
+	}
+	p.addConns(1)
+	p.register(operator)
+	return nil
 }
 
 func (p *defaultPoll) Alloc() (operator *FDOperator) {
@@ -270,3 +336,20 @@ func (p *defaultPoll) detaches() {
 		}
 	}(hups)
 }
+
+// epollReadEvents returns the events to monitor the readable of operator, which are removed if the reading is paused.
+// EPOLLRDHUP is removed as well, otherwise the connection would be closed before the rest data is read.
+func epollReadEvents(operator *FDOperator) uint32 {
+	if operator.isPaused() {
+		return 0
+	}
+	return syscall.EPOLLIN | syscall.EPOLLRDHUP
+}
+
+// epollWriteEvents returns the events to monitor the writable of operator, must be called with the operator locked.
+func epollWriteEvents(operator *FDOperator) uint32 {
+	if operator.event == PollR2RW {
+		return syscall.EPOLLOUT
+	}
+	return 0
+}
@@ -16,6 +16,7 @@ package netpoll
 
 import (
 	"sync/atomic"
+	"time"
 
 	"github.com/bytedance/gopkg/lang/fastrand"
 )
@@ -29,34 +30,87 @@ const (
 	// RoundRobin requests that connections are distributed to a Poll
 	// in a round-robin fashion.
 	RoundRobin
+	// LeastConnections requests that connections are distributed to the Poll
+	// with the fewest registered connections.
+	LeastConnections
+	// LeastEvents requests that connections are distributed to the Poll
+	// which handles the fewest events per second recently.
+	LeastEvents
+	// PowerOfTwoChoices requests that connections are distributed to the less loaded one
+	// of two randomly chosen polls, which compares the connections first and then the events.
+	PowerOfTwoChoices
+
+	// customLoadBalance is the LoadBalance of the LoadBalancer set by SetLoadBalancer.
+	customLoadBalance LoadBalance = -1
 )
 
-// loadbalance sets the load balancing method for []*polls
-type loadbalance interface {
-	LoadBalance() LoadBalance
-	// Choose the most qualified Poll
+// LoadBalancer distributes the connections between multiple polls, which can be customized by SetLoadBalancer.
+// The polls of netpoll implement PollLoad, which can be used to evaluate the load of each poll.
+type LoadBalancer interface {
+	// Pick chooses the most qualified Poll, it may be called concurrently.
 	Pick() (poll Poll)
 
+	// Rebalance is called with all the polls when the polls are changed.
 	Rebalance(polls []Poll)
 }
 
+// PollLoad reports the load of Poll.
+type PollLoad interface {
+	// Conns returns the number of fds registered in the poll.
+	Conns() int
+
+	// EventRate returns the number of events handled by the poll per second recently.
+	EventRate() float64
+
+	// Saturation returns the ratio of the time spent on handling events recently, which is in [0, 1].
+	Saturation() float64
+}
+
+// loadbalance sets the load balancing method for []*polls
+type loadbalance interface {
+	LoadBalancer
+	LoadBalance() LoadBalance
+}
+
 func newLoadbalance(lb LoadBalance, polls []Poll) loadbalance {
 	switch lb {
 	case Random:
 		return newRandomLB(polls)
 	case RoundRobin:
 		return newRoundRobinLB(polls)
+	case LeastConnections:
+		return newLeastLB(LeastConnections, pollConns, polls)
+	case LeastEvents:
+		return newLeastLB(LeastEvents, pollEventRate, polls)
+	case PowerOfTwoChoices:
+		return newP2CLB(polls)
 	}
 	return newRoundRobinLB(polls)
 }
 
+// lbPolls is embedded in the loadbalances to store the polls,
+// which can be rebalanced while picking, since the pollers may be scaled at runtime.
+type lbPolls struct {
+	polls atomic.Value // []Poll
+}
+
+func (b *lbPolls) Rebalance(polls []Poll) {
+	b.polls.Store(polls)
+}
+
+func (b *lbPolls) load() []Poll {
+	var polls, _ = b.polls.Load().([]Poll)
+	return polls
+}
+
 func newRandomLB(polls []Poll) loadbalance {
-	return &randomLB{polls: polls, pollSize: len(polls)}
+	var b = &randomLB{}
+	b.Rebalance(polls)
+	return b
 }
 
 type randomLB struct {
-	polls    []Poll
-	pollSize int
+	lbPolls
 }
 
 func (b *randomLB) LoadBalance() LoadBalance {
@@ -64,22 +118,20 @@ func (b *randomLB) LoadBalance() LoadBalance {
 }
 
 func (b *randomLB) Pick() (poll Poll) {
-	idx := fastrand.Intn(b.pollSize)
-	return b.polls[idx]
-}
-
-func (b *randomLB) Rebalance(polls []Poll) {
-	b.polls, b.pollSize = polls, len(polls)
+	var polls = b.load()
+	idx := fastrand.Intn(len(polls))
+	return polls[idx]
 }
 
 func newRoundRobinLB(polls []Poll) loadbalance {
-	return &roundRobinLB{polls: polls, pollSize: len(polls)}
+	var b = &roundRobinLB{}
+	b.Rebalance(polls)
+	return b
 }
 
 type roundRobinLB struct {
-	polls    []Poll
+	lbPolls
 	accepted uintptr // accept counter
-	pollSize int
 }
 
 func (b *roundRobinLB) LoadBalance() LoadBalance {
@@ -87,10 +139,158 @@ func (b *roundRobinLB) LoadBalance() LoadBalance {
 }
 
 func (b *roundRobinLB) Pick() (poll Poll) {
-	idx := int(atomic.AddUintptr(&b.accepted, 1)) % b.pollSize
-	return b.polls[idx]
+	var polls = b.load()
+	idx := int(atomic.AddUintptr(&b.accepted, 1)) % len(polls)
+	return polls[idx]
+}
+
+func newLeastLB(lb LoadBalance, load func(p Poll) float64, polls []Poll) loadbalance {
+	var b = &leastLB{lb: lb, loadOf: load}
+	b.Rebalance(polls)
+	return b
+}
+
+// leastLB picks the poll with the least load, and the ties are broken in a round-robin fashion.
+type leastLB struct {
+	lbPolls
+	lb       LoadBalance
+	loadOf   func(p Poll) float64
+	accepted uintptr // accept counter
+}
+
+func (b *leastLB) LoadBalance() LoadBalance {
+	return b.lb
+}
+
+func (b *leastLB) Pick() (poll Poll) {
+	var polls = b.load()
+	var start = int(atomic.AddUintptr(&b.accepted, 1)) % len(polls)
+	var min float64
+	for i := 0; i < len(polls); i++ {
+		var p = polls[(start+i)%len(polls)]
+		if load := b.loadOf(p); poll == nil || load < min {
+			poll, min = p, load
+		}
+	}
+	return poll
+}
+
+func newP2CLB(polls []Poll) loadbalance {
+	var b = &p2cLB{}
+	b.Rebalance(polls)
+	return b
+}
+
+type p2cLB struct {
+	lbPolls
 }
 
-func (b *roundRobinLB) Rebalance(polls []Poll) {
-	b.polls, b.pollSize = polls, len(polls)
+func (b *p2cLB) LoadBalance() LoadBalance {
+	return PowerOfTwoChoices
+}
+
+func (b *p2cLB) Pick() (poll Poll) {
+	var polls = b.load()
+	if len(polls) == 1 {
+		return polls[0]
+	}
+	var i, j = fastrand.Intn(len(polls)), fastrand.Intn(len(polls) - 1)
+	if j >= i {
+		j++
+	}
+	var p1, p2 = polls[i], polls[j]
+	var c1, c2 = pollConns(p1), pollConns(p2)
+	if c1 < c2 || c1 == c2 && pollEventRate(p1) <= pollEventRate(p2) {
+		return p1
+	}
+	return p2
+}
+
+// customLB wraps the LoadBalancer set by SetLoadBalancer.
+type customLB struct {
+	LoadBalancer
+}
+
+func (b *customLB) LoadBalance() LoadBalance {
+	return customLoadBalance
+}
+
+// pollConns returns the connections of poll, or 0 if it doesn't implement PollLoad.
+func pollConns(p Poll) float64 {
+	if l, ok := p.(PollLoad); ok {
+		return float64(l.Conns())
+	}
+	return 0
+}
+
+// pollEventRate returns the event rate of poll, or 0 if it doesn't implement PollLoad.
+func pollEventRate(p Poll) float64 {
+	if l, ok := p.(PollLoad); ok {
+		return l.EventRate()
+	}
+	return 0
+}
+
+const (
+	loadBuckets    = 10                     // the number of buckets in the sliding window
+	loadBucketTime = 100 * time.Millisecond // the time span of each bucket
+)
+
+// pollLoad is embedded in the polls to implement PollLoad.
+// The events are counted in a sliding window of buckets, which is only written by the poller.
+type pollLoad struct {
+	conns   int64
+	buckets [loadBuckets]struct {
+		id     int64 // the time in loadBucketTime when the bucket is used
+		events int64
+		busy   int64 // the time in ns spent on handling events
+	}
+}
+
+// Conns implements PollLoad.
+func (l *pollLoad) Conns() int {
+	return int(atomic.LoadInt64(&l.conns))
+}
+
+// EventRate implements PollLoad.
+func (l *pollLoad) EventRate() float64 {
+	var events, _ = l.window()
+	return float64(events) / (loadBuckets * loadBucketTime).Seconds()
+}
+
+// Saturation implements PollLoad.
+func (l *pollLoad) Saturation() float64 {
+	var _, busy = l.window()
+	return float64(busy) / float64(loadBuckets*loadBucketTime)
+}
+
+// window sums the buckets in the sliding window.
+func (l *pollLoad) window() (events, busy int64) {
+	var id = time.Now().UnixNano() / int64(loadBucketTime)
+	for i := range l.buckets {
+		var b = &l.buckets[i]
+		if id-atomic.LoadInt64(&b.id) < loadBuckets {
+			events += atomic.LoadInt64(&b.events)
+			busy += atomic.LoadInt64(&b.busy)
+		}
+	}
+	return events, busy
+}
+
+func (l *pollLoad) addConns(delta int64) {
+	atomic.AddInt64(&l.conns, delta)
+}
+
+// addEvents counts the events handled by the poller since start, it must be called only by the poller.
+func (l *pollLoad) addEvents(n int, start time.Time) {
+	var now = time.Now()
+	var id = now.UnixNano() / int64(loadBucketTime)
+	var b = &l.buckets[id%loadBuckets]
+	if atomic.LoadInt64(&b.id) != id {
+		atomic.StoreInt64(&b.events, 0)
+		atomic.StoreInt64(&b.busy, 0)
+		atomic.StoreInt64(&b.id, id)
+	}
+	atomic.AddInt64(&b.events, int64(n))
+	atomic.AddInt64(&b.busy, int64(now.Sub(start)))
 }
@@ -0,0 +1,154 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+// mockLoadPoll is a Poll with the given load.
+type mockLoadPoll struct {
+	Poll
+	conns int
+	rate  float64
+}
+
+func (p *mockLoadPoll) Conns() int {
+	return p.conns
+}
+
+func (p *mockLoadPoll) EventRate() float64 {
+	return p.rate
+}
+
+func (p *mockLoadPoll) Saturation() float64 {
+	return 0
+}
+
+func TestLoadBalanceLeast(t *testing.T) {
+	var p0, p1, p2 = &mockLoadPoll{conns: 2, rate: 10}, &mockLoadPoll{conns: 1, rate: 30}, &mockLoadPoll{conns: 3, rate: 20}
+	var polls = []Poll{p0, p1, p2}
+
+	var lb = newLoadbalance(LeastConnections, polls)
+	Equal(t, lb.LoadBalance(), LeastConnections)
+	for i := 0; i < 10; i++ {
+		MustTrue(t, lb.Pick() == p1)
+	}
+	lb = newLoadbalance(LeastEvents, polls)
+	Equal(t, lb.LoadBalance(), LeastEvents)
+	for i := 0; i < 10; i++ {
+		MustTrue(t, lb.Pick() == p0)
+	}
+
+	// the ties are broken in turn
+	p0.conns, p1.conns, p2.conns = 1, 1, 1
+	lb = newLoadbalance(LeastConnections, polls)
+	var picked = map[Poll]int{}
+	for i := 0; i < 30; i++ {
+		picked[lb.Pick()]++
+	}
+	Equal(t, len(picked), 3)
+}
+
+func TestLoadBalancePowerOfTwoChoices(t *testing.T) {
+	var p0, p1, p2 = &mockLoadPoll{conns: 1}, &mockLoadPoll{conns: 2}, &mockLoadPoll{conns: 3}
+	var lb = newLoadbalance(PowerOfTwoChoices, []Poll{p0, p1, p2})
+	Equal(t, lb.LoadBalance(), PowerOfTwoChoices)
+	var picked = map[Poll]int{}
+	for i := 0; i < 100; i++ {
+		picked[lb.Pick()]++
+	}
+	// the most loaded poll is never picked
+	Equal(t, picked[p2], 0)
+	MustTrue(t, picked[p0] > picked[p1])
+
+	lb.Rebalance([]Poll{p2})
+	MustTrue(t, lb.Pick() == p2)
+}
+
+type mockLoadBalancer struct {
+	polls []Poll
+}
+
+func (b *mockLoadBalancer) Pick() (poll Poll) {
+	return b.polls[len(b.polls)-1]
+}
+
+func (b *mockLoadBalancer) Rebalance(polls []Poll) {
+	b.polls = polls
+}
+
+func TestLoadBalancer(t *testing.T) {
+	var m = &manager{}
+	MustNil(t, m.SetLoadBalance(RoundRobin))
+	MustNil(t, m.SetNumLoops(2))
+	defer m.Close()
+
+	MustTrue(t, m.SetLoadBalancer(nil) != nil)
+	MustNil(t, m.SetLoadBalancer(&mockLoadBalancer{}))
+	Equal(t, m.balance.LoadBalance(), customLoadBalance)
+	MustTrue(t, m.Pick() == m.polls[1])
+
+	MustNil(t, m.SetNumLoops(3))
+	MustTrue(t, m.Pick() == m.polls[2])
+
+	MustNil(t, m.SetLoadBalance(LeastConnections))
+	Equal(t, m.balance.LoadBalance(), LeastConnections)
+}
+
+func TestPollLoad(t *testing.T) {
+	var m = &manager{}
+	MustNil(t, m.SetLoadBalance(LeastConnections))
+	MustNil(t, m.SetNumLoops(2))
+	defer m.Close()
+	for _, p := range m.polls {
+		Equal(t, p.(PollLoad).Conns(), 0)
+	}
+
+	var operators []*FDOperator
+	for i := 0; i < 4; i++ {
+		r, w := GetSysFdPairs()
+		defer syscall.Close(r)
+		defer syscall.Close(w)
+		var operator = &FDOperator{FD: r, OnRead: func(p Poll) error { return nil }}
+		operator.poll = m.Pick()
+		MustNil(t, operator.Control(PollReadable))
+		operators = append(operators, operator)
+	}
+	for _, p := range m.polls {
+		Equal(t, p.(PollLoad).Conns(), 2)
+	}
+	for _, operator := range operators {
+		MustNil(t, operator.Control(PollDetach))
+	}
+	for _, p := range m.polls {
+		Equal(t, p.(PollLoad).Conns(), 0)
+	}
+
+	var load pollLoad
+	load.addEvents(10, time.Now().Add(-time.Millisecond))
+	MustTrue(t, load.Saturation() > 0)
+	MustTrue(t, load.EventRate() > 0)
+	// the events out of the window are not counted
+	for i := range load.buckets {
+		load.buckets[i].id = time.Now().UnixNano()/int64(loadBucketTime) - loadBuckets
+	}
+	Equal(t, load.EventRate(), float64(0))
+}
@@ -23,6 +23,10 @@ import (
 	"log"
 	"os"
 	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 func setNumLoops(numLoops int) error {
@@ -33,6 +37,34 @@ func setLoadBalance(lb LoadBalance) error {
 	return pollmanager.SetLoadBalance(lb)
 }
 
+func setLoadBalancer(lb LoadBalancer) error {
+	return pollmanager.SetLoadBalancer(lb)
+}
+
+func setPollType(t PollType) error {
+	return pollmanager.SetPollType(t)
+}
+
+func setPollerLockOSThread(lock bool) error {
+	return pollmanager.SetLockOSThread(lock)
+}
+
+func setPollerAffinity(cpus []int) error {
+	return pollmanager.SetAffinity(cpus)
+}
+
+func setBusyPoll(timeout time.Duration) error {
+	return pollmanager.SetBusyPoll(timeout)
+}
+
+func setAutoScale(min, max int) error {
+	return pollmanager.SetAutoScale(min, max)
+}
+
+func rebalance() error {
+	return pollmanager.Rebalance()
+}
+
 func setLoggerOutput(w io.Writer) {
 	logger = log.New(w, "", log.LstdFlags)
 }
@@ -50,12 +82,29 @@ func init() {
 	setLoggerOutput(os.Stderr)
 }
 
+const (
+	retireInterval      = 10 * time.Millisecond // the interval to retry moving the operators of retired poller
+	autoScaleInterval   = time.Second           // the interval to check the saturation of pollers
+	scaleUpSaturation   = 0.8                   // add a poller if the average saturation is higher
+	scaleDownSaturation = 0.5                   // remove a poller if the saturation is still lower after removed
+	rebalanceThreshold  = 0.2                   // rebalance if the event rate differs more than the ratio
+)
+
 // LoadBalance is used to do load balancing among multiple pollers.
 // a single poller may not be optimal if the number of cores is large (40C+).
 type manager struct {
+	mu       sync.Mutex // protects the pollers from being changed concurrently
 	NumLoops int
+	pollType PollType
 	balance  loadbalance // load balancing method
 	polls    []Poll      // all the polls
+
+	lockOSThread bool          // run each poller on a locked OS thread
+	cpus         []int         // the cpus bound by pollers
+	busyPoll     time.Duration // the time that pollers spin before blocking
+
+	scaleMin, scaleMax int           // the range of NumLoops when auto-scaling
+	scaleStop          chan struct{} // stops auto-scaling, nil if disabled
 }
 
 // SetNumLoops will return error when set numLoops < 1
@@ -63,31 +112,23 @@ func (m *manager) SetNumLoops(numLoops int) error {
 	if numLoops < 1 {
 		return fmt.Errorf("set invalid numLoops[%d]", numLoops)
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
 	if numLoops < m.NumLoops {
-		// if less than, close the redundant pollers
-		var polls = make([]Poll, numLoops)
-		for idx := 0; idx < m.NumLoops; idx++ {
-			if idx < numLoops {
-				polls[idx] = m.polls[idx]
-			} else {
-				if err := m.polls[idx].Close(); err != nil {
-					logger.Printf("NETPOLL: poller close failed: %v\n", err)
-				}
-			}
-		}
-		m.NumLoops = numLoops
-		m.polls = polls
-		m.balance.Rebalance(m.polls)
+		// if less than, retire the redundant pollers after moving their connections
+		m.shrink(numLoops)
 		return nil
 	}
 
 	m.NumLoops = numLoops
-	return m.Run()
+	return m.run()
 }
 
 // SetLoadBalance set load balance.
 func (m *manager) SetLoadBalance(lb LoadBalance) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.balance != nil && m.balance.LoadBalance() == lb {
 		return nil
 	}
@@ -95,8 +136,102 @@ func (m *manager) SetLoadBalance(lb LoadBalance) error {
 	return nil
 }
 
+// SetLoadBalancer set the custom load balancer.
+func (m *manager) SetLoadBalancer(lb LoadBalancer) error {
+	if lb == nil {
+		return fmt.Errorf("set invalid loadBalancer[nil]")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lb.Rebalance(m.polls)
+	m.balance = &customLB{lb}
+	return nil
+}
+
+// SetPollType set the implementation of pollers, and reset the pollers if it's changed.
+func (m *manager) SetPollType(t PollType) error {
+	if t != EpollPoll && t != IOUringPoll {
+		return fmt.Errorf("set invalid pollType[%d]", t)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.pollType == t {
+		return nil
+	}
+	m.pollType = t
+	return m.reset()
+}
+
+// SetLockOSThread set whether pollers run on locked OS threads, and reset the pollers if it's changed.
+func (m *manager) SetLockOSThread(lock bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.lockOSThread == lock {
+		return nil
+	}
+	m.lockOSThread = lock
+	return m.reset()
+}
+
+// SetAffinity set the cpus bound by pollers, and reset the pollers.
+func (m *manager) SetAffinity(cpus []int) error {
+	for _, cpu := range cpus {
+		if err := checkAffinity(cpu); err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cpus = append([]int(nil), cpus...)
+	return m.reset()
+}
+
+// SetBusyPoll set the time that pollers spin before blocking, and reset the pollers if it's changed.
+func (m *manager) SetBusyPoll(timeout time.Duration) error {
+	if timeout < 0 {
+		return fmt.Errorf("set invalid busyPoll[%s]", timeout)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.busyPoll == timeout {
+		return nil
+	}
+	m.busyPoll = timeout
+	return m.reset()
+}
+
+// SetAutoScale scales the number of pollers in [min, max] by their saturation, and max <= 0 disables it.
+func (m *manager) SetAutoScale(min, max int) error {
+	if max > 0 && (min < 1 || min > max) {
+		return fmt.Errorf("set invalid autoScale[%d, %d]", min, max)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scaleMin, m.scaleMax = min, max
+	if max <= 0 {
+		m.stopAutoScale()
+		return nil
+	}
+	if m.scaleStop == nil {
+		m.scaleStop = make(chan struct{})
+		go m.autoScale(m.scaleStop)
+	}
+	return nil
+}
+
+// Rebalance moves the hot connections from the most loaded poller to the least loaded one.
+func (m *manager) Rebalance() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rebalance()
+	return nil
+}
+
 // Close release all resources.
 func (m *manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopAutoScale()
 	for _, poll := range m.polls {
 		poll.Close()
 	}
@@ -108,11 +243,20 @@ func (m *manager) Close() error {
 
 // Run all pollers.
 func (m *manager) Run() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.run()
+}
+
+func (m *manager) run() error {
 	// new poll to fill delta.
 	for idx := len(m.polls); idx < m.NumLoops; idx++ {
-		var poll = openPoll()
+		var poll = openPollType(m.pollType)
+		if bp, ok := poll.(busyPoller); ok {
+			bp.setBusyPoll(m.busyPoll)
+		}
 		m.polls = append(m.polls, poll)
-		go poll.Wait()
+		go runPoll(poll, m.lockOSThread, m.cpus)
 	}
 	// LoadBalance must be set before calling Run, otherwise it will panic.
 	m.balance.Rebalance(m.polls)
@@ -121,14 +265,183 @@ func (m *manager) Run() error {
 
 // Reset pollers, this operation is very dangerous, please make sure to do this when calling !
 func (m *manager) Reset() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reset()
+}
+
+func (m *manager) reset() error {
 	for _, poll := range m.polls {
 		poll.Close()
 	}
 	m.polls = nil
-	return m.Run()
+	return m.run()
 }
 
 // Pick will select the poller for use each time based on the LoadBalance.
 func (m *manager) Pick() Poll {
 	return m.balance.Pick()
 }
+
+// shrink removes the redundant pollers from load balancing, and retires them.
+func (m *manager) shrink(numLoops int) {
+	var retired = m.polls[numLoops:]
+	// copy, since the retired pollers will be overwritten by appending.
+	m.polls = append([]Poll(nil), m.polls[:numLoops]...)
+	m.NumLoops = numLoops
+	m.balance.Rebalance(m.polls)
+	for _, poll := range retired {
+		if !m.retire(poll) {
+			go m.retiring(poll)
+		}
+	}
+}
+
+// retire moves the operators of poll to the other pollers, and closes the poll if it's empty.
+// It returns false if some operators can't be moved now, e.g. they are waiting to write,
+// or the poll doesn't support migration, in which case it waits for the connections to be closed.
+func (m *manager) retire(poll Poll) (closed bool) {
+	if mp, ok := poll.(migratablePoll); ok && m.balance != nil {
+		for _, operator := range mp.registered() {
+			migrate(operator, m.Pick())
+		}
+	}
+	// the manager has been closed, or the poll is empty.
+	if m.balance == nil || pollConns(poll) == 0 {
+		if err := poll.Close(); err != nil {
+			logger.Printf("NETPOLL: poller close failed: %v", err)
+		}
+		return true
+	}
+	return false
+}
+
+// retiring retries retire until the poll is closed.
+func (m *manager) retiring(poll Poll) {
+	for closed := false; !closed; {
+		time.Sleep(retireInterval)
+		m.mu.Lock()
+		closed = m.retire(poll)
+		m.mu.Unlock()
+	}
+}
+
+// rebalance moves the hot operators from the poller with the highest event rate to the lowest one,
+// until about half of the difference is moved. The hotness of operators is counted since the last rebalance.
+func (m *manager) rebalance() (moved int) {
+	if len(m.polls) < 2 {
+		return 0
+	}
+	var hot, cold = m.polls[0], m.polls[0]
+	var max, min = pollEventRate(hot), pollEventRate(cold)
+	for _, poll := range m.polls[1:] {
+		var rate = pollEventRate(poll)
+		if rate > max {
+			hot, max = poll, rate
+		}
+		if rate < min {
+			cold, min = poll, rate
+		}
+	}
+	var mp, ok = hot.(migratablePoll)
+	if !ok || max-min <= max*rebalanceThreshold {
+		return 0
+	}
+	type hotOperator struct {
+		operator *FDOperator
+		events   uint64
+	}
+	var operators []hotOperator
+	var total uint64
+	for _, operator := range mp.registered() {
+		var events = atomic.SwapUint64(&operator.events, 0)
+		operators = append(operators, hotOperator{operator, events})
+		total += events
+	}
+	sort.Slice(operators, func(i, j int) bool {
+		return operators[i].events > operators[j].events
+	})
+	var quota = uint64(float64(total) * (max - min) / (2 * max))
+	for _, h := range operators {
+		if h.events == 0 || h.events > quota {
+			continue
+		}
+		if migrate(h.operator, cold) {
+			quota -= h.events
+			moved++
+		}
+	}
+	return moved
+}
+
+// autoScale checks the saturation of pollers periodically until stopped.
+func (m *manager) autoScale(stop chan struct{}) {
+	var ticker = time.NewTicker(autoScaleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.mu.Lock()
+			m.scale()
+			m.mu.Unlock()
+		}
+	}
+}
+
+// scale adds a poller if the pollers are saturated, or removes one if they are idle.
+func (m *manager) scale() {
+	if m.balance == nil || m.NumLoops == 0 {
+		return
+	}
+	var saturation float64
+	for _, poll := range m.polls {
+		if l, ok := poll.(PollLoad); ok {
+			saturation += l.Saturation()
+		}
+	}
+	var n = float64(m.NumLoops)
+	switch {
+	case m.NumLoops < m.scaleMax && (m.NumLoops < m.scaleMin || saturation/n > scaleUpSaturation):
+		m.NumLoops++
+		m.run()
+		// share the load with the new poller.
+		m.rebalance()
+	case m.NumLoops > m.scaleMin && (m.NumLoops > m.scaleMax || saturation/(n-1) < scaleDownSaturation):
+		m.shrink(m.NumLoops - 1)
+	}
+}
+
+func (m *manager) stopAutoScale() {
+	if m.scaleStop != nil {
+		close(m.scaleStop)
+		m.scaleStop = nil
+	}
+}
+
+// openPollType opens the poll of PollType, and falls back to the default poll if it's not supported.
+func openPollType(t PollType) Poll {
+	if t == IOUringPoll {
+		var poll, err = openURingPoll()
+		if err == nil {
+			return poll
+		}
+		logger.Printf("NETPOLL: io_uring is not supported, fall back to epoll: %v", err)
+	}
+	return openPoll()
+}
+
+// runPoll runs the poll, on a locked OS thread if lock, and binds the thread to the cpus if any.
+func runPoll(poll Poll, lock bool, cpus []int) {
+	if lock || len(cpus) > 0 {
+		// never unlock, so that the thread exits with the poll, and its affinity won't be inherited.
+		runtime.LockOSThread()
+	}
+	if len(cpus) > 0 {
+		if err := setAffinity(cpus); err != nil {
+			logger.Printf("NETPOLL: poller bind cpus%v failed: %v", cpus, err)
+		}
+	}
+	poll.Wait()
+}
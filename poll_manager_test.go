@@ -51,3 +51,41 @@ func TestPollManagerReset(t *testing.T) {
 	Equal(t, len(pollmanager.polls), n)
 	Equal(t, pollmanager.NumLoops, n)
 }
+
+func TestPollManagerPollerOptions(t *testing.T) {
+	var old = pollmanager
+	pollmanager = &manager{}
+	pollmanager.SetLoadBalance(RoundRobin)
+	defer func() {
+		pollmanager.Close()
+		pollmanager = old
+	}()
+	MustNil(t, pollmanager.SetNumLoops(2))
+	MustTrue(t, pollmanager.SetBusyPoll(-1) != nil)
+	MustTrue(t, pollmanager.SetAffinity([]int{-1}) != nil)
+	MustNil(t, pollmanager.SetBusyPoll(10*time.Millisecond))
+	MustNil(t, pollmanager.SetLockOSThread(true))
+	if checkAffinity(0) == nil {
+		MustNil(t, pollmanager.SetAffinity([]int{0}))
+	}
+	Equal(t, len(pollmanager.polls), 2)
+
+	r, w := GetSysFdPairs()
+	var rconn, wconn = &connection{}, &connection{}
+	rconn.init(&netFD{fd: r}, nil)
+	wconn.init(&netFD{fd: w}, nil)
+	defer rconn.Close()
+	defer wconn.Close()
+
+	var msg = []byte("hello world")
+	for i := 0; i < 10; i++ {
+		n, err := wconn.Write(msg)
+		MustNil(t, err)
+		Equal(t, n, len(msg))
+		p, err := rconn.Reader().Next(n)
+		MustNil(t, err)
+		Equal(t, string(p), string(msg))
+		// wait for the pollers to block after spinning.
+		time.Sleep(time.Duration(i) * 2 * time.Millisecond)
+	}
+}
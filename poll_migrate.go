@@ -0,0 +1,146 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// migratablePoll is implemented by the polls whose registered operators can be moved to another poll.
+type migratablePoll interface {
+	Poll
+
+	// registered returns the operators registered in the poll.
+	registered() []*FDOperator
+
+	// attach registers the operator moved from another poll to wait read, without changing its state.
+	attach(operator *FDOperator) error
+
+	// epoch returns the number of rounds handled by the poller.
+	epoch() uint64
+}
+
+// pollRegistry is embedded in the migratable polls to track the registered operators and the rounds.
+type pollRegistry struct {
+	mu        sync.Mutex
+	operators map[*FDOperator]struct{}
+	rounds    uint64
+}
+
+func (r *pollRegistry) register(operator *FDOperator) {
+	r.mu.Lock()
+	if r.operators == nil {
+		r.operators = make(map[*FDOperator]struct{})
+	}
+	r.operators[operator] = struct{}{}
+	r.mu.Unlock()
+}
+
+func (r *pollRegistry) deregister(operator *FDOperator) {
+	r.mu.Lock()
+	delete(r.operators, operator)
+	r.mu.Unlock()
+}
+
+func (r *pollRegistry) registered() []*FDOperator {
+	r.mu.Lock()
+	var operators = make([]*FDOperator, 0, len(r.operators))
+	for operator := range r.operators {
+		operators = append(operators, operator)
+	}
+	r.mu.Unlock()
+	return operators
+}
+
+func (r *pollRegistry) epoch() uint64 {
+	return atomic.LoadUint64(&r.rounds)
+}
+
+// round is called by the poller after handling each round of events.
+func (r *pollRegistry) round() {
+	atomic.AddUint64(&r.rounds, 1)
+}
+
+// the waits of migrate back off from migrateBackoff to migrateMaxBackoff, and give up after migrateTimeout.
+const (
+	migrateBackoff    = 10 * time.Microsecond
+	migrateMaxBackoff = time.Millisecond
+	migrateTimeout    = 100 * time.Millisecond
+)
+
+// waitUntil calls cond with backoff until it returns true or migrateTimeout elapses, and returns the last result.
+func waitUntil(cond func() bool) bool {
+	var deadline = time.Now().Add(migrateTimeout)
+	for backoff := migrateBackoff; !cond(); backoff <<= 1 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		if backoff > migrateMaxBackoff {
+			backoff = migrateMaxBackoff
+		}
+		time.Sleep(backoff)
+	}
+	return true
+}
+
+// migrate moves the operator to the poll to, and returns false if the operator can't be moved now,
+// e.g. it is detached, waiting to write or paused reading, which can be retried later.
+func migrate(operator *FDOperator, to Poll) (ok bool) {
+	// hold the operator, so that neither of the pollers handles it during the migration.
+	var held bool
+	if !waitUntil(func() bool {
+		held = operator.do()
+		return held || operator.isUnused()
+	}) || !held {
+		return false
+	}
+	operator.lock()
+	var from, _ = operator.poll.(migratablePoll)
+	var dest, _ = to.(migratablePoll)
+	switch operator.event {
+	case PollReadable, PollModReadable, PollRW2R:
+		ok = from != nil && dest != nil && from != dest && !operator.isPaused()
+	}
+	if ok && from.Control(operator, PollDetach) != nil {
+		ok = false
+	}
+	if ok {
+		if err := dest.attach(operator); err != nil {
+			logger.Printf("NETPOLL: migrate operator failed: %v", err)
+			if err = from.attach(operator); err != nil {
+				logger.Printf("NETPOLL: migrate operator rollback failed: %v", err)
+			}
+			ok = false
+		} else {
+			operator.poll = to
+		}
+	}
+	operator.unlock()
+	if ok {
+		// the poller of from may be handling the events of operator received before detached,
+		// so the operator can't be handled by the new poller until the round finishes,
+		// unless the poller doesn't finish it in time, e.g. it's closed.
+		var epoch = from.epoch()
+		from.Trigger()
+		waitUntil(func() bool { return from.epoch() != epoch })
+	}
+	operator.done()
+	return ok
+}
@@ -0,0 +1,167 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package netpoll
+
+import (
+	"testing"
+	"time"
+)
+
+// useManager replaces the global pollmanager with m until restore.
+func useManager(m *manager) (restore func()) {
+	var old = pollmanager
+	pollmanager = m
+	return func() {
+		m.Close()
+		pollmanager = old
+	}
+}
+
+// newConnPairs creates n pairs of connected connections.
+func newConnPairs(t *testing.T, n int) (rconns, wconns []*connection) {
+	for i := 0; i < n; i++ {
+		r, w := GetSysFdPairs()
+		var rconn, wconn = &connection{}, &connection{}
+		MustNil(t, rconn.init(&netFD{fd: r}, nil))
+		MustNil(t, wconn.init(&netFD{fd: w}, nil))
+		rconns, wconns = append(rconns, rconn), append(wconns, wconn)
+	}
+	return rconns, wconns
+}
+
+func pingConnPairs(t *testing.T, rconns, wconns []*connection) {
+	var msg = []byte("hello world")
+	for i := range rconns {
+		_, err := wconns[i].Write(msg)
+		MustNil(t, err)
+		p, err := rconns[i].Reader().Next(len(msg))
+		MustNil(t, err)
+		Equal(t, string(p), string(msg))
+		rconns[i].Reader().Release()
+	}
+}
+
+func TestPollManagerShrink(t *testing.T) {
+	var m = &manager{}
+	m.SetLoadBalance(RoundRobin)
+	MustNil(t, m.SetNumLoops(3))
+	defer useManager(m)()
+
+	var rconns, wconns = newConnPairs(t, 6)
+	pingConnPairs(t, rconns, wconns)
+	var retired = m.polls[1:]
+	MustNil(t, m.SetNumLoops(1))
+	Equal(t, len(m.polls), 1)
+	Equal(t, pollConns(m.polls[0]), float64(12))
+	for _, poll := range retired {
+		Equal(t, pollConns(poll), float64(0))
+	}
+	for i := range rconns {
+		MustTrue(t, rconns[i].operator.poll == m.polls[0])
+		MustTrue(t, wconns[i].operator.poll == m.polls[0])
+	}
+	pingConnPairs(t, rconns, wconns)
+
+	// grow again, and the connections still work.
+	MustNil(t, m.SetNumLoops(2))
+	pingConnPairs(t, rconns, wconns)
+	for i := range rconns {
+		MustNil(t, wconns[i].Close())
+	}
+	time.Sleep(10 * time.Millisecond)
+	for i := range rconns {
+		MustTrue(t, !rconns[i].IsActive())
+	}
+	Equal(t, pollConns(m.polls[0]), float64(0))
+}
+
+// firstLB always picks the first poll.
+type firstLB struct {
+	polls []Poll
+}
+
+func (b *firstLB) Pick() Poll {
+	return b.polls[0]
+}
+
+func (b *firstLB) Rebalance(polls []Poll) {
+	b.polls = polls
+}
+
+func TestPollManagerRebalance(t *testing.T) {
+	var m = &manager{}
+	m.SetLoadBalancer(&firstLB{})
+	MustNil(t, m.SetNumLoops(2))
+	defer useManager(m)()
+
+	var rconns, wconns = newConnPairs(t, 8)
+	// make rconns[0] the hottest.
+	for i := 0; i < 100; i++ {
+		pingConnPairs(t, rconns[:1], wconns[:1])
+	}
+	pingConnPairs(t, rconns, wconns)
+	Equal(t, pollConns(m.polls[1]), float64(0))
+
+	MustNil(t, m.Rebalance())
+	var moved int
+	for i := range rconns {
+		if rconns[i].operator.poll == m.polls[1] {
+			moved++
+		}
+	}
+	MustTrue(t, moved > 0)
+	// the hottest one can't be moved, or the pollers are still unbalanced.
+	MustTrue(t, rconns[0].operator.poll == m.polls[0])
+	pingConnPairs(t, rconns, wconns)
+	for i := range rconns {
+		MustNil(t, rconns[i].Close())
+		MustNil(t, wconns[i].Close())
+	}
+}
+
+func TestPollManagerAutoScale(t *testing.T) {
+	var m = &manager{}
+	m.SetLoadBalance(RoundRobin)
+	MustNil(t, m.SetNumLoops(1))
+	defer useManager(m)()
+
+	MustTrue(t, m.SetAutoScale(0, 2) != nil)
+	MustTrue(t, m.SetAutoScale(3, 2) != nil)
+	MustNil(t, m.SetAutoScale(2, 3))
+	MustTrue(t, m.scaleStop != nil)
+
+	// scale to the min, and then scale down to the min since the pollers are idle.
+	m.mu.Lock()
+	m.scale()
+	Equal(t, m.NumLoops, 2)
+	m.scaleMin = 1
+	m.scale()
+	Equal(t, m.NumLoops, 1)
+	m.scale()
+	Equal(t, m.NumLoops, 1)
+	m.mu.Unlock()
+
+	var rconns, wconns = newConnPairs(t, 2)
+	pingConnPairs(t, rconns, wconns)
+	MustNil(t, m.SetAutoScale(0, 0))
+	MustTrue(t, m.scaleStop == nil)
+	for i := range rconns {
+		MustNil(t, rconns[i].Close())
+		MustNil(t, wconns[i].Close())
+	}
+}
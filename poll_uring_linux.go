@@ -0,0 +1,720 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	uringEntries = 1024
+
+	// The received data is copied from the provided buffers into the input buffer of connection.
+	uringBufGroup   = 0
+	uringBufEntries = 256 // must be the power of 2
+	uringBufSize    = 4 * block4k
+
+	// uringLinkIovecs is the max number of iovecs of each writev in a linked chain.
+	uringLinkIovecs = 8
+)
+
+// The kinds of the requests, stored in the lowest byte of user_data.
+const (
+	uringWake = iota
+	uringClose
+	uringCancel
+	uringAccept
+	uringRecv
+	uringPollIn
+	uringPollOut
+	uringWriteKick
+	uringWrite
+)
+
+// openURingPoll opens the io_uring poll, and returns error if the kernel doesn't support it.
+// It requires linux 5.19+ for provided buffer rings and multishot accept, and multishot recv
+// since linux 6.0 is used if available.
+func openURingPoll() (Poll, error) {
+	ring, err := openURing(uringEntries,
+		IORING_OP_NOP, IORING_OP_WRITEV, IORING_OP_POLL_ADD,
+		IORING_OP_ACCEPT, IORING_OP_ASYNC_CANCEL, IORING_OP_RECV)
+	if err != nil {
+		return nil, err
+	}
+	bufs, err := ring.registerBufRing(uringBufGroup, uringBufEntries, uringBufSize)
+	if err != nil {
+		ring.Close()
+		return nil, err
+	}
+	var poll = &uringPoll{
+		ring:    ring,
+		bufs:    bufs,
+		ops:     make(map[*FDOperator]*uringOperator),
+		cqes:    make([]uringCQE, len(ring.cqes)),
+		opcache: newOperatorCache(),
+	}
+	poll.barrier.bs = make([][]byte, barriercap)
+	return poll, nil
+}
+
+// uringPoll is the Poll based on io_uring. Unlike epoll, it is completion based:
+//   - the listener accepts by multishot accept, and the conns are handed to FDOperator.OnAccept.
+//   - the connection reads by multishot recv with provided buffers, so no readiness event is needed.
+//   - the output is written by writev in the poller, and the iovecs beyond uringLinkIovecs are
+//     split into a chain of linked writev, which are executed in order by the kernel.
+//   - Trigger and Close submit NOP, so there is no eventfd.
+type uringPoll struct {
+	busyPoll
+	pollLoad
+
+	ring *uring
+	bufs *uringBufRing
+
+	mu    sync.Mutex // serializes the submission, and protects ops and slots
+	ops   map[*FDOperator]*uringOperator
+	slots []*uringOperator
+	frees []int32
+
+	pending []uringSQE // the requests to be submitted by the poller
+	waking  bool
+
+	cqes       []uringCQE
+	barrier    barrier
+	hups       []func(p Poll) error
+	trigger    uint32
+	closed     int32
+	recvSingle bool // multishot recv is not supported
+	opcache    *operatorCache
+}
+
+// uringOperator is the state of a registered FDOperator.
+// The requests in flight are tagged by index and gen, so that the completions of
+// a detached operator are dropped, even if the FDOperator has been reused.
+type uringOperator struct {
+	operator  *FDOperator
+	index     int32
+	gen       uint32
+	inflight  int  // the number of requests not completed
+	detached  bool // removed from poll, waiting for the requests in flight
+	noAccept  bool // multishot accept is not supported, use OnRead instead
+	receiving bool // the recv request is in flight
+
+	rw      int32 // 1 if the output is waiting to be written
+	writing int32 // 1 if the poller is writing the output
+	// linked writev, only accessed by the poller.
+	bs, out [][]byte
+	ivs     []syscall.Iovec
+	lens    []int
+	wdone   int
+	wn      int
+	broken  bool
+	werr    error
+}
+
+// Wait implements Poll.
+func (p *uringPoll) Wait() (err error) {
+	var idle bool
+	for {
+		p.mu.Lock()
+		p.flush()
+		p.mu.Unlock()
+		var n = p.ring.peekCQEs(p.cqes)
+		if n == 0 {
+			if !idle || p.busySpin() {
+				// like epoll, yield to run the goroutines readied by the handler before blocking.
+				idle = true
+				runtime.Gosched()
+				continue
+			}
+			if err = p.ring.wait(); err != nil && err != syscall.EINTR && err != syscall.EAGAIN && err != syscall.EBUSY {
+				return err
+			}
+			continue
+		}
+		idle = false
+		p.busyReset()
+		var start = time.Now()
+		if p.handler(p.cqes[:n]) {
+			return nil
+		}
+		// we can make sure that there is no op remaining if handler finished
+		p.opcache.free()
+		p.addEvents(n, start)
+	}
+}
+
+func (p *uringPoll) handler(cqes []uringCQE) (closed bool) {
+	for i := range cqes {
+		var cqe = &cqes[i]
+		switch cqe.userData & 0xff {
+		case uringWake:
+			atomic.StoreUint32(&p.trigger, 0)
+			continue
+		case uringClose:
+			atomic.StoreInt32(&p.closed, 1)
+			p.ring.Close()
+			p.bufs.Close()
+			return true
+		case uringCancel:
+			continue
+		}
+		var st = p.get(cqe)
+		if st == nil || !p.acquire(st.operator) {
+			// the operator has been detached, and only the buffer needs to be given back.
+			if cqe.flags&IORING_CQE_F_BUFFER != 0 {
+				p.bufs.recycle(uint16(cqe.flags >> IORING_CQE_BUFFER_SHIFT))
+			}
+		} else if p.handle(st, cqe) {
+			st.operator.done()
+		}
+		p.put(st)
+	}
+	// hup conns together to avoid blocking the poll.
+	p.detaches()
+	return false
+}
+
+// handle handles the completion, and returns false if the operator is hup.
+func (p *uringPoll) handle(st *uringOperator, cqe *uringCQE) (ok bool) {
+	var operator = st.operator
+	var more = cqe.flags&IORING_CQE_F_MORE != 0
+	switch cqe.userData & 0xff {
+	case uringAccept:
+		if cqe.res >= 0 {
+			operator.OnAccept(int(cqe.res), p)
+		} else if errno := syscall.Errno(-cqe.res); errno == syscall.EINVAL {
+			st.noAccept = true
+		} else if errno != syscall.ECANCELED && errno != syscall.EAGAIN {
+			logger.Printf("NETPOLL: accept(fd=%d) failed: %s", operator.FD, errno.Error())
+		}
+		if !more {
+			p.rearm(st)
+		}
+	case uringRecv:
+		if cqe.flags&IORING_CQE_F_BUFFER != 0 {
+			var bid = uint16(cqe.flags >> IORING_CQE_BUFFER_SHIFT)
+			if cqe.res > 0 {
+				p.input(operator, p.bufs.buffer(bid, int(cqe.res)))
+			}
+			p.bufs.recycle(bid)
+		}
+		if cqe.res == 0 {
+			// EOF, the same as EPOLLRDHUP.
+			if operator.OnRdHup == nil {
+				return p.appendHup(operator)
+			}
+			// the reading is paused by OnRdHup, so it won't be restarted by rearm.
+			operator.OnRdHup(p)
+		}
+		if cqe.res < 0 {
+			switch errno := syscall.Errno(-cqe.res); errno {
+			case syscall.ENOBUFS, syscall.EAGAIN, syscall.EINTR:
+			case syscall.ECANCELED:
+				// canceled by PollPauseRead, the reading will be restarted by PollResumeRead.
+			case syscall.EINVAL:
+				if p.recvSingle {
+					logger.Printf("NETPOLL: recv(fd=%d) failed: %s", operator.FD, errno.Error())
+					return p.appendHup(operator)
+				}
+				p.recvSingle = true
+			default:
+				// ENOTCONN is returned instead of EOF if the peer of unix socket has been closed.
+				if errno != syscall.ENOTCONN {
+					logger.Printf("NETPOLL: recv(fd=%d) failed: %s", operator.FD, errno.Error())
+				}
+				return p.appendHup(operator)
+			}
+		}
+		if !more {
+			p.rearm(st)
+		}
+	case uringPollIn:
+		if cqe.res < 0 {
+			return p.appendHup(operator)
+		}
+		if cqe.res&POLLIN != 0 {
+			operator.OnRead(p)
+		}
+		if cqe.res&(POLLHUP|POLLRDHUP|POLLERR) != 0 {
+			return p.appendHup(operator)
+		}
+		p.rearm(st)
+	case uringPollOut:
+		if cqe.res < 0 || cqe.res&POLLHUP != 0 {
+			return p.appendHup(operator)
+		}
+		if operator.OnWrite != nil {
+			// for non-connection
+			operator.OnWrite(p)
+		} else if operator.Outputs != nil {
+			// for connection waiting for the file to be sent
+			return p.write(st)
+		}
+	case uringWriteKick:
+		return p.write(st)
+	case uringWrite:
+		return p.written(st, cqe.res)
+	}
+	return true
+}
+
+// get finds the operator of the cqe and holds it until put, and returns nil if it has been detached.
+func (p *uringPoll) get(cqe *uringCQE) (st *uringOperator) {
+	var index, gen = int32(cqe.userData >> 32), uint32(cqe.userData>>8) & 0xffffff
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st = p.slots[index]
+	if st.gen&0xffffff != gen {
+		return nil
+	}
+	if cqe.flags&IORING_CQE_F_MORE == 0 {
+		st.inflight--
+	}
+	if st.detached {
+		if st.inflight == 0 {
+			p.release(st)
+		}
+		return nil
+	}
+	// the state can't be released while the poller is handling it.
+	st.inflight++
+	return st
+}
+
+// put releases the hold of get.
+func (p *uringPoll) put(st *uringOperator) {
+	if st == nil {
+		return
+	}
+	p.mu.Lock()
+	st.inflight--
+	if st.detached && st.inflight == 0 {
+		p.release(st)
+	}
+	p.mu.Unlock()
+}
+
+// acquire waits for the other goroutine (e.g. Release of connection) to finish operating the FDOperator,
+// and the completion can't be skipped like epoll, since the data has been received.
+func (p *uringPoll) acquire(operator *FDOperator) bool {
+	for !operator.do() {
+		if operator.isUnused() {
+			return false
+		}
+		runtime.Gosched()
+	}
+	return true
+}
+
+// input copies the received data into the connection.
+func (p *uringPoll) input(operator *FDOperator, data []byte) {
+	for len(data) > 0 {
+		var bs = operator.Inputs(p.barrier.bs)
+		if len(bs) == 0 {
+			return
+		}
+		var n int
+		for i := range bs {
+			n += copy(bs[i], data[n:])
+		}
+		operator.InputAck(n)
+		data = data[n:]
+	}
+}
+
+// write sends the output of connection until it has to wait for the completion, and returns false if hup.
+func (p *uringPoll) write(st *uringOperator) (ok bool) {
+	var operator = st.operator
+	for {
+		for atomic.LoadInt32(&st.rw) == 1 {
+			var bs, _ = operator.Outputs(st.bs)
+			if len(bs) > 0 {
+				if err := p.writev(st, bs); err != nil {
+					logger.Printf("NETPOLL: writev(fd=%d) failed: %s", operator.FD, err.Error())
+					return p.appendHup(operator)
+				}
+				return true
+			}
+			if operator.OutputFile == nil {
+				break
+			}
+			if err := operator.OutputFile(); err != nil {
+				logger.Printf("NETPOLL: sendfile(fd=%d) failed: %s", operator.FD, err.Error())
+				return p.appendHup(operator)
+			}
+			if atomic.LoadInt32(&st.rw) == 1 {
+				// EAGAIN, wait for writable.
+				p.mu.Lock()
+				defer p.mu.Unlock()
+				if !st.detached {
+					p.prepare(st, uringPollOut, IORING_OP_POLL_ADD, POLLOUT)
+				}
+				return true
+			}
+		}
+		atomic.StoreInt32(&st.writing, 0)
+		// PollR2RW may be called before writing is cleared.
+		if atomic.LoadInt32(&st.rw) == 0 || !atomic.CompareAndSwapInt32(&st.writing, 0, 1) {
+			return true
+		}
+	}
+}
+
+// writev prepares bs as a chain of linked writev.
+func (p *uringPoll) writev(st *uringOperator, bs [][]byte) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if st.detached {
+		// the connection is closing, and the output is given up.
+		return nil
+	}
+	var iovLen = iovecs(bs, st.ivs)
+	st.out, st.lens = bs, st.lens[:0]
+	for start := 0; start < iovLen; start += uringLinkIovecs {
+		var end = start + uringLinkIovecs
+		if end > iovLen {
+			end = iovLen
+		}
+		var sqe = p.prepare(st, uringWrite, IORING_OP_WRITEV, 0)
+		sqe.addr, sqe.len = uint64(ivsAddr(st.ivs[start:])), uint32(end-start)
+		if end < iovLen {
+			sqe.flags = IOSQE_IO_LINK
+		}
+		var l int
+		for i := start; i < end; i++ {
+			l += int(st.ivs[i].Len)
+		}
+		st.lens = append(st.lens, l)
+	}
+	return nil
+}
+
+// written handles the completion of a writev in the chain, and returns false if hup.
+func (p *uringPoll) written(st *uringOperator, res int32) (ok bool) {
+	var operator = st.operator
+	if !st.broken {
+		if res >= 0 {
+			st.wn += int(res)
+			// a short write breaks the chain, and the rest are canceled.
+			st.broken = int(res) < st.lens[st.wdone]
+		} else {
+			switch errno := syscall.Errno(-res); errno {
+			case syscall.ECANCELED, syscall.EAGAIN, syscall.EINTR:
+			default:
+				st.werr = errno
+			}
+			st.broken = true
+		}
+	}
+	st.wdone++
+	if st.wdone < len(st.lens) {
+		return true
+	}
+	var n, err = st.wn, st.werr
+	resetIovecs(st.out, st.ivs)
+	st.out, st.wdone, st.wn, st.broken, st.werr = nil, 0, 0, false, nil
+	operator.OutputAck(n)
+	if err != nil {
+		logger.Printf("NETPOLL: writev(fd=%d) failed: %s", operator.FD, err.Error())
+		return p.appendHup(operator)
+	}
+	return p.write(st)
+}
+
+// Close implements Poll.
+func (p *uringPoll) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.nop(uringClose)
+}
+
+// Trigger implements Poll.
+func (p *uringPoll) Trigger() error {
+	if atomic.AddUint32(&p.trigger, 1) > 1 {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.nop(uringWake)
+}
+
+// Control implements Poll.
+func (p *uringPoll) Control(operator *FDOperator, event PollEvent) error {
+	if event == PollReadable || event == PollWritable {
+		operator.inuse()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var st = p.ops[operator]
+	switch event {
+	case PollReadable, PollWritable, PollModReadable:
+		if st == nil {
+			st = p.attach(operator)
+		}
+		if event == PollWritable {
+			// client create a new connection and wait connect finished
+			p.prepare(st, uringPollOut, IORING_OP_POLL_ADD, POLLOUT)
+		} else if err := p.arm(st); err != nil {
+			return err
+		}
+		return p.wake()
+	}
+	if st == nil || st.detached {
+		return nil
+	}
+	switch event {
+	case PollDetach:
+		p.detach(st)
+		return p.wake()
+	case PollPauseRead:
+		if !st.receiving {
+			return nil
+		}
+		// the data received before canceled is still delivered.
+		p.pending = append(p.pending, uringSQE{
+			opcode:   IORING_OP_ASYNC_CANCEL,
+			addr:     p.userData(st, uringRecv),
+			userData: uringCancel,
+		})
+		return p.wake()
+	case PollResumeRead:
+		if err := p.arm(st); err != nil {
+			return err
+		}
+		return p.wake()
+	case PollR2RW:
+		atomic.StoreInt32(&st.rw, 1)
+		if atomic.CompareAndSwapInt32(&st.writing, 0, 1) {
+			// the output must be written by the poller, so wake it up by a NOP.
+			st.inflight++
+			return p.nop(p.userData(st, uringWriteKick))
+		}
+	case PollRW2R:
+		atomic.StoreInt32(&st.rw, 0)
+	}
+	return nil
+}
+
+// Alloc implements Poll.
+func (p *uringPoll) Alloc() (operator *FDOperator) {
+	op := p.opcache.alloc()
+	op.poll = p
+	return op
+}
+
+// Free implements Poll, and waits until the requests of the operator have completed,
+// since the kernel may still be referencing the memory of the connection.
+func (p *uringPoll) Free(operator *FDOperator) {
+	p.mu.Lock()
+	if st := p.ops[operator]; st != nil && !st.detached {
+		p.detach(st)
+	}
+	p.mu.Unlock()
+	p.waitDetached(operator)
+	p.opcache.freeable(operator)
+}
+
+// waitDetached implements detachWaiter.
+func (p *uringPoll) waitDetached(operator *FDOperator) {
+	for atomic.LoadInt32(&p.closed) == 0 {
+		p.mu.Lock()
+		var _, ok = p.ops[operator]
+		p.mu.Unlock()
+		if !ok {
+			return
+		}
+		runtime.Gosched()
+	}
+}
+
+// attach allocates the state of operator, must be called with mu held.
+func (p *uringPoll) attach(operator *FDOperator) (st *uringOperator) {
+	if n := len(p.frees); n > 0 {
+		st = p.slots[p.frees[n-1]]
+		p.frees = p.frees[:n-1]
+	} else {
+		st = &uringOperator{index: int32(len(p.slots))}
+		st.bs = make([][]byte, barriercap)
+		st.ivs = make([]syscall.Iovec, barriercap)
+		p.slots = append(p.slots, st)
+	}
+	st.operator = operator
+	p.ops[operator] = st
+	p.addConns(1)
+	return st
+}
+
+// detach cancels all the requests of operator, must be called with mu held.
+func (p *uringPoll) detach(st *uringOperator) {
+	st.detached = true
+	p.addConns(-1)
+	if st.inflight == 0 {
+		p.release(st)
+		return
+	}
+	p.pending = append(p.pending, uringSQE{
+		opcode:   IORING_OP_ASYNC_CANCEL,
+		fd:       int32(st.operator.FD),
+		opFlags:  IORING_ASYNC_CANCEL_FD | IORING_ASYNC_CANCEL_ALL,
+		userData: uringCancel,
+	})
+}
+
+// release recycles the state after all the requests have completed, must be called with mu held.
+func (p *uringPoll) release(st *uringOperator) {
+	if p.ops[st.operator] == st {
+		delete(p.ops, st.operator)
+	}
+	if st.out != nil {
+		resetIovecs(st.out, st.ivs)
+	}
+	st.operator, st.gen = nil, st.gen+1
+	st.detached, st.noAccept, st.receiving = false, false, false
+	st.rw, st.writing = 0, 0
+	st.out, st.wdone, st.wn, st.broken, st.werr = nil, 0, 0, false, nil
+	p.frees = append(p.frees, st.index)
+}
+
+// arm prepares the request to read, must be called with mu held.
+func (p *uringPoll) arm(st *uringOperator) error {
+	var operator = st.operator
+	switch {
+	case operator.OnRead != nil && operator.OnAccept != nil && !st.noAccept:
+		var sqe = p.prepare(st, uringAccept, IORING_OP_ACCEPT, syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC)
+		sqe.ioprio = IORING_ACCEPT_MULTISHOT
+	case operator.OnRead != nil:
+		// oneshot, so that it is level triggered after re-armed.
+		p.prepare(st, uringPollIn, IORING_OP_POLL_ADD, POLLIN|POLLRDHUP)
+	case operator.Inputs != nil:
+		// only one recv request is in flight, and it isn't restarted until resumed if paused.
+		if st.receiving || operator.isPaused() {
+			return nil
+		}
+		st.receiving = true
+		var sqe = p.prepare(st, uringRecv, IORING_OP_RECV, 0)
+		sqe.flags, sqe.bufGroup = IOSQE_BUFFER_SELECT, uringBufGroup
+		if !p.recvSingle {
+			sqe.ioprio = IORING_RECV_MULTISHOT
+		}
+	default:
+		return Exception(ErrUnsupported, "operator has no fn to read")
+	}
+	return nil
+}
+
+// rearm prepares the request to read again after the last one finished.
+func (p *uringPoll) rearm(st *uringOperator) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if st.detached {
+		return
+	}
+	// the last request to read has finished.
+	st.receiving = false
+	if err := p.arm(st); err != nil {
+		logger.Printf("NETPOLL: poller rearm operator failed: %v", err)
+	}
+}
+
+// prepare queues a request of the operator, must be called with mu held.
+// The requests are submitted by the poller in flush, since the completions are processed by
+// the task work of the submitter thread, which shouldn't be the thread of a busy user goroutine.
+// The returned SQE is only valid until the next call.
+func (p *uringPoll) prepare(st *uringOperator, kind uint64, opcode uint8, opFlags uint32) *uringSQE {
+	p.pending = append(p.pending, uringSQE{
+		opcode:   opcode,
+		fd:       int32(st.operator.FD),
+		opFlags:  opFlags,
+		userData: p.userData(st, kind),
+	})
+	st.inflight++
+	return &p.pending[len(p.pending)-1]
+}
+
+func (p *uringPoll) userData(st *uringOperator, kind uint64) uint64 {
+	return uint64(st.index)<<32 | uint64(st.gen&0xffffff)<<8 | kind
+}
+
+// flush submits the queued requests, must be called by the poller with mu held.
+func (p *uringPoll) flush() {
+	for i := range p.pending {
+		*p.getSQE() = p.pending[i]
+		p.pending[i] = uringSQE{}
+	}
+	p.pending, p.waking = p.pending[:0], false
+	if err := p.ring.submit(); err != nil {
+		logger.Printf("NETPOLL: io_uring submit failed: %v", err)
+	}
+}
+
+// wake wakes up the poller to submit the queued requests, must be called with mu held.
+func (p *uringPoll) wake() error {
+	if p.waking || len(p.pending) == 0 {
+		return nil
+	}
+	p.waking = true
+	return p.nop(uringWake)
+}
+
+// nop submits a NOP directly, which completes inline without task work, must be called with mu held.
+func (p *uringPoll) nop(userData uint64) error {
+	var sqe = p.getSQE()
+	sqe.opcode, sqe.userData = IORING_OP_NOP, userData
+	return p.ring.submit()
+}
+
+func ivsAddr(ivs []syscall.Iovec) uintptr {
+	return uintptr(unsafe.Pointer(&ivs[0]))
+}
+
+// getSQE returns a SQE, and submits the prepared ones if the queue is full.
+func (p *uringPoll) getSQE() (sqe *uringSQE) {
+	for sqe = p.ring.getSQE(); sqe == nil; sqe = p.ring.getSQE() {
+		if err := p.ring.submit(); err != nil {
+			runtime.Gosched()
+		}
+	}
+	return sqe
+}
+
+// appendHup detaches the operator, and always returns false for the convenience of handle.
+func (p *uringPoll) appendHup(operator *FDOperator) (ok bool) {
+	p.hups = append(p.hups, operator.OnHup)
+	if err := operator.Control(PollDetach); err != nil {
+		logger.Printf("NETPOLL: poller detach operator failed: %v", err)
+	}
+	operator.done()
+	return false
+}
+
+func (p *uringPoll) detaches() {
+	if len(p.hups) == 0 {
+		return
+	}
+	hups := p.hups
+	p.hups = nil
+	go func(onhups []func(p Poll) error) {
+		for i := range onhups {
+			if onhups[i] != nil {
+				onhups[i](p)
+			}
+		}
+	}(hups)
+}
@@ -0,0 +1,184 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestMain runs all the tests under io_uring if NETPOLL_TEST_POLL=io_uring.
+func TestMain(m *testing.M) {
+	if os.Getenv("NETPOLL_TEST_POLL") == "io_uring" {
+		if err := SetPollType(IOUringPoll); err != nil {
+			panic(err)
+		}
+	}
+	os.Exit(m.Run())
+}
+
+// usePollType replaces the global pollmanager with a new one of PollType until restore.
+func usePollType(t PollType) (restore func()) {
+	var old = pollmanager
+	pollmanager = &manager{pollType: t}
+	pollmanager.SetLoadBalance(RoundRobin)
+	pollmanager.SetNumLoops(1)
+	return func() {
+		pollmanager.Close()
+		pollmanager = old
+	}
+}
+
+func skipIfNoURing(t testing.TB) {
+	if _, ok := pollmanager.Pick().(*uringPoll); !ok {
+		t.Skip("io_uring is not supported")
+	}
+}
+
+func TestURingPollFallback(t *testing.T) {
+	var poll = openPollType(IOUringPoll)
+	MustTrue(t, poll != nil)
+	go poll.Wait()
+	MustNil(t, poll.Trigger())
+	MustNil(t, poll.Close())
+}
+
+func TestURingPollConnection(t *testing.T) {
+	defer usePollType(IOUringPoll)()
+	skipIfNoURing(t)
+
+	var network, address = "tcp", ":18890"
+	// larger than the provided buffers and the socket buffers, so that the output is
+	// sent by linked writev and the input is received by multiple recv.
+	var size = 4 * 1024 * 1024
+	var msg = bytes.Repeat([]byte("0123456789abcdef"), size/16)
+	var loop = newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			input, err := connection.Reader().Next(size)
+			if err != nil {
+				return err
+			}
+			// write in pieces to produce scattered iovecs.
+			for i := 0; i < len(input); i += 64 * 1024 {
+				if _, err = connection.Writer().WriteBinary(input[i : i+64*1024]); err != nil {
+					return err
+				}
+			}
+			return connection.Writer().Flush()
+		},
+	)
+	defer loop.Shutdown(context.Background())
+	time.Sleep(10 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		conn, err := DialConnection(network, address, time.Second)
+		MustNil(t, err)
+		_, err = conn.Writer().WriteBinary(msg)
+		MustNil(t, err)
+		MustNil(t, conn.Writer().Flush())
+		output, err := conn.Reader().Next(size)
+		MustNil(t, err)
+		MustTrue(t, bytes.Equal(output, msg))
+		MustNil(t, conn.Close())
+	}
+}
+
+func TestURingPollHup(t *testing.T) {
+	defer usePollType(IOUringPoll)()
+	skipIfNoURing(t)
+
+	var network, address = "tcp", ":18891"
+	var closed = make(chan struct{})
+	var loop = newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			return nil
+		},
+		WithOnConnect(func(ctx context.Context, connection Connection) context.Context {
+			connection.AddCloseCallback(func(connection Connection) error {
+				close(closed)
+				return nil
+			})
+			return ctx
+		}),
+	)
+	defer loop.Shutdown(context.Background())
+	time.Sleep(10 * time.Millisecond)
+
+	conn, err := DialConnection(network, address, time.Second)
+	MustNil(t, err)
+	MustNil(t, conn.Close())
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("the server connection is not closed after the peer hangup")
+	}
+}
+
+func BenchmarkPollEcho(b *testing.B) {
+	var cases = []struct {
+		name     string
+		pollType PollType
+	}{
+		{"epoll", EpollPoll},
+		{"io_uring", IOUringPoll},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			defer usePollType(c.pollType)()
+			if c.pollType == IOUringPoll {
+				skipIfNoURing(b)
+			}
+			benchmarkPollEcho(b, 1024)
+		})
+	}
+}
+
+func benchmarkPollEcho(b *testing.B, size int) {
+	var network, address = "tcp", ":18892"
+	var loop = newTestEventLoop(network, address,
+		func(ctx context.Context, connection Connection) error {
+			input, err := connection.Reader().Next(size)
+			if err != nil {
+				return err
+			}
+			connection.Writer().WriteBinary(input)
+			return connection.Writer().Flush()
+		},
+	)
+	defer loop.Shutdown(context.Background())
+	time.Sleep(10 * time.Millisecond)
+
+	conn, err := DialConnection(network, address, time.Second)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	var msg = make([]byte, size)
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn.Writer().WriteBinary(msg)
+		if err = conn.Writer().Flush(); err != nil {
+			b.Fatal(err)
+		}
+		if _, err = conn.Reader().Next(size); err != nil {
+			b.Fatal(err)
+		}
+		conn.Reader().Release()
+	}
+}
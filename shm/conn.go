@@ -0,0 +1,515 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// the bytes rung on the doorbell.
+const (
+	bellReadable byte = 'r' // the data has been written for the peer waiting to read
+	bellWritable byte = 'w' // the space has been freed for the peer waiting to write
+)
+
+// the directions of connection shut down.
+const (
+	shutRead  int32 = 1 << iota // shut down by CloseRead
+	shutWrite                   // shut down by CloseWrite
+)
+
+var _ netpoll.Connection = &connection{}
+var _ netpoll.AsyncFlusher = &connection{}
+var _ netpoll.MemStatsReporter = &connection{}
+var _ netpoll.Aborter = &connection{}
+var _ netpoll.HalfCloser = &connection{}
+
+// connection implements netpoll.Connection over the rings in the shared memory.
+type connection struct {
+	conn         *net.UnixConn // the doorbell, which also tells the peer's hang-up
+	mu           sync.RWMutex  // guards the memory against unmapping while in use
+	mem          []byte        // nil after unmapped
+	in, out      *ring
+	reader       *reader
+	writer       *writer
+	readable     chan struct{}
+	writable     chan struct{}
+	hup          chan struct{} // closed when the peer hangs up
+	closed       chan struct{} // closed when the connection is closed
+	hupOnce      sync.Once
+	closeOnce    sync.Once
+	shut         int32
+	processing   int32 // 1 if OnRequest is running
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	ctx          context.Context
+	onRequest    atomic.Value // netpoll.OnRequest
+	serveOnce    sync.Once
+	cbMu         sync.Mutex
+	callbacks    []netpoll.CloseCallback
+}
+
+// newConnection creates a connection on mem, whose first half is the ring written by the dialer.
+func newConnection(conn *net.UnixConn, mem []byte, dialer bool) *connection {
+	var half = len(mem) / 2
+	var c = &connection{
+		conn:     conn,
+		mem:      mem,
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+		hup:      make(chan struct{}),
+		closed:   make(chan struct{}),
+		ctx:      context.Background(),
+	}
+	c.in, c.out = newRing(mem[half:]), newRing(mem[:half])
+	if !dialer {
+		c.in, c.out = c.out, c.in
+	}
+	c.reader = &reader{c: c}
+	c.writer = &writer{c: c, over: netpoll.NewLinkBuffer()}
+	go c.doorbell()
+	return c
+}
+
+// Reader implements Connection, whose slices refer to the ring memory until Release,
+// and must not be used after Close except in OnRequest, which holds the memory until it returns.
+func (c *connection) Reader() netpoll.Reader {
+	return c.reader
+}
+
+// Writer implements Connection, whose Malloc hands out the space of the ring,
+// and Flush copies the rest into the ring and waits if it's full.
+func (c *connection) Writer() netpoll.Writer {
+	return c.writer
+}
+
+// IsActive implements Connection.
+func (c *connection) IsActive() bool {
+	return !isDone(c.closed) && !isDone(c.hup)
+}
+
+// SetReadTimeout implements Connection.
+func (c *connection) SetReadTimeout(timeout time.Duration) error {
+	if timeout >= 0 {
+		c.readTimeout = timeout
+	}
+	return nil
+}
+
+// SetWriteTimeout implements Connection.
+func (c *connection) SetWriteTimeout(timeout time.Duration) error {
+	if timeout >= 0 {
+		c.writeTimeout = timeout
+	}
+	return nil
+}
+
+// SetIdleTimeout implements Connection, which is not needed since the hang-up is told by the doorbell.
+func (c *connection) SetIdleTimeout(timeout time.Duration) error {
+	return nil
+}
+
+// SetOnRequest implements Connection, and OnRequest is called in a separate goroutine like netpoll.
+func (c *connection) SetOnRequest(on netpoll.OnRequest) error {
+	if on == nil {
+		return nil
+	}
+	c.onRequest.Store(on)
+	c.serveOnce.Do(func() {
+		go c.serve()
+	})
+	return nil
+}
+
+// AddCloseCallback implements Connection.
+func (c *connection) AddCloseCallback(callback netpoll.CloseCallback) error {
+	if callback == nil {
+		return nil
+	}
+	c.cbMu.Lock()
+	c.callbacks = append(c.callbacks, callback)
+	c.cbMu.Unlock()
+	return nil
+}
+
+// FlushAsync implements netpoll.AsyncFlusher. The data is copied into the ring by Flush,
+// so cb is called before it returns unless Flush fails.
+func (c *connection) FlushAsync(cb func(err error)) error {
+	if err := c.writer.Flush(); err != nil {
+		return err
+	}
+	if cb != nil {
+		cb(nil)
+	}
+	return nil
+}
+
+// MemStats implements netpoll.MemStatsReporter, which returns the memory of the rings.
+func (c *connection) MemStats() netpoll.MemStats {
+	var size = int64(len(c.in.data)+len(c.out.data)) + 2*headerSize
+	var stats = netpoll.MemStats{Allocated: size, InUse: size}
+	if isDone(c.closed) {
+		stats.InUse = 0
+	}
+	return stats
+}
+
+// Abort implements netpoll.Aborter, which is the same as Close since the output is never pending.
+func (c *connection) Abort() error {
+	return c.Close()
+}
+
+// CloseWrite implements HalfCloser.
+func (c *connection) CloseWrite() error {
+	if !c.IsActive() {
+		return netpoll.Exception(netpoll.ErrConnClosed, "when close write")
+	}
+	if !c.shutdown(shutWrite) {
+		return nil
+	}
+	if c.rlock() {
+		atomic.StoreUint32(c.out.shut, 1)
+		if atomic.CompareAndSwapUint32(c.out.readWait, 1, 0) {
+			c.ring(bellReadable)
+		}
+		c.mu.RUnlock()
+	}
+	if c.isShut(shutRead) {
+		return c.Close()
+	}
+	return nil
+}
+
+// CloseRead implements HalfCloser.
+func (c *connection) CloseRead() error {
+	if !c.IsActive() {
+		return netpoll.Exception(netpoll.ErrConnClosed, "when close read")
+	}
+	if !c.shutdown(shutRead) {
+		return nil
+	}
+	notify(c.readable)
+	if c.isShut(shutWrite) {
+		return c.Close()
+	}
+	return nil
+}
+
+// Close implements Connection. The peer reads the rest data in the ring before EOF.
+func (c *connection) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.mu.Lock()
+		atomic.StoreUint32(c.out.shut, 1)
+		c.conn.Close()
+		c.mu.Unlock()
+		// the slices read by OnRequest refer to the memory until it returns.
+		if atomic.LoadInt32(&c.processing) == 0 {
+			c.unmap()
+		}
+
+		c.cbMu.Lock()
+		var callbacks = c.callbacks
+		c.callbacks = nil
+		c.cbMu.Unlock()
+		for i := len(callbacks) - 1; i >= 0; i-- {
+			_ = callbacks[i](c)
+		}
+	})
+	return nil
+}
+
+// Read implements net.Conn.
+func (c *connection) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err = c.reader.Peek(1); err != nil {
+		return 0, err
+	}
+	if n = c.reader.Len(); n > len(p) {
+		n = len(p)
+	}
+	src, err := c.reader.Next(n)
+	n = copy(p, src)
+	if err == nil {
+		err = c.reader.Release()
+	}
+	return n, err
+}
+
+// Write implements net.Conn.
+func (c *connection) Write(p []byte) (n int, err error) {
+	dst, err := c.writer.Malloc(len(p))
+	if err != nil {
+		return 0, err
+	}
+	n = copy(dst, p)
+	if err = c.writer.Flush(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// LocalAddr implements net.Conn.
+func (c *connection) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr implements net.Conn.
+func (c *connection) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline implements net.Conn.
+func (c *connection) SetDeadline(t time.Time) error {
+	return netpoll.Exception(netpoll.ErrUnsupported, "SetDeadline")
+}
+
+// SetReadDeadline implements net.Conn.
+func (c *connection) SetReadDeadline(t time.Time) error {
+	return netpoll.Exception(netpoll.ErrUnsupported, "SetReadDeadline")
+}
+
+// SetWriteDeadline implements net.Conn.
+func (c *connection) SetWriteDeadline(t time.Time) error {
+	return netpoll.Exception(netpoll.ErrUnsupported, "SetWriteDeadline")
+}
+
+// ------------------------------------------ private ------------------------------------------
+
+// write copies all of p into the output ring, and waits if it's full.
+func (c *connection) write(p []byte) (n int, err error) {
+	for n < len(p) {
+		if c.isShut(shutWrite) {
+			return n, netpoll.Exception(syscall.EPIPE, "when write")
+		}
+		if isDone(c.hup) {
+			return n, netpoll.Exception(netpoll.ErrConnClosed, "when write")
+		}
+		if !c.rlock() {
+			return n, netpoll.Exception(netpoll.ErrConnClosed, "when write")
+		}
+		var m, ok = c.out.write(p[n:])
+		if m > 0 && atomic.CompareAndSwapUint32(c.out.readWait, 1, 0) {
+			c.ring(bellReadable)
+		}
+		c.mu.RUnlock()
+		if !ok {
+			return n, c.corrupted()
+		}
+		if n += m; m > 0 {
+			continue
+		}
+		if err = c.waitWritable(c.writeTimeout); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// waitReadable waits until the input ring has been written to the position until,
+// and returns ErrEOF if the peer has shut down writing.
+func (c *connection) waitReadable(until uint64, timeout time.Duration) error {
+	var timer <-chan time.Time
+	if timeout > 0 {
+		var t = time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	for {
+		if c.isShut(shutRead) {
+			return netpoll.Exception(netpoll.ErrEOF, "")
+		}
+		if !c.rlock() {
+			return netpoll.Exception(netpoll.ErrConnClosed, "when read")
+		}
+		// set the flag before checking, so that either the data or the bell won't be missed.
+		atomic.StoreUint32(c.in.readWait, 1)
+		var ready, shut = int64(atomic.LoadUint64(c.in.head)-until) >= 0, c.in.isShut()
+		if ready || shut {
+			atomic.StoreUint32(c.in.readWait, 0)
+		}
+		c.mu.RUnlock()
+		if ready {
+			return nil
+		}
+		// the rest data must be read before EOF.
+		if shut || isDone(c.hup) {
+			return netpoll.Exception(netpoll.ErrEOF, "")
+		}
+		select {
+		case <-c.readable:
+		case <-c.hup:
+		case <-c.closed:
+		case <-timer:
+			return netpoll.Exception(netpoll.ErrReadTimeout, c.RemoteAddr().String())
+		}
+	}
+}
+
+// waitWritable waits until the output ring has space.
+func (c *connection) waitWritable(timeout time.Duration) error {
+	var timer <-chan time.Time
+	if timeout > 0 {
+		var t = time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	for {
+		if !c.rlock() {
+			return netpoll.Exception(netpoll.ErrConnClosed, "when write")
+		}
+		atomic.StoreUint32(c.out.spaceWait, 1)
+		// the corrupted ring is told by the write after.
+		var n, ok = c.out.writable(atomic.LoadUint64(c.out.head))
+		var ready = n > 0 || !ok
+		if ready {
+			atomic.StoreUint32(c.out.spaceWait, 0)
+		}
+		c.mu.RUnlock()
+		if ready {
+			return nil
+		}
+		select {
+		case <-c.writable:
+		case <-c.hup:
+			return netpoll.Exception(netpoll.ErrConnClosed, "when write")
+		case <-c.closed:
+		case <-timer:
+			return netpoll.Exception(netpoll.ErrWriteTimeout, c.RemoteAddr().String())
+		}
+	}
+}
+
+// ring rings the doorbell of the peer.
+func (c *connection) ring(b byte) {
+	_, _ = c.conn.Write([]byte{b})
+}
+
+// doorbell dispatches the bells rung by the peer, until the peer hangs up or the connection is closed.
+func (c *connection) doorbell() {
+	var buf [64]byte
+	for {
+		n, err := c.conn.Read(buf[:])
+		for _, b := range buf[:n] {
+			switch b {
+			case bellReadable:
+				notify(c.readable)
+			case bellWritable:
+				notify(c.writable)
+			}
+		}
+		if err != nil {
+			c.hupOnce.Do(func() {
+				close(c.hup)
+			})
+			return
+		}
+	}
+}
+
+// serve calls OnRequest when there is input, until the input is drained after EOF or the connection is closed.
+func (c *connection) serve() {
+	defer c.Close()
+	var pending int
+	for {
+		// wait for more data if OnRequest has left the input unread like netpoll.
+		if n := c.reader.Len(); n == 0 || n == pending {
+			if _, err := c.reader.wait(n+1, 0); err != nil {
+				return
+			}
+		}
+		var onRequest, _ = c.onRequest.Load().(netpoll.OnRequest)
+		atomic.StoreInt32(&c.processing, 1)
+		_ = onRequest(c.ctx, c)
+		atomic.StoreInt32(&c.processing, 0)
+		if isDone(c.closed) {
+			c.unmap()
+			return
+		}
+		pending = c.reader.Len()
+	}
+}
+
+// rlock read-locks the memory, and returns false without locking if the connection has been closed.
+func (c *connection) rlock() bool {
+	c.mu.RLock()
+	if c.mem == nil || isDone(c.closed) {
+		c.mu.RUnlock()
+		return false
+	}
+	return true
+}
+
+// unmap unmaps the memory after the connection has been closed.
+func (c *connection) unmap() {
+	c.mu.Lock()
+	if c.mem != nil {
+		syscall.Munmap(c.mem)
+		c.mem = nil
+	}
+	c.mu.Unlock()
+}
+
+// corrupted closes the connection whose rings have been corrupted by the peer.
+func (c *connection) corrupted() error {
+	c.Close()
+	return errCorrupted
+}
+
+// shutdown marks the direction shut down, and returns false if it has been marked.
+func (c *connection) shutdown(how int32) bool {
+	for {
+		var shut = atomic.LoadInt32(&c.shut)
+		if shut&how != 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&c.shut, shut, shut|how) {
+			return true
+		}
+	}
+}
+
+// isShut checks whether any of the directions has been shut down.
+func (c *connection) isShut(how int32) bool {
+	return atomic.LoadInt32(&c.shut)&how != 0
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func isDone(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package shm provides a netpoll.Connection over shared memory between the processes on the same host,
+// which avoids the syscalls and copies of sockets on the data path, e.g. for the traffic between a sidecar
+// and the application. It's only supported on Linux.
+//
+// The dialer creates a memfd with a ring for each direction, and sends it to the listener over a unix socket.
+// Then the data is written into and read from the rings directly, and the unix socket is kept as the doorbell,
+// which is only rung when the peer is waiting for data or space. Since Connection is implemented with the same
+// Reader and Writer, the handlers written for netpoll.EventLoop work unchanged by NewEventLoop:
+//
+//	ln, _ := shm.Listen("/tmp/app.sock")
+//	loop, _ := shm.NewEventLoop(onRequest)
+//	loop.Serve(ln)
+//
+//	conn, _ := shm.DialConnection("/tmp/app.sock", time.Second)
+package shm
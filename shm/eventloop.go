@@ -0,0 +1,175 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// Option configures the EventLoop created by NewEventLoop.
+type Option struct {
+	f func(*options)
+}
+
+type options struct {
+	onPrepare   netpoll.OnPrepare
+	onConnect   netpoll.OnConnect
+	readTimeout time.Duration
+}
+
+// WithOnPrepare registers the OnPrepare method to EventLoop.
+func WithOnPrepare(onPrepare netpoll.OnPrepare) Option {
+	return Option{func(op *options) {
+		op.onPrepare = onPrepare
+	}}
+}
+
+// WithOnConnect registers the OnConnect method to EventLoop.
+func WithOnConnect(onConnect netpoll.OnConnect) Option {
+	return Option{func(op *options) {
+		op.onConnect = onConnect
+	}}
+}
+
+// WithReadTimeout sets the read timeout of connections.
+func WithReadTimeout(timeout time.Duration) Option {
+	return Option{func(op *options) {
+		op.readTimeout = timeout
+	}}
+}
+
+// NewEventLoop creates a netpoll.EventLoop that serves the Listener created by Listen,
+// and calls the handlers in the same way as netpoll.NewEventLoop.
+func NewEventLoop(onRequest netpoll.OnRequest, ops ...Option) (netpoll.EventLoop, error) {
+	if onRequest == nil {
+		return nil, errors.New("shm: OnRequest is nil")
+	}
+	var opts = &options{}
+	for _, do := range ops {
+		do.f(opts)
+	}
+	return &eventLoop{
+		onRequest:   onRequest,
+		opts:        opts,
+		connections: make(map[*connection]struct{}),
+	}, nil
+}
+
+type eventLoop struct {
+	onRequest   netpoll.OnRequest
+	opts        *options
+	mu          sync.Mutex
+	ln          net.Listener
+	shutdown    bool
+	connections map[*connection]struct{}
+}
+
+// Serve implements EventLoop, and returns nil after Shutdown.
+func (evl *eventLoop) Serve(ln net.Listener) error {
+	if _, ok := ln.(*Listener); !ok {
+		return errors.New("shm: the listener is not created by shm.Listen")
+	}
+	evl.mu.Lock()
+	evl.ln = ln
+	evl.mu.Unlock()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			evl.mu.Lock()
+			var shutdown = evl.shutdown
+			evl.mu.Unlock()
+			if shutdown {
+				return nil
+			}
+			return err
+		}
+		var c = conn.(*connection)
+		evl.mu.Lock()
+		evl.connections[c] = struct{}{}
+		evl.mu.Unlock()
+		c.AddCloseCallback(func(connection netpoll.Connection) error {
+			evl.mu.Lock()
+			delete(evl.connections, c)
+			evl.mu.Unlock()
+			return nil
+		})
+		go evl.connect(c)
+	}
+}
+
+// Shutdown implements EventLoop, which closes the listener and the idle connections,
+// and waits for the connections in progress until ctx is done.
+func (evl *eventLoop) Shutdown(ctx context.Context) error {
+	evl.mu.Lock()
+	evl.shutdown = true
+	var ln = evl.ln
+	evl.mu.Unlock()
+	if ln != nil {
+		ln.Close()
+	}
+
+	var ticker = time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		evl.mu.Lock()
+		var idle []*connection
+		for c := range evl.connections {
+			if atomic.LoadInt32(&c.processing) == 0 {
+				idle = append(idle, c)
+			}
+		}
+		var hasConn = len(evl.connections) > 0
+		evl.mu.Unlock()
+		if !hasConn {
+			return nil
+		}
+		for _, c := range idle {
+			c.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// connect prepares the connection, and then starts calling OnRequest.
+func (evl *eventLoop) connect(c *connection) {
+	c.SetReadTimeout(evl.opts.readTimeout)
+	var ctx = context.Background()
+	if evl.opts.onPrepare != nil {
+		if prepared := evl.opts.onPrepare(c); prepared != nil {
+			ctx = prepared
+		}
+	}
+	if evl.opts.onConnect != nil {
+		if connected := evl.opts.onConnect(ctx, c); connected != nil {
+			ctx = connected
+		}
+	}
+	c.ctx = ctx
+	c.SetOnRequest(evl.onRequest)
+}
@@ -0,0 +1,384 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"fmt"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+var _ netpoll.Reader = &reader{}
+var _ netpoll.Writer = &writer{}
+
+// reader implements netpoll.Reader over the input ring. The slices read refer to the ring until Release,
+// unless the data wraps around, so the peer can't write into the space of them before that.
+type reader struct {
+	c        *connection
+	pos      uint64 // the position read to
+	released uint64 // the position released to, which is the tail of the ring
+}
+
+// Next implements Reader. n can be larger than the ring only if the data read before has been released,
+// and then the data is copied out and released as it's read.
+func (r *reader) Next(n int) (p []byte, err error) {
+	if n <= 0 {
+		return
+	}
+	if r.fits(n) {
+		if p, err = r.peek(n); err == nil {
+			r.pos += uint64(n)
+		}
+		return p, err
+	}
+	p = make([]byte, n)
+	return p, r.drain(p)
+}
+
+// Peek implements Reader, and n can't be larger than the ring.
+func (r *reader) Peek(n int) (buf []byte, err error) {
+	if n <= 0 {
+		return
+	}
+	if !r.fits(n) {
+		return nil, errTooLarge
+	}
+	return r.peek(n)
+}
+
+// Skip implements Reader.
+func (r *reader) Skip(n int) (err error) {
+	if n <= 0 {
+		return
+	}
+	if !r.fits(n) {
+		return r.drain(make([]byte, n))
+	}
+	if _, err = r.wait(n, r.c.readTimeout); err == nil {
+		r.pos += uint64(n)
+	}
+	return err
+}
+
+// Until implements Reader.
+func (r *reader) Until(delim byte) (line []byte, err error) {
+	var c, scanned = r.c, 0
+	for {
+		n, err := r.wait(scanned+1, c.readTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if !c.rlock() {
+			return nil, netpoll.Exception(netpoll.ErrConnClosed, "when read")
+		}
+		var i = c.in.index(r.pos+uint64(scanned), n-scanned, delim)
+		c.mu.RUnlock()
+		if i >= 0 {
+			return r.Next(scanned + i + 1)
+		}
+		scanned = n
+	}
+}
+
+// ReadString implements Reader.
+func (r *reader) ReadString(n int) (s string, err error) {
+	p, err := r.Next(n)
+	return string(p), err
+}
+
+// ReadBinary implements Reader, which copies the data out of the ring.
+func (r *reader) ReadBinary(n int) (p []byte, err error) {
+	src, err := r.Next(n)
+	if err != nil || len(src) == 0 {
+		return nil, err
+	}
+	p = make([]byte, len(src))
+	copy(p, src)
+	return p, nil
+}
+
+// ReadByte implements Reader.
+func (r *reader) ReadByte() (b byte, err error) {
+	p, err := r.Next(1)
+	if err != nil {
+		return 0, err
+	}
+	return p[0], nil
+}
+
+// Slice implements Reader, which copies the data into a LinkBuffer since the ring is released.
+func (r *reader) Slice(n int) (netpoll.Reader, error) {
+	p, err := r.Next(n)
+	if err != nil {
+		return nil, err
+	}
+	var buf = netpoll.NewLinkBuffer(len(p))
+	dst, _ := buf.Malloc(len(p))
+	copy(dst, p)
+	buf.Flush()
+	return buf, r.Release()
+}
+
+// Release implements Reader, which frees the space read for the peer.
+func (r *reader) Release() (err error) {
+	if r.released == r.pos {
+		return nil
+	}
+	r.release()
+	return nil
+}
+
+// Len implements Reader.
+func (r *reader) Len() (length int) {
+	length, _ = r.readable()
+	return length
+}
+
+// fits checks whether n bytes can be read into the ring with the data not released.
+func (r *reader) fits(n int) bool {
+	return int(r.pos-r.released)+n <= len(r.c.in.data)
+}
+
+// readable returns the length of the data to read, and closes the connection if the ring is corrupted.
+func (r *reader) readable() (int, error) {
+	var c = r.c
+	if !c.rlock() {
+		return 0, netpoll.Exception(netpoll.ErrConnClosed, "when read")
+	}
+	var size, ok = c.in.readable(r.released)
+	c.mu.RUnlock()
+	var n = size - int(r.pos-r.released)
+	if !ok || n < 0 {
+		return 0, c.corrupted()
+	}
+	return n, nil
+}
+
+// wait waits until there are n bytes to read, which must fit in the ring, and returns the length of the data.
+func (r *reader) wait(n int, timeout time.Duration) (length int, err error) {
+	var c = r.c
+	for {
+		if c.isShut(shutRead) {
+			return 0, netpoll.Exception(netpoll.ErrEOF, "")
+		}
+		if length, err = r.readable(); err != nil || length >= n {
+			return length, err
+		}
+		if !r.fits(n) {
+			return length, errTooLarge
+		}
+		if err = c.waitReadable(r.pos+uint64(n), timeout); err != nil {
+			return length, err
+		}
+	}
+}
+
+// peek waits for n bytes, and returns them without advancing the position.
+func (r *reader) peek(n int) (p []byte, err error) {
+	if _, err = r.wait(n, r.c.readTimeout); err != nil {
+		return nil, err
+	}
+	var c = r.c
+	if !c.rlock() {
+		return nil, netpoll.Exception(netpoll.ErrConnClosed, "when read")
+	}
+	defer c.mu.RUnlock()
+	return c.in.slice(r.pos, n), nil
+}
+
+// drain copies the data larger than the ring into p, and releases it as it's read,
+// which requires the data read before has been released.
+func (r *reader) drain(p []byte) error {
+	if r.released != r.pos {
+		return errTooLarge
+	}
+	var c = r.c
+	for len(p) > 0 {
+		n, err := r.wait(1, c.readTimeout)
+		if err != nil {
+			return err
+		}
+		if n > len(p) {
+			n = len(p)
+		}
+		if !c.rlock() {
+			return netpoll.Exception(netpoll.ErrConnClosed, "when read")
+		}
+		copy(p, c.in.slice(r.pos, n))
+		c.mu.RUnlock()
+		r.pos += uint64(n)
+		r.release()
+		p = p[n:]
+	}
+	return nil
+}
+
+// release moves the tail of the ring to the position read, and rings the peer waiting for space.
+func (r *reader) release() {
+	var c = r.c
+	r.released = r.pos
+	if c.rlock() {
+		atomic.StoreUint64(c.in.tail, r.released)
+		if atomic.CompareAndSwapUint32(c.in.spaceWait, 1, 0) {
+			c.ring(bellWritable)
+		}
+		c.mu.RUnlock()
+	}
+}
+
+// writer implements netpoll.Writer over the output ring. Malloc hands out the space of the ring,
+// which is published to the peer by Flush. Once the ring has no contiguous space for Malloc, the rest
+// is written into a LinkBuffer and copied into the ring by Flush, which waits if the ring is full.
+type writer struct {
+	c       *connection
+	pos     uint64              // the position malloced to
+	head    uint64              // the position flushed to, which is the head of the ring
+	spilled bool                // whether the data has been written into the overflow since Flush
+	over    *netpoll.LinkBuffer // the data written after the ring has no space
+}
+
+// Malloc implements Writer.
+func (w *writer) Malloc(n int) (buf []byte, err error) {
+	if n <= 0 {
+		return
+	}
+	if !w.spilled {
+		var c = w.c
+		if c.rlock() {
+			var space, ok = c.out.writable(w.pos)
+			var off = int(w.pos & c.out.mask)
+			if ok && n <= space && off+n <= len(c.out.data) {
+				buf = c.out.data[off : off+n : off+n]
+				w.pos += uint64(n)
+			}
+			c.mu.RUnlock()
+		}
+		if buf != nil {
+			return buf, nil
+		}
+		w.spilled = true
+	}
+	return w.over.Malloc(n)
+}
+
+// MallocLen implements Writer.
+func (w *writer) MallocLen() (length int) {
+	return int(w.pos-w.head) + w.over.MallocLen()
+}
+
+// MallocAck implements Writer.
+func (w *writer) MallocAck(n int) (err error) {
+	if n < 0 {
+		return fmt.Errorf("shm writer malloc ack[%d] invalid", n)
+	}
+	var m = int(w.pos - w.head)
+	if n <= m {
+		w.pos = w.head + uint64(n)
+		w.spilled = false
+		return w.over.MallocAck(0)
+	}
+	return w.over.MallocAck(n - m)
+}
+
+// Append implements Writer, which appends the LinkBuffer after the data malloced.
+func (w *writer) Append(w2 netpoll.Writer) (err error) {
+	w.spilled = true
+	return w.over.Append(w2)
+}
+
+// WriteString implements Writer, which copies s into the ring.
+func (w *writer) WriteString(s string) (n int, err error) {
+	if len(s) == 0 {
+		return
+	}
+	buf, _ := w.Malloc(len(s))
+	return copy(buf, s), nil
+}
+
+// WriteBinary implements Writer, which copies b into the ring.
+func (w *writer) WriteBinary(b []byte) (n int, err error) {
+	if len(b) == 0 {
+		return
+	}
+	buf, _ := w.Malloc(len(b))
+	return copy(buf, b), nil
+}
+
+// WriteByte implements Writer.
+func (w *writer) WriteByte(b byte) (err error) {
+	buf, _ := w.Malloc(1)
+	buf[0] = b
+	return nil
+}
+
+// WriteDirect implements Writer. p can't be inserted before the space malloced in the ring,
+// which is only supported after the data has been written into the overflow, or if remainCap is 0.
+func (w *writer) WriteDirect(p []byte, remainCap int) error {
+	if len(p) == 0 || remainCap < 0 {
+		return nil
+	}
+	if remainCap == 0 {
+		_, err := w.WriteBinary(p)
+		return err
+	}
+	if remainCap <= w.over.MallocLen() {
+		return w.over.WriteDirect(p, remainCap)
+	}
+	return netpoll.Exception(netpoll.ErrUnsupported, "WriteDirect into the ring")
+}
+
+// Flush implements Writer, which publishes the data in the ring, and then copies the overflow into it.
+func (w *writer) Flush() (err error) {
+	if w.pos == w.head && !w.spilled {
+		return nil
+	}
+	var c = w.c
+	if c.isShut(shutWrite) {
+		return netpoll.Exception(syscall.EPIPE, "when write")
+	}
+	if isDone(c.hup) {
+		return netpoll.Exception(netpoll.ErrConnClosed, "when write")
+	}
+	if w.pos != w.head {
+		if !c.rlock() {
+			return netpoll.Exception(netpoll.ErrConnClosed, "when write")
+		}
+		atomic.StoreUint64(c.out.head, w.pos)
+		if atomic.CompareAndSwapUint32(c.out.readWait, 1, 0) {
+			c.ring(bellReadable)
+		}
+		c.mu.RUnlock()
+		w.head = w.pos
+	}
+	if !w.spilled {
+		return nil
+	}
+	w.over.Flush()
+	n, err := c.write(w.over.Bytes())
+	w.head += uint64(n)
+	w.pos = w.head
+	w.over.Skip(n)
+	w.over.Release()
+	if w.over.Len() == 0 {
+		w.spilled = false
+	}
+	return err
+}
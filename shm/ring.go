@@ -0,0 +1,133 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"bytes"
+	"sync/atomic"
+	"unsafe"
+)
+
+// The layout of a ring in the shared memory, with the header of a page followed by the data.
+// The fields written by different sides are kept in separate cache lines.
+const (
+	offHead      = 0   // uint64, the total bytes written, updated by the producer
+	offTail      = 64  // uint64, the total bytes read, updated by the consumer
+	offReadWait  = 128 // uint32, 1 if the consumer is waiting for data
+	offSpaceWait = 192 // uint32, 1 if the producer is waiting for space
+	offShut      = 256 // uint32, 1 if the producer has shut down writing
+	headerSize   = 4096
+)
+
+// ring is a single-producer single-consumer byte queue in the shared memory.
+type ring struct {
+	head      *uint64
+	tail      *uint64
+	readWait  *uint32
+	spaceWait *uint32
+	shut      *uint32
+	data      []byte
+	mask      uint64
+}
+
+// newRing maps the ring on mem, whose data size must be a power of 2.
+func newRing(mem []byte) *ring {
+	var base = unsafe.Pointer(&mem[0])
+	var data = mem[headerSize:]
+	return &ring{
+		head:      (*uint64)(unsafe.Pointer(uintptr(base) + offHead)),
+		tail:      (*uint64)(unsafe.Pointer(uintptr(base) + offTail)),
+		readWait:  (*uint32)(unsafe.Pointer(uintptr(base) + offReadWait)),
+		spaceWait: (*uint32)(unsafe.Pointer(uintptr(base) + offSpaceWait)),
+		shut:      (*uint32)(unsafe.Pointer(uintptr(base) + offShut)),
+		data:      data,
+		mask:      uint64(len(data) - 1),
+	}
+}
+
+// readable returns the length of the data to read from pos, the tail of the consumer, and false if the head
+// written by the peer is out of range, in which case the length is clamped to [0, len(data)].
+func (r *ring) readable(pos uint64) (int, bool) {
+	return r.clamp(int64(atomic.LoadUint64(r.head) - pos))
+}
+
+// writable returns the length of the free space to write from pos, the head of the producer, and false if
+// the tail written by the peer is out of range, in which case the length is clamped to [0, len(data)].
+func (r *ring) writable(pos uint64) (int, bool) {
+	return r.clamp(int64(len(r.data)) - int64(pos-atomic.LoadUint64(r.tail)))
+}
+
+// clamp limits n to [0, len(data)], and returns false if it's out of range.
+func (r *ring) clamp(n int64) (int, bool) {
+	if n < 0 {
+		return 0, false
+	}
+	if n > int64(len(r.data)) {
+		return len(r.data), false
+	}
+	return int(n), true
+}
+
+// write copies p as much as possible into the ring, which is only called by the producer.
+func (r *ring) write(p []byte) (n int, ok bool) {
+	var head = atomic.LoadUint64(r.head)
+	if n, ok = r.writable(head); !ok {
+		return 0, false
+	}
+	if n > len(p) {
+		n = len(p)
+	}
+	var off = int(head & r.mask)
+	var m = copy(r.data[off:], p[:n])
+	copy(r.data, p[m:n])
+	atomic.StoreUint64(r.head, head+uint64(n))
+	return n, ok
+}
+
+// slice returns the n bytes from pos, which refers to the ring unless they wrap around.
+func (r *ring) slice(pos uint64, n int) []byte {
+	var off = int(pos & r.mask)
+	if off+n <= len(r.data) {
+		return r.data[off : off+n : off+n]
+	}
+	var p = make([]byte, n)
+	var m = copy(p, r.data[off:])
+	copy(p[m:], r.data)
+	return p
+}
+
+// index returns the index of the first delim in the n bytes from pos, or -1 if not present.
+func (r *ring) index(pos uint64, n int, delim byte) int {
+	var off = int(pos & r.mask)
+	if off+n <= len(r.data) {
+		return bytes.IndexByte(r.data[off:off+n], delim)
+	}
+	var m = len(r.data) - off
+	if i := bytes.IndexByte(r.data[off:], delim); i >= 0 {
+		return i
+	}
+	if i := bytes.IndexByte(r.data[:n-m], delim); i >= 0 {
+		return m + i
+	}
+	return -1
+}
+
+// isShut checks whether the producer has shut down writing.
+func (r *ring) isShut() bool {
+	return atomic.LoadUint32(r.shut) != 0
+}
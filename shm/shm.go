@@ -0,0 +1,253 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/cloudwego/netpoll"
+)
+
+// RingSize that can be modified is the size of the ring of each direction created by DialConnection,
+// which is rounded up to a power of 2 and at least a page.
+var RingSize = 4 * 1024 * 1024
+
+// maxRingSize limits the ring size accepted by the listener.
+const maxRingSize = 1 << 30
+
+// handshakeTimeout limits the time to set up the shared memory after the unix socket accepted.
+const handshakeTimeout = 3 * time.Second
+
+// the seals that keep the size of the shared memory, otherwise the peer could make the mapping fault.
+const memSeals = unix.F_SEAL_SHRINK | unix.F_SEAL_GROW | unix.F_SEAL_SEAL
+
+// logger reports the connections failed to set up, whose output is set by SetLoggerOutput.
+var logger = log.New(os.Stderr, "", log.LstdFlags)
+
+// SetLoggerOutput sets the output of the logs of shm, like netpoll.SetLoggerOutput.
+func SetLoggerOutput(w io.Writer) {
+	logger = log.New(w, "", log.LstdFlags)
+}
+
+var (
+	errHandshake = errors.New("shm: invalid handshake")
+	errCorrupted = errors.New("shm: corrupted ring")
+	errTooLarge  = errors.New("shm: the data is larger than the ring with the unreleased data")
+)
+
+// DialConnection connects to the Listener on the unix socket path, and sets up the shared memory.
+// The timeout covers both of them.
+func DialConnection(path string, timeout time.Duration) (connection netpoll.Connection, err error) {
+	var dialer = net.Dialer{Timeout: timeout}
+	nc, err := dialer.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	var conn = nc.(*net.UnixConn)
+	defer func() {
+		if err != nil {
+			conn.Close()
+		}
+	}()
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	var size = ringSize(RingSize)
+	fd, err := unix.MemfdCreate("netpoll-shm", unix.MFD_CLOEXEC|unix.MFD_ALLOW_SEALING)
+	if err != nil {
+		return nil, os.NewSyscallError("memfd_create", err)
+	}
+	defer syscall.Close(fd)
+	var total = 2 * (headerSize + size)
+	if err = syscall.Ftruncate(fd, int64(total)); err != nil {
+		return nil, os.NewSyscallError("ftruncate", err)
+	}
+	if _, err = unix.FcntlInt(uintptr(fd), unix.F_ADD_SEALS, memSeals); err != nil {
+		return nil, os.NewSyscallError("fcntl", err)
+	}
+	mem, err := syscall.Mmap(fd, 0, total, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, os.NewSyscallError("mmap", err)
+	}
+	defer func() {
+		if err != nil {
+			syscall.Munmap(mem)
+		}
+	}()
+
+	var msg [8]byte
+	binary.LittleEndian.PutUint64(msg[:], uint64(size))
+	if _, _, err = conn.WriteMsgUnix(msg[:], syscall.UnixRights(fd), nil); err != nil {
+		return nil, err
+	}
+	// wait for the listener to map the memory.
+	if _, err = conn.Read(msg[:1]); err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return newConnection(conn, mem, true), nil
+}
+
+// Listen announces on the unix socket path for DialConnection.
+func Listen(path string) (*Listener, error) {
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	var l = &Listener{
+		ln:    ln,
+		conns: make(chan *connection),
+		done:  make(chan struct{}),
+	}
+	go l.serve()
+	return l, nil
+}
+
+// Listener implements net.Listener, which accepts the connections over the shared memory.
+type Listener struct {
+	ln    *net.UnixListener
+	conns chan *connection // the connections set up
+	done  chan struct{}    // closed when the unix listener fails
+	err   error            // the error of the unix listener
+}
+
+// Accept implements net.Listener, and the returned net.Conn can be asserted as netpoll.Connection.
+// The connections are set up in their own goroutines, and the ones failed are closed and skipped.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+// Close implements net.Listener.
+func (l *Listener) Close() error {
+	return l.ln.Close()
+}
+
+// Addr implements net.Listener.
+func (l *Listener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// serve accepts the unix sockets, and sets up the connections in their own goroutines,
+// so that a slow or malicious dialer can't block the others.
+func (l *Listener) serve() {
+	for {
+		conn, err := l.ln.AcceptUnix()
+		if err != nil {
+			l.err = err
+			close(l.done)
+			return
+		}
+		go l.handshake(conn)
+	}
+}
+
+// handshake sets up the connection on conn, and hands it to Accept.
+func (l *Listener) handshake(conn *net.UnixConn) {
+	c, err := accept(conn)
+	if err != nil {
+		conn.Close()
+		logger.Printf("NETPOLL: shm accept connection from %v failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+// accept maps the shared memory sent by the dialer.
+func accept(conn *net.UnixConn) (c *connection, err error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	var msg [8]byte
+	var oob = make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(msg[:], oob)
+	if err != nil {
+		return nil, err
+	}
+	scms, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	var fds []int
+	for i := range scms {
+		rights, err := syscall.ParseUnixRights(&scms[i])
+		if err != nil {
+			return nil, err
+		}
+		fds = append(fds, rights...)
+	}
+	for _, fd := range fds {
+		defer syscall.Close(fd)
+	}
+	if n != len(msg) || len(fds) != 1 {
+		return nil, errHandshake
+	}
+
+	var size = binary.LittleEndian.Uint64(msg[:])
+	if size < uint64(os.Getpagesize()) || size > maxRingSize || size&(size-1) != 0 {
+		return nil, fmt.Errorf("shm: invalid ring size %d", size)
+	}
+	var total = 2 * (headerSize + int(size))
+	var stat syscall.Stat_t
+	if err = syscall.Fstat(fds[0], &stat); err != nil {
+		return nil, os.NewSyscallError("fstat", err)
+	}
+	seals, err := unix.FcntlInt(uintptr(fds[0]), unix.F_GET_SEALS, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("fcntl", err)
+	}
+	if stat.Size != int64(total) || seals&memSeals != memSeals {
+		return nil, errHandshake
+	}
+	mem, err := syscall.Mmap(fds[0], 0, total, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, os.NewSyscallError("mmap", err)
+	}
+	if _, err = conn.Write(msg[:1]); err != nil {
+		syscall.Munmap(mem)
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return newConnection(conn, mem, false), nil
+}
+
+// ringSize rounds size up to a power of 2, which is at least a page.
+func ringSize(size int) int {
+	var n = os.Getpagesize()
+	for n < size && n < maxRingSize {
+		n <<= 1
+	}
+	return n
+}
@@ -0,0 +1,234 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/netpoll"
+)
+
+func TestEventLoopEcho(t *testing.T) {
+	var size = RingSize
+	RingSize = os.Getpagesize()
+	defer func() { RingSize = size }()
+
+	var echo = func(ctx context.Context, connection netpoll.Connection) error {
+		var reader, writer = connection.Reader(), connection.Writer()
+		p, err := reader.Next(reader.Len())
+		if err != nil {
+			return err
+		}
+		_, err = writer.WriteBinary(p)
+		if err == nil {
+			err = writer.Flush()
+		}
+		reader.Release()
+		return err
+	}
+	ln, loop := serve(t, echo)
+	defer loop.Shutdown(context.Background())
+
+	conn, err := DialConnection(ln.Addr().String(), time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+
+	// the message is much larger than the rings, which wraps around and waits for the space.
+	var msg = make([]byte, 1024*1024)
+	for i := range msg {
+		msg[i] = byte(i % 251)
+	}
+	var done = make(chan error, 1)
+	go func() {
+		_, err := conn.Write(msg)
+		done <- err
+	}()
+	p, err := conn.Reader().Next(len(msg))
+	MustNil(t, err)
+	MustTrue(t, bytes.Equal(p, msg))
+	MustNil(t, <-done)
+	MustNil(t, conn.Reader().Release())
+	MustTrue(t, conn.(netpoll.MemStatsReporter).MemStats().InUse > int64(2*RingSize))
+}
+
+func TestConnectionClose(t *testing.T) {
+	var closed = make(chan struct{})
+	var onRequest = func(ctx context.Context, connection netpoll.Connection) error {
+		// reply the rest data after the peer shut down writing.
+		line, err := connection.Reader().Next(6)
+		if err != nil {
+			return err
+		}
+		connection.Writer().WriteBinary(line)
+		connection.Writer().Flush()
+		_, err = connection.Reader().Next(1)
+		if !errors.Is(err, netpoll.ErrEOF) {
+			return err
+		}
+		return connection.Close()
+	}
+	ln, loop := serve(t, onRequest)
+	defer loop.Shutdown(context.Background())
+
+	conn, err := DialConnection(ln.Addr().String(), time.Second)
+	MustNil(t, err)
+	conn.AddCloseCallback(func(connection netpoll.Connection) error {
+		close(closed)
+		return nil
+	})
+	MustNil(t, conn.SetReadTimeout(50*time.Millisecond))
+	_, err = conn.Reader().Next(1)
+	MustTrue(t, errors.Is(err, netpoll.ErrReadTimeout))
+
+	_, err = conn.Write([]byte("hello\n"))
+	MustNil(t, err)
+	MustNil(t, conn.(netpoll.HalfCloser).CloseWrite())
+	_, err = conn.Write([]byte("world\n"))
+	MustTrue(t, err != nil)
+
+	// the reply is read before EOF.
+	MustNil(t, conn.SetReadTimeout(time.Second))
+	line, err := conn.Reader().ReadString(6)
+	MustNil(t, err)
+	Equal(t, line, "hello\n")
+	_, err = conn.Reader().Next(1)
+	MustTrue(t, errors.Is(err, netpoll.ErrEOF))
+	MustTrue(t, !conn.IsActive())
+	MustNil(t, conn.Close())
+	<-closed
+	Equal(t, conn.(netpoll.MemStatsReporter).MemStats().InUse, int64(0))
+}
+
+func TestEventLoopShutdown(t *testing.T) {
+	var onRequest = func(ctx context.Context, connection netpoll.Connection) error {
+		return nil
+	}
+	ln, loop := serve(t, onRequest)
+	conn, err := DialConnection(ln.Addr().String(), time.Second)
+	MustNil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	MustNil(t, loop.Shutdown(ctx))
+	_, err = conn.Reader().Next(1)
+	MustTrue(t, errors.Is(err, netpoll.ErrEOF))
+	MustNil(t, conn.Close())
+	_, err = DialConnection(ln.Addr().String(), time.Second)
+	MustTrue(t, err != nil)
+}
+
+func TestConnectionNocopy(t *testing.T) {
+	var onRequest = func(ctx context.Context, connection netpoll.Connection) error {
+		return nil
+	}
+	ln, loop := serve(t, onRequest)
+	defer loop.Shutdown(context.Background())
+	conn, err := DialConnection(ln.Addr().String(), time.Second)
+	MustNil(t, err)
+	defer conn.Close()
+	var c = conn.(*connection)
+
+	// Malloc hands out the ring, and Next reads the ring of the peer.
+	buf, err := c.Writer().Malloc(5)
+	MustNil(t, err)
+	MustTrue(t, &buf[0] == &c.out.data[0])
+	copy(buf, "hello")
+	MustNil(t, c.Writer().Flush())
+	// write the ring of the peer as if it's the peer.
+	_, ok := c.in.write([]byte("world"))
+	MustTrue(t, ok)
+	p, err := c.Reader().Next(5)
+	MustNil(t, err)
+	Equal(t, string(p), "world")
+	MustTrue(t, &p[0] == &c.in.data[0])
+	MustNil(t, c.Reader().Release())
+}
+
+func TestConnectionCorrupted(t *testing.T) {
+	var onRequest = func(ctx context.Context, connection netpoll.Connection) error {
+		return nil
+	}
+	ln, loop := serve(t, onRequest)
+	defer loop.Shutdown(context.Background())
+	conn, err := DialConnection(ln.Addr().String(), time.Second)
+	MustNil(t, err)
+	var c = conn.(*connection)
+
+	// the head beyond the ring is told instead of panicking.
+	*c.in.head = uint64(len(c.in.data)) + 1
+	_, err = conn.Reader().Next(1)
+	MustTrue(t, errors.Is(err, errCorrupted))
+	MustTrue(t, !conn.IsActive())
+}
+
+func TestListenerSlowHandshake(t *testing.T) {
+	var onRequest = func(ctx context.Context, connection netpoll.Connection) error {
+		return nil
+	}
+	ln, loop := serve(t, onRequest)
+	defer loop.Shutdown(context.Background())
+
+	// the dialer without the handshake doesn't block the others.
+	slow, err := net.Dial("unix", ln.Addr().String())
+	MustNil(t, err)
+	defer slow.Close()
+	conn, err := DialConnection(ln.Addr().String(), time.Second)
+	MustNil(t, err)
+	MustNil(t, conn.Close())
+}
+
+func serve(t *testing.T, onRequest netpoll.OnRequest) (*Listener, netpoll.EventLoop) {
+	dir, err := ioutil.TempDir("", "shm")
+	MustNil(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	ln, err := Listen(filepath.Join(dir, "shm.sock"))
+	MustNil(t, err)
+	loop, err := NewEventLoop(onRequest)
+	MustNil(t, err)
+	go loop.Serve(ln)
+	return ln, loop
+}
+
+func MustNil(t *testing.T, val interface{}) {
+	t.Helper()
+	if val != nil {
+		t.Fatal("assertion nil failed, val=", val)
+	}
+}
+
+func MustTrue(t *testing.T, cond bool) {
+	t.Helper()
+	if !cond {
+		t.Fatal("assertion true failed.")
+	}
+}
+
+func Equal(t *testing.T, got, expect interface{}) {
+	t.Helper()
+	if got != expect {
+		t.Fatalf("assertion equal failed, got=[%v], expect=[%v]", got, expect)
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// cpuSetSize is the CPU_SETSIZE of glibc.
+const cpuSetSize = 1024
+
+// checkAffinity checks whether the cpu can be used by setAffinity.
+func checkAffinity(cpu int) error {
+	if cpu < 0 || cpu >= cpuSetSize {
+		return fmt.Errorf("invalid cpu[%d]", cpu)
+	}
+	return nil
+}
+
+// setAffinity binds the current thread to the cpus, it must be called with the goroutine locked to the thread.
+func setAffinity(cpus []int) error {
+	var set [cpuSetSize / 64]uint64
+	for _, cpu := range cpus {
+		set[cpu/64] |= 1 << (uint(cpu) % 64)
+	}
+	_, _, e := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0, uintptr(len(set)*8), uintptr(unsafe.Pointer(&set)))
+	if e != 0 {
+		return e
+	}
+	return nil
+}
@@ -35,6 +35,12 @@ func setTCPNoDelay(fd int, b bool) (err error) {
 	return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY, boolint(b))
 }
 
+// setLinger set the SO_LINGER option on socket, and sec == 0 means sending RST on close.
+func setLinger(fd, sec int) (err error) {
+	var l = syscall.Linger{Onoff: 1, Linger: int32(sec)}
+	return syscall.SetsockoptLinger(fd, syscall.SOL_SOCKET, syscall.SO_LINGER, &l)
+}
+
 // Wrapper around the socket system call that marks the returned file
 // descriptor as nonblocking and close-on-exec.
 func sysSocket(family, sotype, proto int) (int, error) {
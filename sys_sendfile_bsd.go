@@ -0,0 +1,40 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package netpoll
+
+import "time"
+
+func fileSendMode(fd int) (splice bool, err error) {
+	return false, ErrUnsupported
+}
+
+func sendfile(dst, src int, offset *int64, n int) (int, error) {
+	return 0, ErrUnsupported
+}
+
+func splice(dst, src int, n int) (int, error) {
+	return 0, ErrUnsupported
+}
+
+func sysPipe() (r, w int, err error) {
+	return -1, -1, ErrUnsupported
+}
+
+func waitReadable(fd int, timeout time.Duration) (err error) {
+	return ErrUnsupported
+}
@@ -0,0 +1,99 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// the flags of splice.
+const (
+	spliceFMove     = 0x1
+	spliceFNonblock = 0x2
+)
+
+// maxSendfileSize limits the size of each sendfile/splice.
+const maxSendfileSize = 1 << 30
+
+// fileSendMode checks whether fd can be sent by sendfile or splice.
+// Regular files are sent by sendfile, pipes and sockets are sent by splice and set non-blocking.
+func fileSendMode(fd int) (splice bool, err error) {
+	var stat syscall.Stat_t
+	if err = syscall.Fstat(fd, &stat); err != nil {
+		return false, err
+	}
+	switch stat.Mode & syscall.S_IFMT {
+	case syscall.S_IFREG:
+		return false, nil
+	case syscall.S_IFIFO, syscall.S_IFSOCK:
+		// splice can't be interrupted by the write timeout if the source is blocking.
+		if err = syscall.SetNonblock(fd, true); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, ErrUnsupported
+}
+
+// sendfile wraps the sendfile system call.
+func sendfile(dst, src int, offset *int64, n int) (int, error) {
+	if n > maxSendfileSize {
+		n = maxSendfileSize
+	}
+	return syscall.Sendfile(dst, src, offset, n)
+}
+
+// splice wraps the splice system call, one of src and dst must be a pipe.
+func splice(dst, src int, n int) (int, error) {
+	if n > maxSendfileSize {
+		n = maxSendfileSize
+	}
+	r, err := syscall.Splice(src, nil, dst, nil, n, spliceFMove|spliceFNonblock)
+	return int(r), err
+}
+
+// sysPipe creates a nonblocking pipe, which is the intermediate buffer of splice.
+func sysPipe() (r, w int, err error) {
+	var p [2]int
+	if err = syscall.Pipe2(p[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		return -1, -1, err
+	}
+	return p[0], p[1], nil
+}
+
+// waitReadable blocks until fd is readable or timeout, and timeout = 0 means no timeout.
+func waitReadable(fd int, timeout time.Duration) (err error) {
+	var msec = -1
+	if timeout > 0 {
+		msec = int(timeout / time.Millisecond)
+	}
+	var fds = []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		n, err := unix.Poll(fds, msec)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrWriteTimeout
+		}
+		return nil
+	}
+}
@@ -0,0 +1,413 @@
+// Copyright 2022 CloudWeGo Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"reflect"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// The syscall numbers of io_uring are the same on all architectures.
+const (
+	SYS_IO_URING_SETUP    = 425
+	SYS_IO_URING_ENTER    = 426
+	SYS_IO_URING_REGISTER = 427
+)
+
+const (
+	IORING_SETUP_CLAMP = 1 << 4
+
+	IORING_FEAT_SINGLE_MMAP = 1 << 0
+	IORING_FEAT_NODROP      = 1 << 1
+	IORING_FEAT_FAST_POLL   = 1 << 5
+
+	IORING_OFF_SQ_RING = 0
+	IORING_OFF_CQ_RING = 0x8000000
+	IORING_OFF_SQES    = 0x10000000
+
+	IORING_ENTER_GETEVENTS = 1 << 0
+
+	IORING_REGISTER_PROBE     = 8
+	IORING_REGISTER_PBUF_RING = 22
+)
+
+const (
+	IORING_OP_NOP          = 0
+	IORING_OP_WRITEV       = 2
+	IORING_OP_POLL_ADD     = 6
+	IORING_OP_ACCEPT       = 13
+	IORING_OP_ASYNC_CANCEL = 14
+	IORING_OP_RECV         = 27
+)
+
+const (
+	IOSQE_IO_LINK       = 1 << 2
+	IOSQE_BUFFER_SELECT = 1 << 5
+
+	IORING_POLL_ADD_MULTI   = 1 << 0
+	IORING_ACCEPT_MULTISHOT = 1 << 0
+	IORING_RECV_MULTISHOT   = 1 << 1
+
+	IORING_ASYNC_CANCEL_ALL = 1 << 0
+	IORING_ASYNC_CANCEL_FD  = 1 << 1
+
+	IORING_CQE_F_BUFFER     = 1 << 0
+	IORING_CQE_F_MORE       = 1 << 1
+	IORING_CQE_BUFFER_SHIFT = 16
+
+	IO_URING_OP_SUPPORTED = 1 << 0
+)
+
+const (
+	POLLIN    = 0x1
+	POLLOUT   = 0x4
+	POLLERR   = 0x8
+	POLLHUP   = 0x10
+	POLLRDHUP = 0x2000
+)
+
+const (
+	uringSQESize  = 64
+	uringCQESize  = 16
+	uringProbeOps = 256
+
+	uringRequiredFeatures = IORING_FEAT_NODROP | IORING_FEAT_FAST_POLL
+
+	// The tail of a buffer ring overlaps the resv field of the first entry.
+	uringBufEntrySize  = 16
+	uringBufTailOffset = 14
+)
+
+// uringParams is struct io_uring_params.
+type uringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        uringSQOffsets
+	cqOff        uringCQOffsets
+}
+
+type uringSQOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringCQOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+// uringSQE is struct io_uring_sqe.
+type uringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32 // poll32_events, msg_flags, accept_flags, cancel_flags...
+	userData    uint64
+	bufGroup    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+// uringCQE is struct io_uring_cqe.
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// uringBufReg is struct io_uring_buf_reg.
+type uringBufReg struct {
+	ringAddr    uint64
+	ringEntries uint32
+	bgid        uint16
+	flags       uint16
+	resv        [3]uint64
+}
+
+// uringProbe is struct io_uring_probe followed by the ops.
+type uringProbe struct {
+	lastOp uint8
+	opsLen uint8
+	resv   uint16
+	resv2  [3]uint32
+	ops    [uringProbeOps]struct {
+		op    uint8
+		resv  uint8
+		flags uint16
+		resv2 uint32
+	}
+}
+
+// uring is the memory mapped rings of an io_uring instance.
+// The submission queue may be used by multiple goroutines, which must be serialized by the caller,
+// and the completion queue must only be consumed by one goroutine.
+type uring struct {
+	fd      int
+	ringMem []byte
+	cqMem   []byte // nil if FEAT_SINGLE_MMAP
+	sqeMem  []byte
+
+	sqHead, sqTail *uint32
+	sqMask         uint32
+	sqEntries      uint32
+	sqArray        []uint32
+	sqes           []uringSQE
+	sqLocal        uint32 // tail of the SQEs prepared but not submitted
+
+	cqHead, cqTail *uint32
+	cqMask         uint32
+	cqes           []uringCQE
+}
+
+// openURing sets up an io_uring instance, and checks that the kernel supports all the ops.
+func openURing(entries uint32, ops ...uint8) (r *uring, err error) {
+	var params uringParams
+	params.flags = IORING_SETUP_CLAMP
+	r0, _, e0 := syscall.Syscall(SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&params)), 0)
+	if e0 != 0 {
+		return nil, e0
+	}
+	r = &uring{fd: int(r0)}
+	if params.features&uringRequiredFeatures != uringRequiredFeatures {
+		r.Close()
+		return nil, syscall.ENOSYS
+	}
+	if err = r.mmap(&params); err != nil {
+		r.Close()
+		return nil, err
+	}
+	if err = r.probe(ops...); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *uring) mmap(params *uringParams) (err error) {
+	var sqSize = int(params.sqOff.array + params.sqEntries*4)
+	var cqSize = int(params.cqOff.cqes + params.cqEntries*uringCQESize)
+	var single = params.features&IORING_FEAT_SINGLE_MMAP != 0
+	if single && cqSize > sqSize {
+		sqSize = cqSize
+	}
+	r.ringMem, err = syscall.Mmap(r.fd, IORING_OFF_SQ_RING, sqSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		return err
+	}
+	var cqMem = r.ringMem
+	if !single {
+		r.cqMem, err = syscall.Mmap(r.fd, IORING_OFF_CQ_RING, cqSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+		if err != nil {
+			return err
+		}
+		cqMem = r.cqMem
+	}
+	r.sqeMem, err = syscall.Mmap(r.fd, IORING_OFF_SQES, int(params.sqEntries)*uringSQESize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		return err
+	}
+
+	var sq = &params.sqOff
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.ringMem[sq.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.ringMem[sq.tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&r.ringMem[sq.ringMask]))
+	r.sqEntries = *(*uint32)(unsafe.Pointer(&r.ringMem[sq.ringEntries]))
+	r.sqLocal = *r.sqTail
+	sliceHeader(unsafe.Pointer(&r.sqArray), unsafe.Pointer(&r.ringMem[sq.array]), int(r.sqEntries))
+	sliceHeader(unsafe.Pointer(&r.sqes), unsafe.Pointer(&r.sqeMem[0]), int(r.sqEntries))
+
+	var cq = &params.cqOff
+	r.cqHead = (*uint32)(unsafe.Pointer(&cqMem[cq.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&cqMem[cq.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&cqMem[cq.ringMask]))
+	var cqEntries = *(*uint32)(unsafe.Pointer(&cqMem[cq.ringEntries]))
+	sliceHeader(unsafe.Pointer(&r.cqes), unsafe.Pointer(&cqMem[cq.cqes]), int(cqEntries))
+	return nil
+}
+
+// probe checks whether the ops are supported.
+func (r *uring) probe(ops ...uint8) error {
+	var p uringProbe
+	if err := r.register(IORING_REGISTER_PROBE, unsafe.Pointer(&p), uringProbeOps); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if op > p.lastOp || p.ops[op].flags&IO_URING_OP_SUPPORTED == 0 {
+			return syscall.ENOSYS
+		}
+	}
+	return nil
+}
+
+func (r *uring) register(opcode uintptr, arg unsafe.Pointer, nrArgs uintptr) error {
+	_, _, e0 := syscall.Syscall6(SYS_IO_URING_REGISTER, uintptr(r.fd), opcode, uintptr(arg), nrArgs, 0, 0)
+	if e0 != 0 {
+		return e0
+	}
+	return nil
+}
+
+// getSQE returns a zeroed SQE to prepare, or nil if the submission queue is full.
+func (r *uring) getSQE() *uringSQE {
+	if r.sqLocal-atomic.LoadUint32(r.sqHead) >= r.sqEntries {
+		return nil
+	}
+	var idx = r.sqLocal & r.sqMask
+	r.sqArray[idx] = idx
+	r.sqLocal++
+	var sqe = &r.sqes[idx]
+	*sqe = uringSQE{}
+	return sqe
+}
+
+// submit submits all the prepared SQEs.
+func (r *uring) submit() (err error) {
+	atomic.StoreUint32(r.sqTail, r.sqLocal)
+	for {
+		// the kernel consumes the SQEs in io_uring_enter, since SQPOLL is not used.
+		var toSubmit = r.sqLocal - atomic.LoadUint32(r.sqHead)
+		if toSubmit == 0 {
+			return nil
+		}
+		if _, err = r.enter(toSubmit, 0, 0); err != nil && err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// wait blocks until there is at least one CQE.
+func (r *uring) wait() error {
+	_, err := r.enter(0, 1, IORING_ENTER_GETEVENTS)
+	return err
+}
+
+func (r *uring) enter(toSubmit, minComplete uint32, flags uintptr) (n int, err error) {
+	var r0 uintptr
+	var e0 syscall.Errno
+	if minComplete == 0 {
+		r0, _, e0 = syscall.RawSyscall6(SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(toSubmit), 0, flags, 0, 0)
+	} else {
+		r0, _, e0 = syscall.Syscall6(SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(toSubmit), uintptr(minComplete), flags, 0, 0)
+	}
+	if e0 != 0 {
+		return int(r0), e0
+	}
+	return int(r0), nil
+}
+
+// peekCQEs copies the arrived CQEs into cqes and consumes them.
+func (r *uring) peekCQEs(cqes []uringCQE) (n int) {
+	var head = *r.cqHead
+	var tail = atomic.LoadUint32(r.cqTail)
+	for ; head != tail && n < len(cqes); head, n = head+1, n+1 {
+		cqes[n] = r.cqes[head&r.cqMask]
+	}
+	atomic.StoreUint32(r.cqHead, head)
+	return n
+}
+
+// Close unmaps the rings and closes the io_uring fd.
+func (r *uring) Close() error {
+	for _, mem := range [][]byte{r.sqeMem, r.cqMem, r.ringMem} {
+		if mem != nil {
+			syscall.Munmap(mem)
+		}
+	}
+	r.sqeMem, r.cqMem, r.ringMem = nil, nil, nil
+	return syscall.Close(r.fd)
+}
+
+// uringBufRing is a ring of provided buffers, from which the kernel picks a buffer for each recv.
+type uringBufRing struct {
+	mem     []byte // ring entries
+	bufs    []byte
+	bgid    uint16
+	entries uint16
+	size    int
+	tail    uint16
+}
+
+// registerBufRing registers a ring of entries buffers of size, entries must be the power of 2.
+func (r *uring) registerBufRing(bgid, entries uint16, size int) (br *uringBufRing, err error) {
+	br = &uringBufRing{bgid: bgid, entries: entries, size: size}
+	var flags = syscall.PROT_READ | syscall.PROT_WRITE
+	br.mem, err = syscall.Mmap(-1, 0, int(entries)*uringBufEntrySize, flags, syscall.MAP_ANONYMOUS|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, err
+	}
+	br.bufs, err = syscall.Mmap(-1, 0, int(entries)*size, flags, syscall.MAP_ANONYMOUS|syscall.MAP_PRIVATE)
+	if err != nil {
+		syscall.Munmap(br.mem)
+		return nil, err
+	}
+	var reg = uringBufReg{
+		ringAddr:    uint64(uintptr(unsafe.Pointer(&br.mem[0]))),
+		ringEntries: uint32(entries),
+		bgid:        bgid,
+	}
+	if err = r.register(IORING_REGISTER_PBUF_RING, unsafe.Pointer(&reg), 1); err != nil {
+		br.Close()
+		return nil, err
+	}
+	for bid := uint16(0); bid < entries; bid++ {
+		br.recycle(bid)
+	}
+	return br, nil
+}
+
+// buffer returns the data of n bytes received into the buffer bid.
+func (br *uringBufRing) buffer(bid uint16, n int) []byte {
+	var off = int(bid) * br.size
+	return br.bufs[off : off+n : off+br.size]
+}
+
+// recycle gives the buffer bid back to the kernel.
+func (br *uringBufRing) recycle(bid uint16) {
+	var entry = br.mem[int(br.tail&(br.entries-1))*uringBufEntrySize:]
+	*(*uint64)(unsafe.Pointer(&entry[0])) = uint64(uintptr(unsafe.Pointer(&br.bufs[int(bid)*br.size])))
+	*(*uint32)(unsafe.Pointer(&entry[8])) = uint32(br.size)
+	*(*uint16)(unsafe.Pointer(&entry[12])) = bid
+	br.tail++
+	// There is no 16-bit atomic store, so the tail is published together with the bid of
+	// the first entry which is only written by us, and it is little endian on linux of all supported arches.
+	var word = (*uint32)(unsafe.Pointer(&br.mem[uringBufTailOffset-2]))
+	atomic.StoreUint32(word, uint32(br.tail)<<16|atomic.LoadUint32(word)&0xffff)
+}
+
+// Close unmaps the buffers, which must be called after the io_uring is closed.
+func (br *uringBufRing) Close() {
+	syscall.Munmap(br.bufs)
+	syscall.Munmap(br.mem)
+}
+
+// sliceHeader points the slice at ptr to the memory of n elements.
+func sliceHeader(ptr, data unsafe.Pointer, n int) {
+	var sh = (*reflect.SliceHeader)(ptr)
+	sh.Data, sh.Len, sh.Cap = uintptr(data), n, n
+}
@@ -26,3 +26,9 @@ func setZeroCopy(fd int) error {
 func setBlockZeroCopySend(fd int, sec, usec int64) error {
 	return syscall.EINVAL
 }
+
+const zeroCopyOOBSize = 0
+
+func recvZeroCopyAck(fd int, oob []byte) (lo, hi uint32, err error) {
+	return 0, 0, syscall.EAGAIN
+}
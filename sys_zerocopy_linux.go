@@ -16,6 +16,7 @@ package netpoll
 
 import (
 	"syscall"
+	"unsafe"
 )
 
 const (
@@ -34,3 +35,56 @@ func setBlockZeroCopySend(fd int, sec, usec int64) error {
 		Usec: usec,
 	})
 }
+
+// soEEOriginZeroCopy is SO_EE_ORIGIN_ZEROCOPY, the origin of the completions of zerocopy sends.
+const soEEOriginZeroCopy = 5
+
+// sockExtendedErr is struct sock_extended_err in linux/errqueue.h.
+type sockExtendedErr struct {
+	Errno  uint32
+	Origin uint8
+	Type   uint8
+	Code   uint8
+	Pad    uint8
+	Info   uint32
+	Data   uint32
+}
+
+// zeroCopyOOBSize is enough to hold a cmsg of sock_extended_err and its offender address.
+const zeroCopyOOBSize = 128
+
+// recvZeroCopyAck reads a notification from the error queue of fd.
+// It returns the range [lo, hi] of the completed zerocopy sends, or an error if
+// the error queue is empty (EAGAIN) or contains a non-zerocopy error.
+func recvZeroCopyAck(fd int, oob []byte) (lo, hi uint32, err error) {
+	var msghdr = syscall.Msghdr{
+		Control: &oob[0],
+	}
+	msghdr.SetControllen(len(oob))
+	_, _, e := syscall.RawSyscall(syscall.SYS_RECVMSG, uintptr(fd), uintptr(unsafe.Pointer(&msghdr)), syscall.MSG_ERRQUEUE)
+	if e != 0 {
+		return 0, 0, syscall.Errno(e)
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:msghdr.Controllen])
+	if err != nil {
+		return 0, 0, err
+	}
+	for i := range msgs {
+		if !(msgs[i].Header.Level == syscall.SOL_IP && msgs[i].Header.Type == syscall.IP_RECVERR) &&
+			!(msgs[i].Header.Level == syscall.SOL_IPV6 && msgs[i].Header.Type == syscall.IPV6_RECVERR) {
+			continue
+		}
+		if len(msgs[i].Data) < int(unsafe.Sizeof(sockExtendedErr{})) {
+			continue
+		}
+		var serr = (*sockExtendedErr)(unsafe.Pointer(&msgs[i].Data[0]))
+		if serr.Origin != soEEOriginZeroCopy {
+			if serr.Errno != 0 {
+				return 0, 0, syscall.Errno(serr.Errno)
+			}
+			break
+		}
+		return serr.Info, serr.Data, nil
+	}
+	return 0, 0, syscall.EIO
+}